@@ -0,0 +1,111 @@
+package DeviceProfiles
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"serialAdapter/GenericSerial"
+)
+
+//DefaultProfileName : The profile used when adapter_settings does not specify one. This
+//matches the historical behavior of the adapter (enter serial data mode on connect)
+const DefaultProfileName = "xdot"
+
+//DeviceProfile : Device specific behavior invoked by the adapter at well defined points
+//of the serial port lifecycle
+type DeviceProfile interface {
+	//Name : The name the profile was registered with
+	Name() string
+
+	//Init : Prepares the device for data exchange. Invoked when the adapter first connects to the broker
+	//and again only after the device was shut down (ex. an exclusive session released the port)
+	Init(ctx context.Context, port *GenericSerial.SerialPort) error
+
+	//PreRead : Invoked before each read of the serial port
+	PreRead(ctx context.Context, port *GenericSerial.SerialPort) error
+
+	//PostWrite : Invoked after each write to the serial port
+	PostWrite(ctx context.Context, port *GenericSerial.SerialPort) error
+
+	//Shutdown : Returns the device to an idle state. Invoked when an exclusive session reserves the port and when the adapter exits
+	Shutdown(ctx context.Context, port *GenericSerial.SerialPort) error
+
+	//HealthCheck : Verifies the device is able to exchange data
+	HealthCheck(ctx context.Context, port *GenericSerial.SerialPort) error
+}
+
+//ProfileFactory : Creates a profile from the adapter_settings JSON object
+type ProfileFactory func(settings map[string]interface{}) (DeviceProfile, error)
+
+var (
+	registryLock = &sync.Mutex{}
+	registry     = map[string]ProfileFactory{}
+)
+
+//RegisterProfile : Makes a profile selectable by name. Registering the same name twice replaces the factory
+func RegisterProfile(name string, factory ProfileFactory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	registry[strings.ToLower(name)] = factory
+}
+
+//CreateProfile : Creates the profile registered under the given name
+func CreateProfile(name string, settings map[string]interface{}) (DeviceProfile, error) {
+	if name == "" {
+		name = DefaultProfileName
+	}
+
+	registryLock.Lock()
+	factory, ok := registry[strings.ToLower(name)]
+	registryLock.Unlock()
+
+	if !ok {
+		log.Printf("[ERROR] CreateProfile - Unknown device profile: %s\n", name)
+		return nil, fmt.Errorf("Unknown device profile %q, available profiles: %s", name, strings.Join(ProfileNames(), ", "))
+	}
+
+	if settings == nil {
+		settings = make(map[string]interface{})
+	}
+
+	log.Printf("[INFO] CreateProfile - Creating device profile %s\n", name)
+	return factory(settings)
+}
+
+//ProfileNames : The sorted names of all registered profiles
+func ProfileNames() []string {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//checkPortOpen : Basic health check shared by the profiles that cannot query the device
+func checkPortOpen(port *GenericSerial.SerialPort) error {
+	if port == nil || !port.IsOpen() {
+		return errors.New("Serial port is not open")
+	}
+	return nil
+}
+
+//settingString : Returns the adapter setting as a string, or the default value when it is not present
+func settingString(settings map[string]interface{}, key string, defaultValue string) string {
+	if value, ok := settings[key]; ok && value != nil {
+		if str, ok := value.(string); ok {
+			return str
+		}
+		return fmt.Sprint(value)
+	}
+	return defaultValue
+}
@@ -0,0 +1,67 @@
+package DeviceProfiles
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"serialAdapter/GenericSerial"
+)
+
+//ModbusRtuProfileName : Profile for Modbus RTU slaves
+const ModbusRtuProfileName = "modbus-rtu"
+
+//Above 19200 baud the Modbus specification uses a fixed inter-frame delay
+const modbusFixedFrameDelay = 1750 * time.Microsecond
+
+func init() {
+	RegisterProfile(ModbusRtuProfileName, func(settings map[string]interface{}) (DeviceProfile, error) {
+		return &modbusRtuProfile{}, nil
+	})
+}
+
+//modbusRtuProfile : Modbus RTU frames are delimited by silence on the line. The profile
+//guarantees the 3.5 character silent interval after every frame written to the port.
+type modbusRtuProfile struct{}
+
+func (profile *modbusRtuProfile) Name() string {
+	return ModbusRtuProfileName
+}
+
+func (profile *modbusRtuProfile) Init(ctx context.Context, port *GenericSerial.SerialPort) error {
+	return nil
+}
+
+func (profile *modbusRtuProfile) PreRead(ctx context.Context, port *GenericSerial.SerialPort) error {
+	return nil
+}
+
+func (profile *modbusRtuProfile) PostWrite(ctx context.Context, port *GenericSerial.SerialPort) error {
+	delay := modbusFrameDelay(port.BaudRate())
+	log.Printf("[DEBUG] modbusRtuProfile.PostWrite - Waiting %s for inter-frame delay\n", delay)
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (profile *modbusRtuProfile) Shutdown(ctx context.Context, port *GenericSerial.SerialPort) error {
+	return nil
+}
+
+func (profile *modbusRtuProfile) HealthCheck(ctx context.Context, port *GenericSerial.SerialPort) error {
+	return checkPortOpen(port)
+}
+
+//modbusFrameDelay : 3.5 character times, with 11 bits per character
+func modbusFrameDelay(baud int) time.Duration {
+	if baud <= 0 || baud > 19200 {
+		return modbusFixedFrameDelay
+	}
+	return time.Duration(float64(time.Second) * 3.5 * 11 / float64(baud))
+}
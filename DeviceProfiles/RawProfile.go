@@ -0,0 +1,42 @@
+package DeviceProfiles
+
+import (
+	"context"
+	"serialAdapter/GenericSerial"
+)
+
+//RawProfileName : Transparent profile for devices that do not need any initialization
+const RawProfileName = "raw"
+
+func init() {
+	RegisterProfile(RawProfileName, func(settings map[string]interface{}) (DeviceProfile, error) {
+		return &rawProfile{}, nil
+	})
+}
+
+//rawProfile : Shuttles bytes to and from the serial port without sending anything to the device
+type rawProfile struct{}
+
+func (profile *rawProfile) Name() string {
+	return RawProfileName
+}
+
+func (profile *rawProfile) Init(ctx context.Context, port *GenericSerial.SerialPort) error {
+	return nil
+}
+
+func (profile *rawProfile) PreRead(ctx context.Context, port *GenericSerial.SerialPort) error {
+	return nil
+}
+
+func (profile *rawProfile) PostWrite(ctx context.Context, port *GenericSerial.SerialPort) error {
+	return nil
+}
+
+func (profile *rawProfile) Shutdown(ctx context.Context, port *GenericSerial.SerialPort) error {
+	return nil
+}
+
+func (profile *rawProfile) HealthCheck(ctx context.Context, port *GenericSerial.SerialPort) error {
+	return checkPortOpen(port)
+}
@@ -0,0 +1,261 @@
+package DeviceProfiles
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"serialAdapter/GenericSerial"
+)
+
+//XDotProfileName : MultiTech xDot using serial data mode with the configuration already stored on the card
+const XDotProfileName = "xdot"
+
+//XDotPeerToPeerProfileName : MultiTech xDot configured for LoRa peer to peer mode
+const XDotPeerToPeerProfileName = "xdot-p2p"
+
+//XDotOtaaProfileName : MultiTech xDot joining a LoRaWAN public network with over the air activation
+const XDotOtaaProfileName = "xdot-otaa"
+
+// peer 2 peer mode adapter setting defaults
+const (
+	defaultNetworkAddress        = "00:11:22:33"
+	defaultNetworkSessionKey     = "00:11:22:33:00:11:22:33:00:11:22:33:00:11:22:33"
+	defaultNetworkDataKey        = "33:22:11:00:33:22:11:00:33:22:11:00:33:22:11:00"
+	defaultTransmissionDataRate  = "DR8"
+	defaultTransmissionFrequency = "915500000"
+)
+
+// public ota lora mode adapter setting defaults (empty strings are required adapter settings)
+const (
+	defaultNetworkID        = ""
+	defaultNetworkKey       = ""
+	defaultFrequencySubBand = "0"
+)
+
+func init() {
+	RegisterProfile(XDotProfileName, func(settings map[string]interface{}) (DeviceProfile, error) {
+		return &xDotProfile{name: XDotProfileName}, nil
+	})
+	RegisterProfile(XDotPeerToPeerProfileName, newXDotPeerToPeerProfile)
+	RegisterProfile(XDotOtaaProfileName, newXDotOtaaProfile)
+}
+
+//xDotProfile : Enters serial data mode on init and leaves it on shutdown. The xDot
+//card cannot answer AT commands while in serial data mode, so the health check
+//only verifies the port is open.
+type xDotProfile struct {
+	name string
+}
+
+//xDotSetting : An xDot configuration value that is only written when it differs from the value on the card
+type xDotSetting struct {
+	description string
+	apply       func() (bool, error)
+}
+
+func (profile *xDotProfile) Name() string {
+	return profile.name
+}
+
+func (profile *xDotProfile) Init(ctx context.Context, port *GenericSerial.SerialPort) error {
+	if err := profile.applyFlowControl(ctx, port); err != nil {
+		return err
+	}
+
+	log.Println("[DEBUG] xDotProfile.Init - Entering serial data mode...")
+	ctx, cancel := context.WithTimeout(ctx, GenericSerial.SerialDataModeTimeout*time.Second)
+	defer cancel()
+	return port.StartSerialDataMode(ctx)
+}
+
+func (profile *xDotProfile) PreRead(ctx context.Context, port *GenericSerial.SerialPort) error {
+	return nil
+}
+
+func (profile *xDotProfile) PostWrite(ctx context.Context, port *GenericSerial.SerialPort) error {
+	return nil
+}
+
+func (profile *xDotProfile) Shutdown(ctx context.Context, port *GenericSerial.SerialPort) error {
+	log.Println("[DEBUG] xDotProfile.Shutdown - Stopping serial data mode...")
+	ctx, cancel := context.WithTimeout(ctx, GenericSerial.SerialDataModeTimeout*time.Second)
+	defer cancel()
+	return port.StopSerialDataMode(ctx)
+}
+
+func (profile *xDotProfile) HealthCheck(ctx context.Context, port *GenericSerial.SerialPort) error {
+	return checkPortOpen(port)
+}
+
+//applyFlowControl : Matches the hardware flow control of the xDot to the serial port. The xDot has
+//no software flow control, its hardware flow control is disabled with xonxoff.
+func (profile *xDotProfile) applyFlowControl(ctx context.Context, port *GenericSerial.SerialPort) error {
+	hardware := port.LineSettings().FlowControl == GenericSerial.FlowRTSCTS
+	log.Printf("[INFO] %s - Setting hardware flow control to %t...\n", profile.name, hardware)
+
+	err := port.SetDeviceFlowControl(ctx, hardware)
+	if err != nil && !hardware {
+		//The port does not wait for CTS, the device setting makes no difference
+		log.Printf("[WARN] %s - Unable to disable hardware flow control: %s\n", profile.name, err.Error())
+		return nil
+	}
+	return err
+}
+
+//applySettings : Writes each setting to the xDot and saves the configuration if anything changed
+func (profile *xDotProfile) applySettings(ctx context.Context, port *GenericSerial.SerialPort, settings []xDotSetting) error {
+	configChanged := false
+
+	for _, setting := range settings {
+		log.Printf("[INFO] %s - Setting %s...\n", profile.name, setting.description)
+		valueChanged, err := setting.apply()
+		if err != nil {
+			log.Printf("[ERROR] %s - Error setting %s: %s\n", profile.name, setting.description, err.Error())
+			return err
+		}
+		if valueChanged {
+			configChanged = true
+		}
+	}
+
+	if configChanged {
+		log.Printf("[DEBUG] %s - xDot configuration changed, saving new values...\n", profile.name)
+		//Save the xDot configuration
+		if err := port.SaveConfiguration(ctx); err != nil {
+			log.Printf("[WARN] %s - Error saving xDot configuration: %s\n", profile.name, err.Error())
+		}
+		//Resetting the xDot CPU after saving appears to hang the card, so it is intentionally skipped
+	}
+	return nil
+}
+
+//xDotPeerToPeerProfile : http://www.multitech.net/developer/software/mdot-software/peer-to-peer/
+//
+// In order to get the xDot card in Peer to Peer mode, we need to write AT commands
+// to the serial port:
+//
+// AT+NJM=3 --> Set network join mode to peer to peer (3)
+// AT+NA=00:11:22:33 --> Set network address: Must be the same for all xDots. devAddr in LoraMac
+// AT+NSK=00:11:22:33:00:11:22:33:00:11:22:33:00:11:22:33 --> Set network session key: Must be the same for all xDots.
+// AT+DSK=33:22:11:00:33:22:11:00:33:22:11:00:33:22:11:00 --> Set data session key: Must be the same for all xDots.
+// AT+TXDR=DR8 (US:DR8-DR13,EU:DR0-DR6) --> Set the transmission data rate for all channels
+// AT+TXF=915500000 (US-ONLY:915.5-919.7) --> Set the transmission frequency
+// AT&W --> Save configuration to flash memory
+// AT+SD --> Serial Data Mode
+type xDotPeerToPeerProfile struct {
+	xDotProfile
+
+	networkAddress        string
+	networkSessionKey     string
+	networkDataKey        string
+	transmissionDataRate  string
+	transmissionFrequency string
+}
+
+func newXDotPeerToPeerProfile(settings map[string]interface{}) (DeviceProfile, error) {
+	profile := &xDotPeerToPeerProfile{
+		xDotProfile:           xDotProfile{name: XDotPeerToPeerProfileName},
+		networkAddress:        settingString(settings, "networkAddress", defaultNetworkAddress),
+		networkSessionKey:     settingString(settings, "networkSessionKey", defaultNetworkSessionKey),
+		networkDataKey:        settingString(settings, "networkDataKey", defaultNetworkDataKey),
+		transmissionDataRate:  settingString(settings, "transmissionDataRate", defaultTransmissionDataRate),
+		transmissionFrequency: settingString(settings, "transmissionFrequency", defaultTransmissionFrequency),
+	}
+
+	log.Printf("[DEBUG] newXDotPeerToPeerProfile - networkAddress = %s, transmissionDataRate = %s, transmissionFrequency = %s\n",
+		profile.networkAddress, profile.transmissionDataRate, profile.transmissionFrequency)
+	return profile, nil
+}
+
+func (profile *xDotPeerToPeerProfile) Init(ctx context.Context, port *GenericSerial.SerialPort) error {
+	err := profile.applySettings(ctx, port, []xDotSetting{
+		{"network join mode", func() (bool, error) { return port.SetNetworkJoinMode(ctx, GenericSerial.PeerToPeerMode) }},
+		{"device class", func() (bool, error) { return port.SetDeviceClass(ctx, GenericSerial.DeviceClassC) }},
+		{"network address", func() (bool, error) { return port.SetNetworkAddress(ctx, profile.networkAddress) }},
+		{"network session key", func() (bool, error) { return port.SetNetworkSessionKey(ctx, profile.networkSessionKey) }},
+		{"data session key", func() (bool, error) { return port.SetDataSessionKey(ctx, profile.networkDataKey) }},
+		{"transmission data rate", func() (bool, error) { return port.SetDataRate(ctx, profile.transmissionDataRate) }},
+		{"transmission frequency", func() (bool, error) { return port.SetFrequency(ctx, profile.transmissionFrequency) }},
+	})
+	if err != nil {
+		return err
+	}
+
+	return profile.xDotProfile.Init(ctx, port)
+}
+
+//xDotOtaaProfile : Joins a LoRaWAN public network
+//
+// AT+NJM=1
+// AT+NI=00-11-22-33-44-aa-bb-cc (from lora network server, all connecting lora devices use same)
+// AT+NK=00.11.22.33.44.55.66.77.88.99.aa.bb.cc.dd.ee.ff (from lora network server, all connecting lora devices use same)
+// AT+FSB=1 (based off lora network server, should come from config collection)
+// AT+TXDR=3 (could depend on solution, pulling from config collection)
+// save it!
+// join it!
+type xDotOtaaProfile struct {
+	xDotProfile
+
+	networkID            string
+	networkKey           string
+	frequencySubBand     string
+	transmissionDataRate string
+}
+
+func newXDotOtaaProfile(settings map[string]interface{}) (DeviceProfile, error) {
+	profile := &xDotOtaaProfile{
+		xDotProfile:          xDotProfile{name: XDotOtaaProfileName},
+		networkID:            settingString(settings, "networkID", defaultNetworkID),
+		networkKey:           settingString(settings, "networkKey", defaultNetworkKey),
+		frequencySubBand:     settingString(settings, "frequencySubBand", defaultFrequencySubBand),
+		transmissionDataRate: settingString(settings, "transmissionDataRate", defaultTransmissionDataRate),
+	}
+
+	if profile.networkID == "" {
+		log.Println("[ERROR] newXDotOtaaProfile - A networkID value is expected, using a bogus value that likely will not work")
+	}
+	if profile.networkKey == "" {
+		log.Println("[ERROR] newXDotOtaaProfile - A networkKey value is expected, using a bogus value that likely will not work")
+	}
+
+	log.Printf("[DEBUG] newXDotOtaaProfile - frequencySubBand = %s, transmissionDataRate = %s\n", profile.frequencySubBand, profile.transmissionDataRate)
+	return profile, nil
+}
+
+func (profile *xDotOtaaProfile) Init(ctx context.Context, port *GenericSerial.SerialPort) error {
+	err := profile.applySettings(ctx, port, []xDotSetting{
+		{"network join mode", func() (bool, error) { return port.SetNetworkJoinMode(ctx, GenericSerial.OtaJoinMode) }},
+		{"public network mode", func() (bool, error) { return port.SetPublicNetworkMode(ctx, GenericSerial.PublicLoRaWANNetworkMode) }},
+		{"device class", func() (bool, error) { return port.SetDeviceClass(ctx, GenericSerial.DeviceClassC) }},
+		{"network ID", func() (bool, error) { return port.SetNetworkID(ctx, profile.networkID) }},
+		{"network key", func() (bool, error) { return port.SetNetworkKey(ctx, profile.networkKey) }},
+		{"frequency sub-band", func() (bool, error) { return port.SetFrequencySubBand(ctx, profile.frequencySubBand) }},
+		{"transmission data rate", func() (bool, error) { return port.SetDataRate(ctx, profile.transmissionDataRate) }},
+	})
+	if err != nil {
+		return err
+	}
+
+	//Join network
+	log.Printf("[INFO] %s - Joining Network...\n", profile.name)
+	if err := port.JoinNetwork(ctx); err != nil {
+		return err
+	}
+
+	// For us to start receiving downlinks, we need to AT+SEND 2 empty messages...
+	//  http://www.multitech.net/developer/software/lora/class-c-walkthrough/
+	// 1. AT+SEND to acknowledge Join Accept.
+	// 2. AT+SEND to acknowledge first downlink MAC commands
+	log.Printf("[INFO] %s - AT+SEND to ack Join Accept\n", profile.name)
+	if err := port.SendData(ctx, ""); err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] %s - AT+SEND to ack first downlink MAC commands\n", profile.name)
+	if err := port.SendData(ctx, ""); err != nil {
+		return err
+	}
+
+	return profile.xDotProfile.Init(ctx, port)
+}
@@ -1,9 +1,12 @@
 package GenericSerial
 
 import (
+	"context"
 	"errors"
 	"log"
+	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/tarm/serial"
@@ -11,61 +14,168 @@ import (
 
 //SerialPort : Struct that represents the serial port used to interface with a serial device
 type SerialPort struct {
-	serialPort *serial.Port
+	serialPort Transport
 
 	//The OS name used to identify the port (/dev/ttyap1, COM1, etc)
 	portName string
 
-	//The baud rate of the serial port, default is 115200
-	baudRate int
+	//The baud rate and character format of the serial port, default is 115200 8N1
+	line LineSettings
 
 	//
 	timeout time.Duration
+
+	//Second descriptor used for line control ioctls (break, DTR, RTS, modem lines)
+	controlLock *sync.Mutex
+	controlFile *os.File
+
+	//Records the serial traffic when not nil
+	capture *CaptureSettings
+
+	//RS-485 direction control and echo suppression when not nil
+	rs485 *RS485Settings
+
+	//Recording played back instead of opening the port when not empty
+	replayPath  string
+	replaySpeed float64
+
+	//Receives a copy of every chunk read from the port when not nil
+	observerLock *sync.Mutex
+	readObserver func(data []byte)
 }
 
+//ErrSerialDataModeTimeout : Returned when the device does not enter or leave serial data mode before the deadline
+var ErrSerialDataModeTimeout = errors.New("Timed out changing serial data mode")
+
+var ErrReadUntilTimeout = errors.New("Timed out waiting for the expected response from the device")
+
 //CreateSerialPort :
 func CreateSerialPort(osName string, baud int, timeout time.Duration) *SerialPort {
 
-	var thePort = SerialPort{portName: osName, baudRate: baud, timeout: timeout}
+	var thePort = SerialPort{portName: osName, line: defaultLineSettings(baud), timeout: timeout, observerLock: &sync.Mutex{}, controlLock: &sync.Mutex{}}
 	return &thePort
 }
 
-func (serialDevice *SerialPort) OpenSerialPort() error {
-	log.Println("[DEBUG] OpenSerialPort - Opening serial port")
+//SetCapture : Records every read and write to a rotating capture file once the port is opened.
+//Must be called before OpenSerialPort.
+func (serialDevice *SerialPort) SetCapture(settings CaptureSettings) {
+	serialDevice.capture = &settings
+}
 
-	serialConfig := &serial.Config{Name: serialDevice.portName, Baud: serialDevice.baudRate, ReadTimeout: serialDevice.timeout}
+//SetReplay : Plays back the data received in a capture file instead of opening the port. A speed of
+//1 replays at the original speed, 10 ten times faster and 0 as fast as possible. Must be called
+//before OpenSerialPort.
+func (serialDevice *SerialPort) SetReplay(path string, speed float64) {
+	serialDevice.replayPath = path
+	serialDevice.replaySpeed = speed
+}
 
+func (serialDevice *SerialPort) OpenSerialPort() error {
+	var transport Transport
 	var err error
 
-	serialDevice.serialPort, err = serial.OpenPort(serialConfig)
+	if serialDevice.replayPath != "" {
+		log.Println("[DEBUG] OpenSerialPort - Opening recording " + serialDevice.replayPath)
+		transport, err = openReplayTransport(serialDevice.replayPath, serialDevice.replaySpeed, serialDevice.timeout)
+	} else {
+		log.Println("[DEBUG] OpenSerialPort - Opening serial port")
+		transport, err = serial.OpenPort(serialDevice.line.config(serialDevice.portName, serialDevice.timeout))
+	}
 	if err != nil {
 		log.Println("[ERROR] OpenSerialPort - Error opening serial port: " + err.Error())
 		return err
 	}
 
+	//tarm/serial opens the port without flow control
+	if serialDevice.line.FlowControl != FlowNone && serialDevice.replayPath == "" {
+		if err := serialDevice.applyFlowControl(); err != nil {
+			serialDevice.closeControlFd()
+			transport.Close()
+			return err
+		}
+	}
+
+	if serialDevice.rs485 != nil && serialDevice.replayPath == "" {
+		rs485, err := openRS485Transport(transport, serialDevice, *serialDevice.rs485)
+		if err != nil {
+			serialDevice.closeControlFd()
+			transport.Close()
+			return err
+		}
+		transport = rs485
+	}
+
+	if serialDevice.capture != nil {
+		recorder, err := openCaptureRecorder(*serialDevice.capture)
+		if err != nil {
+			transport.Close()
+			return err
+		}
+		transport = &captureTransport{Transport: transport, recorder: recorder}
+	}
+
+	serialDevice.serialPort = transport
 	log.Println("[INFO] OpenSerialPort - Serial port open")
 
 	return nil
 }
 
+//SetReadObserver : Calls observer with a copy of the data of every read, whoever reads the port.
+//A nil observer removes the current one. The observer must not block.
+func (serialDevice *SerialPort) SetReadObserver(observer func(data []byte)) {
+	serialDevice.observerLock.Lock()
+	defer serialDevice.observerLock.Unlock()
+
+	serialDevice.readObserver = observer
+}
+
+//IsReplaying : Returns true if a recording is played back instead of the port
+func (serial *SerialPort) IsReplaying() bool {
+	return serial.replayPath != ""
+}
+
 func (serial *SerialPort) CloseSerialPort() error {
 	var err error
 	log.Println("[DEBUG] CloseSerialPort - Closing serial port")
+	serial.closeControlFd()
 	err = serial.serialPort.Close()
 	if err != nil {
 		log.Println("[ERROR] CloseSerialPort - Error closing serial port: " + err.Error())
 		return err
 	}
+	serial.serialPort = nil
 	log.Println("[INFO] CloseSerialPort - Serial port closed")
 	return nil
 }
 
-//SendATCommand : send at command
-func (serial *SerialPort) SendATCommand(cmd string) (string, error) {
+//IsOpen : Returns true if the serial port has been opened and not closed
+func (serial *SerialPort) IsOpen() bool {
+	return serial.serialPort != nil
+}
+
+//PortName : The OS name used to identify the port
+func (serial *SerialPort) PortName() string {
+	return serial.portName
+}
+
+//BaudRate : The current baud rate of the port
+func (serial *SerialPort) BaudRate() int {
+	return serial.line.BaudRate
+}
+
+//SendATCommand : send at command. Returns the context error if the context is done before the response is read
+func (serial *SerialPort) SendATCommand(ctx context.Context, cmd string) (string, error) {
 	atCmd := cmd + "\r"
 
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	//Network keys are never written to the log
+	sensitive := isSecretATCommand(cmd)
+
 	//write to the serial port
-	log.Printf("[DEBUG] SendATCommand - Writing AT Command to serial port: %#v\n", atCmd)
+	log.Printf("[DEBUG] SendATCommand - Writing AT Command to serial port: %#v\n", redactATCommand(atCmd))
 
 	n, err := serial.serialPort.Write([]byte(atCmd))
 	if err != nil {
@@ -73,22 +183,35 @@ func (serial *SerialPort) SendATCommand(cmd string) (string, error) {
 		return "", err
 	}
 	if n == -1 {
-		log.Printf("[ERROR] SendATCommand - Bad return code received when executing AT command: %s\n", atCmd)
+		log.Printf("[ERROR] SendATCommand - Bad return code received when executing AT command: %s\n", redactATCommand(atCmd))
 		return "", errors.New("-1 return code received when executing AT command")
 	} else {
 		log.Printf("[DEBUG] SendATCommand - Number of bytes written: %d\n", n)
 	}
 
-	resp, err := serial.readCommandResponse()
+	resp, err := serial.readCommandResponse(ctx, sensitive)
 	if err != nil {
 		return "", err
 	}
 
-	log.Println("[DEBUG] SendATCommand - Returning AT command response: " + resp)
+	if sensitive {
+		log.Println("[DEBUG] SendATCommand - Returning AT command response: " + RedactSecret(resp))
+	} else {
+		log.Println("[DEBUG] SendATCommand - Returning AT command response: " + resp)
+	}
 	return resp, nil
 }
 
-func (serial *SerialPort) readCommandResponse() (string, error) {
+//readCommandResponse : Reads until the AT command completes. Read errors other than EOF are
+//returned. When sensitive is true the response is not written to the log.
+func (serial *SerialPort) readCommandResponse(ctx context.Context, sensitive bool) (string, error) {
+	logged := func(data string) string {
+		if sensitive {
+			return RedactSecret(data)
+		}
+		return data
+	}
+
 	sleepTime := 2500 * time.Millisecond
 
 	// Every AT command will return either "OK\r\n", "ERROR\r\n", or "CONNECT\r\n" (In the
@@ -100,15 +223,15 @@ func (serial *SerialPort) readCommandResponse() (string, error) {
 	resp := ""
 	numTries := 1
 
-	buff, err := serial.ReadSerialPort()
+	buff, err := serial.ReadSerialPort(ctx)
 	if err != nil && strings.Contains(err.Error(), "EOF") {
 		log.Println("[INFO] readCommandResponse - EOF Error reading serial data...")
 	} else if err != nil {
-		log.Println("[FATAL] readCommandResponse - Error Reading serial data response from serial port: " + err.Error())
-		panic(err.Error())
+		log.Println("[ERROR] readCommandResponse - Error Reading serial data response from serial port: " + err.Error())
+		return "", err
 	}
 	resp += buff
-	log.Printf("[DEBUG] readCommandResponse - Buffer read: %s\n", buff)
+	log.Printf("[DEBUG] readCommandResponse - Buffer read: %s\n", logged(buff))
 
 	for !strings.Contains(resp, AtCmdSuccessText) &&
 		!strings.Contains(resp, AtCmdErrorText) &&
@@ -116,40 +239,43 @@ func (serial *SerialPort) readCommandResponse() (string, error) {
 
 		log.Println("[DEBUG] readCommandResponse - Continuing to read response from serial port...")
 
-		time.Sleep(sleepTime)
+		if err := sleepContext(ctx, sleepTime); err != nil {
+			log.Println("[ERROR] readCommandResponse - Deadline reached waiting for AT command response")
+			return "", err
+		}
 
-		buff, err = serial.ReadSerialPort()
+		buff, err = serial.ReadSerialPort(ctx)
 		if err != nil && strings.Contains(err.Error(), "EOF") {
 			numTries++
 			continue
 		} else if err != nil {
-			log.Println("[FATAL] readCommandResponse - Error Reading serial data response from serial port: " + err.Error())
-			panic(err.Error())
+			log.Println("[ERROR] readCommandResponse - Error Reading serial data response from serial port: " + err.Error())
+			return "", err
 		}
-		log.Printf("[DEBUG] readCommandResponse - Buffer read: %s\n", buff)
+		log.Printf("[DEBUG] readCommandResponse - Buffer read: %s\n", logged(buff))
 		resp += buff
 		numTries++
 	}
 
 	if numTries > 5 {
-		log.Printf("[ERROR] readCommandResponse - Unable to read AT command response after %d milliseconds\n", sleepTime*5)
-		return "", errors.New("Unable to read response after " + string(sleepTime*5) + " milliseconds")
+		log.Printf("[ERROR] readCommandResponse - Unable to read AT command response after %s\n", sleepTime*5)
+		return "", errors.New("Unable to read response after " + (sleepTime * 5).String())
 	}
 
 	log.Println("[DEBUG] readCommandResponse - Finished retrieving AT command response")
 	if strings.Contains(resp, AtCmdErrorText) {
-		log.Println("[DEBUG] readCommandResponse - Error received executing AT command: " + resp)
+		log.Println("[DEBUG] readCommandResponse - Error received executing AT command: " + logged(resp))
 		return "", errors.New(resp)
 	}
 
-	log.Println("[DEBUG] readCommandResponse - Returning AT command response: " + resp)
+	log.Println("[DEBUG] readCommandResponse - Returning AT command response: " + logged(resp))
 	return resp, nil
 }
 
-func (serial *SerialPort) GetDeviceID() (string, error) {
+func (serial *SerialPort) GetDeviceID(ctx context.Context) (string, error) {
 	log.Println("[DEBUG] GetDeviceID - Retrieving device ID")
 
-	if response, err := serial.SendATCommand(DeviceIDCmd); err != nil {
+	if response, err := serial.SendATCommand(ctx, DeviceIDCmd); err != nil {
 		log.Println("[ERROR] GetDeviceID - Error retrieving network join mode: " + err.Error())
 		return "", err
 	} else {
@@ -157,10 +283,10 @@ func (serial *SerialPort) GetDeviceID() (string, error) {
 	}
 }
 
-func (serial *SerialPort) GetDeviceClass() (string, error) {
+func (serial *SerialPort) GetDeviceClass(ctx context.Context) (string, error) {
 	log.Println("[DEBUG] GetDeviceClass - Retrieving device class")
 
-	if response, err := serial.SendATCommand(DeviceClassCmd); err != nil {
+	if response, err := serial.SendATCommand(ctx, DeviceClassCmd); err != nil {
 		log.Println("[ERROR] GetDeviceClass - Error retrieving device class: " + err.Error())
 		return "", err
 	} else {
@@ -168,11 +294,11 @@ func (serial *SerialPort) GetDeviceClass() (string, error) {
 	}
 }
 
-func (serial *SerialPort) SetDeviceClass(devClass string) (bool, error) {
+func (serial *SerialPort) SetDeviceClass(ctx context.Context, devClass string) (bool, error) {
 	log.Println("[DEBUG] SetDeviceClass - Begin setting device class to " + devClass)
 
 	//Retrieve the current value. Don't update it if we don't have to
-	currentValue, err := serial.GetDeviceClass()
+	currentValue, err := serial.GetDeviceClass(ctx)
 
 	log.Println("[DEBUG] SetDeviceClass - Current value = " + currentValue)
 	log.Println("[DEBUG] SetDeviceClass - Value to set = " + devClass)
@@ -182,7 +308,7 @@ func (serial *SerialPort) SetDeviceClass(devClass string) (bool, error) {
 			log.Println("[ERROR] SetDeviceClass - Error retrieving device class: " + err.Error())
 			return false, err
 		} else {
-			if _, err := serial.SendATCommand(DeviceClassCmd + "=" + devClass); err != nil {
+			if _, err := serial.SendATCommand(ctx, DeviceClassCmd+"="+devClass); err != nil {
 				log.Println("[ERROR] SetDeviceClass - Error setting device class: " + err.Error())
 				return false, err
 			}
@@ -194,9 +320,9 @@ func (serial *SerialPort) SetDeviceClass(devClass string) (bool, error) {
 	return false, nil
 }
 
-func (serial *SerialPort) GetNetworkJoinMode() (string, error) {
+func (serial *SerialPort) GetNetworkJoinMode(ctx context.Context) (string, error) {
 	log.Println("[DEBUG] GetNetworkJoinMode - Retrieving network join mode")
-	if response, err := serial.SendATCommand(NetworkJoinModeCmd); err != nil {
+	if response, err := serial.SendATCommand(ctx, NetworkJoinModeCmd); err != nil {
 		log.Println("[ERROR] GetNetworkJoinMode - Error retrieving network join mode: " + err.Error())
 		return "", err
 	} else {
@@ -204,11 +330,11 @@ func (serial *SerialPort) GetNetworkJoinMode() (string, error) {
 	}
 }
 
-func (serial *SerialPort) SetNetworkJoinMode(mode string) (bool, error) {
+func (serial *SerialPort) SetNetworkJoinMode(ctx context.Context, mode string) (bool, error) {
 	log.Println("[DEBUG] SetNetworkJoinMode - Begin setting network join mode to " + mode)
 
 	//Retrieve the current value. Don't update it if we don't have to
-	currentValue, err := serial.GetNetworkJoinMode()
+	currentValue, err := serial.GetNetworkJoinMode(ctx)
 
 	log.Println("[DEBUG] SetNetworkJoinMode - Current value = " + currentValue)
 	log.Println("[DEBUG] SetNetworkJoinMode - Value to set = " + mode)
@@ -218,7 +344,7 @@ func (serial *SerialPort) SetNetworkJoinMode(mode string) (bool, error) {
 			log.Println("[ERROR] SetNetworkJoinMode - Error retrieving network join mode: " + err.Error())
 			return false, err
 		} else {
-			if _, err := serial.SendATCommand(NetworkJoinModeCmd + "=" + mode); err != nil {
+			if _, err := serial.SendATCommand(ctx, NetworkJoinModeCmd+"="+mode); err != nil {
 				log.Println("[ERROR] SetNetworkJoinMode - Error setting network join mode: " + err.Error())
 				return false, err
 			}
@@ -230,9 +356,9 @@ func (serial *SerialPort) SetNetworkJoinMode(mode string) (bool, error) {
 	return false, nil
 }
 
-func (serial *SerialPort) GetNetworkAddress() (string, error) {
+func (serial *SerialPort) GetNetworkAddress(ctx context.Context) (string, error) {
 	log.Println("[DEBUG] getNetworGetNetworkAddresskAddress - Retrieving network address")
-	if response, err := serial.SendATCommand(NetworkAddrCmd); err != nil {
+	if response, err := serial.SendATCommand(ctx, NetworkAddrCmd); err != nil {
 		log.Println("[ERROR] GetNetworkAddress - Error retrieving network address: " + err.Error())
 		return "", err
 	} else {
@@ -240,11 +366,11 @@ func (serial *SerialPort) GetNetworkAddress() (string, error) {
 	}
 }
 
-func (serial *SerialPort) SetNetworkAddress(address string) (bool, error) {
+func (serial *SerialPort) SetNetworkAddress(ctx context.Context, address string) (bool, error) {
 	log.Println("[DEBUG] SetNetworkAddress - Setting network address")
 
 	//Retrieve the current value. Don't update it if we don't have to
-	currentValue, err := serial.GetNetworkAddress()
+	currentValue, err := serial.GetNetworkAddress(ctx)
 
 	log.Println("[DEBUG] SetNetworkAddress - Current value = " + currentValue)
 	log.Println("[DEBUG] SetNetworkAddress - Value to set = " + address)
@@ -254,7 +380,7 @@ func (serial *SerialPort) SetNetworkAddress(address string) (bool, error) {
 			log.Println("[DEBUG] SetNetworkAddress - Error retrieving network address: " + err.Error())
 			return false, err
 		} else {
-			if _, err := serial.SendATCommand(NetworkAddrCmd + "=" + address); err != nil {
+			if _, err := serial.SendATCommand(ctx, NetworkAddrCmd+"="+address); err != nil {
 				log.Println("[ERROR] SetNetworkAddress - Error setting network address: " + err.Error())
 				return false, err
 			}
@@ -267,9 +393,9 @@ func (serial *SerialPort) SetNetworkAddress(address string) (bool, error) {
 	return false, nil
 }
 
-func (serial *SerialPort) GetNetworkSessionKey() (string, error) {
+func (serial *SerialPort) GetNetworkSessionKey(ctx context.Context) (string, error) {
 	log.Println("[DEBUG] GetNetworkSessionKey - Retrieving network session key")
-	if response, err := serial.SendATCommand(NetworkSessionKeyCmd); err != nil {
+	if response, err := serial.SendATCommand(ctx, NetworkSessionKeyCmd); err != nil {
 		log.Println("[ERROR] GetNetworkSessionKey - Error setting network session key: " + err.Error())
 		return "", err
 	} else {
@@ -277,38 +403,38 @@ func (serial *SerialPort) GetNetworkSessionKey() (string, error) {
 	}
 }
 
-func (serial *SerialPort) SetNetworkSessionKey(sessionKey string) (bool, error) {
+func (serial *SerialPort) SetNetworkSessionKey(ctx context.Context, sessionKey string) (bool, error) {
 	log.Println("[DEBUG] SetNetworkSessionKey - Setting network session key")
 	//Retrieve the current value. Don't update it if we don't have to
-	currentValue, err := serial.GetNetworkSessionKey()
+	currentValue, err := serial.GetNetworkSessionKey(ctx)
 
 	//Replace periods with colons in the returned value
 	currentValue = strings.Replace(currentValue, ".", ":", -1)
 
-	log.Println("[DEBUG] SetNetworkSessionKey - Current value = " + currentValue)
-	log.Println("[DEBUG] SetNetworkSessionKey - Value to set = " + sessionKey)
+	log.Println("[DEBUG] SetNetworkSessionKey - Current value = " + RedactSecret(currentValue))
+	log.Println("[DEBUG] SetNetworkSessionKey - Value to set = " + RedactSecret(sessionKey))
 
 	if err != nil || !strings.Contains(strings.ToLower(currentValue), strings.ToLower(sessionKey)) {
 		if err != nil {
 			log.Println("[ERROR] SetNetworkSessionKey - Error retrieving network session key: " + err.Error())
 			return false, err
 		} else {
-			if _, err := serial.SendATCommand(NetworkSessionKeyCmd + "=" + sessionKey); err != nil {
+			if _, err := serial.SendATCommand(ctx, NetworkSessionKeyCmd+"="+sessionKey); err != nil {
 				log.Println("[ERROR] SetNetworkSessionKey - Error setting network session key: " + err.Error())
 				return false, err
 			}
-			log.Println("[INFO] SetNetworkSessionKey - Network session key set to " + sessionKey)
+			log.Println("[INFO] SetNetworkSessionKey - Network session key set to " + RedactSecret(sessionKey))
 			return true, nil
 		}
 	}
 
-	log.Println("[INFO] SetNetworkSessionKey - Network session key (unchanged) = " + sessionKey)
+	log.Println("[INFO] SetNetworkSessionKey - Network session key (unchanged) = " + RedactSecret(sessionKey))
 	return false, nil
 }
 
-func (serial *SerialPort) GetDataSessionKey() (string, error) {
+func (serial *SerialPort) GetDataSessionKey(ctx context.Context) (string, error) {
 	log.Println("[DEBUG] GetDataSessionKey - Retrieving data session key")
-	if response, err := serial.SendATCommand(NetworkDataKeyCmd); err != nil {
+	if response, err := serial.SendATCommand(ctx, NetworkDataKeyCmd); err != nil {
 		log.Println("[ERROR] GetDataSessionKey - Error retrieving data session key" + err.Error())
 		return "", err
 	} else {
@@ -316,38 +442,38 @@ func (serial *SerialPort) GetDataSessionKey() (string, error) {
 	}
 }
 
-func (serial *SerialPort) SetDataSessionKey(dataKey string) (bool, error) {
+func (serial *SerialPort) SetDataSessionKey(ctx context.Context, dataKey string) (bool, error) {
 	log.Println("[DEBUG] SetDataSessionKey - Setting data session key")
 	//Retrieve the current value. Don't update it if we don't have to
-	currentValue, err := serial.GetDataSessionKey()
+	currentValue, err := serial.GetDataSessionKey(ctx)
 
 	//Replace periods with colons in the returned value
 	currentValue = strings.Replace(currentValue, ".", ":", -1)
 
-	log.Println("[DEBUG] SetDataSessionKey - Current value = " + currentValue)
-	log.Println("[DEBUG] SetDataSessionKey - Value to set = " + dataKey)
+	log.Println("[DEBUG] SetDataSessionKey - Current value = " + RedactSecret(currentValue))
+	log.Println("[DEBUG] SetDataSessionKey - Value to set = " + RedactSecret(dataKey))
 
 	if err != nil || !strings.Contains(strings.ToLower(currentValue), strings.ToLower(dataKey)) {
 		if err != nil {
 			log.Println("[ERROR] SetDataSessionKey - Error retrieving data session key")
 			return false, err
 		} else {
-			if _, err := serial.SendATCommand(NetworkDataKeyCmd + "=" + dataKey); err != nil {
+			if _, err := serial.SendATCommand(ctx, NetworkDataKeyCmd+"="+dataKey); err != nil {
 				log.Println("[ERROR] SetDataSessionKey - Error setting data session key")
 				return false, err
 			}
-			log.Println("[INFO] SetDataSessionKey - Data session key set to " + dataKey)
+			log.Println("[INFO] SetDataSessionKey - Data session key set to " + RedactSecret(dataKey))
 			return true, nil
 		}
 	}
 
-	log.Println("[INFO] SetDataSessionKey - Data session (unchanged) = " + dataKey)
+	log.Println("[INFO] SetDataSessionKey - Data session (unchanged) = " + RedactSecret(dataKey))
 	return false, nil
 }
 
-func (serial *SerialPort) GetDataRate() (string, error) {
+func (serial *SerialPort) GetDataRate(ctx context.Context) (string, error) {
 	log.Println("[DEBUG] GetDataRate - Retrieving data transmission rate")
-	if response, err := serial.SendATCommand(TransmissionDataRateCmd); err != nil {
+	if response, err := serial.SendATCommand(ctx, TransmissionDataRateCmd); err != nil {
 		log.Println("[ERROR] GetDataRate - Error retrieving data transmission rate")
 		return "", err
 	} else {
@@ -355,10 +481,10 @@ func (serial *SerialPort) GetDataRate() (string, error) {
 	}
 }
 
-func (serial *SerialPort) SetDataRate(dataRate string) (bool, error) {
+func (serial *SerialPort) SetDataRate(ctx context.Context, dataRate string) (bool, error) {
 	log.Println("[DEBUG] SetDataRate - Setting data transmission rate")
 	//Retrieve the current value. Don't update it if we don't have to
-	currentValue, err := serial.GetDataRate()
+	currentValue, err := serial.GetDataRate(ctx)
 
 	log.Println("[DEBUG] SetDataRate - Current value = " + currentValue)
 	log.Println("[DEBUG] SetDataRate - Value to set = " + dataRate)
@@ -368,7 +494,7 @@ func (serial *SerialPort) SetDataRate(dataRate string) (bool, error) {
 			log.Println("[DEBUG] SetDataRate - Error retrieving data transmission rate")
 			return false, err
 		} else {
-			if _, err := serial.SendATCommand(TransmissionDataRateCmd + "=" + dataRate); err != nil {
+			if _, err := serial.SendATCommand(ctx, TransmissionDataRateCmd+"="+dataRate); err != nil {
 				log.Println("[DEBUG] SetDataRate - Error setting data transmission rate")
 				return false, err
 			}
@@ -381,9 +507,9 @@ func (serial *SerialPort) SetDataRate(dataRate string) (bool, error) {
 	return false, nil
 }
 
-func (serial *SerialPort) GetFrequency() (string, error) {
+func (serial *SerialPort) GetFrequency(ctx context.Context) (string, error) {
 	log.Println("[DEBUG] GetFrequency - Retrieving frequency")
-	if response, err := serial.SendATCommand(TransmissionFrequencyCmd); err != nil {
+	if response, err := serial.SendATCommand(ctx, TransmissionFrequencyCmd); err != nil {
 		log.Println("[ERROR] GetFrequency - Error retrieving frequency: " + err.Error())
 		return "", err
 	} else {
@@ -391,10 +517,10 @@ func (serial *SerialPort) GetFrequency() (string, error) {
 	}
 }
 
-func (serial *SerialPort) SetFrequency(freq string) (bool, error) {
+func (serial *SerialPort) SetFrequency(ctx context.Context, freq string) (bool, error) {
 	log.Println("[DEBUG] SetFrequency - Setting data transmission frequency")
 	//Retrieve the current value. Don't update it if we don't have to
-	currentValue, err := serial.GetFrequency()
+	currentValue, err := serial.GetFrequency(ctx)
 
 	log.Println("[DEBUG] SetFrequency - Current value = " + currentValue)
 	log.Println("[DEBUG] SetFrequency - Value to set = " + freq)
@@ -404,7 +530,7 @@ func (serial *SerialPort) SetFrequency(freq string) (bool, error) {
 			log.Println("[ERROR] SetFrequency - Error retrieving data transmission frequency: " + err.Error())
 			return false, err
 		} else {
-			if _, err := serial.SendATCommand(TransmissionFrequencyCmd + "=" + freq); err != nil {
+			if _, err := serial.SendATCommand(ctx, TransmissionFrequencyCmd+"="+freq); err != nil {
 				log.Println("[ERROR] SetFrequency - Error setting data transmission frequency: " + err.Error())
 				return false, err
 			}
@@ -416,9 +542,9 @@ func (serial *SerialPort) SetFrequency(freq string) (bool, error) {
 	return false, nil
 }
 
-func (serial *SerialPort) GetPublicNetworkMode() (string, error) {
+func (serial *SerialPort) GetPublicNetworkMode(ctx context.Context) (string, error) {
 	log.Println("[DEBUG] GetPublicNetworkMode - Retrieving public network mode")
-	if response, err := serial.SendATCommand(PublicNetworkModeCmd); err != nil {
+	if response, err := serial.SendATCommand(ctx, PublicNetworkModeCmd); err != nil {
 		log.Println("[ERROR] GetPublicNetworkMode - Error retrieving public network mode: " + err.Error())
 		return "", err
 	} else {
@@ -426,9 +552,9 @@ func (serial *SerialPort) GetPublicNetworkMode() (string, error) {
 	}
 }
 
-func (serial *SerialPort) SetPublicNetworkMode(mode string) (bool, error) {
+func (serial *SerialPort) SetPublicNetworkMode(ctx context.Context, mode string) (bool, error) {
 	log.Println("[DEBUG] SetPublicNetworkMode - Setting Public Network Mode")
-	currentValue, err := serial.GetPublicNetworkMode()
+	currentValue, err := serial.GetPublicNetworkMode(ctx)
 	if err != nil {
 		log.Println("[ERROR] SetPublicNetworkMode - Error retrieving: " + err.Error())
 		return false, err
@@ -439,7 +565,7 @@ func (serial *SerialPort) SetPublicNetworkMode(mode string) (bool, error) {
 		log.Println("[INFO] SetPublicNetworkMode - Unchanged = " + mode)
 		return false, nil
 	}
-	if _, err := serial.SendATCommand(NetworkIdCmd + "=0," + mode); err != nil {
+	if _, err := serial.SendATCommand(ctx, NetworkIdCmd+"=0,"+mode); err != nil {
 		log.Println("[ERROR] SetPublicNetworkMode - Error setting: " + err.Error())
 		return false, err
 	}
@@ -447,9 +573,9 @@ func (serial *SerialPort) SetPublicNetworkMode(mode string) (bool, error) {
 	return true, nil
 }
 
-func (serial *SerialPort) GetNetworkID() (string, error) {
+func (serial *SerialPort) GetNetworkID(ctx context.Context) (string, error) {
 	log.Println("[DEBUG] GetNetworkID - Retrieving Network ID")
-	if response, err := serial.SendATCommand(NetworkIdCmd); err != nil {
+	if response, err := serial.SendATCommand(ctx, NetworkIdCmd); err != nil {
 		log.Println("[ERROR] GetNetworkID - Error retrieving Network ID: " + err.Error())
 		return "", err
 	} else {
@@ -457,9 +583,9 @@ func (serial *SerialPort) GetNetworkID() (string, error) {
 	}
 }
 
-func (serial *SerialPort) SetNetworkID(id string) (bool, error) {
+func (serial *SerialPort) SetNetworkID(ctx context.Context, id string) (bool, error) {
 	log.Println("[DEBUG] SetNetworkID - Setting Network ID")
-	currentValue, err := serial.GetNetworkID()
+	currentValue, err := serial.GetNetworkID(ctx)
 	if err != nil {
 		log.Println("[ERROR] SetNetworkID - Error retrieving: " + err.Error())
 		return false, err
@@ -470,7 +596,7 @@ func (serial *SerialPort) SetNetworkID(id string) (bool, error) {
 		log.Println("[INFO] SetNetworkID - Unchanged = " + id)
 		return false, nil
 	}
-	if _, err := serial.SendATCommand(NetworkIdCmd + "=0," + id); err != nil {
+	if _, err := serial.SendATCommand(ctx, NetworkIdCmd+"=0,"+id); err != nil {
 		log.Println("[ERROR] SetNetworkID - Error setting: " + err.Error())
 		return false, err
 	}
@@ -478,9 +604,9 @@ func (serial *SerialPort) SetNetworkID(id string) (bool, error) {
 	return true, nil
 }
 
-func (serial *SerialPort) GetNetworkKey() (string, error) {
+func (serial *SerialPort) GetNetworkKey(ctx context.Context) (string, error) {
 	log.Println("[DEBUG] GetNetworkKey - Retrieving Network Key")
-	if response, err := serial.SendATCommand(NetworkKeyCmd); err != nil {
+	if response, err := serial.SendATCommand(ctx, NetworkKeyCmd); err != nil {
 		log.Println("[ERROR] GetNetworkKey - Error retrieving: " + err.Error())
 		return "", err
 	} else {
@@ -488,30 +614,30 @@ func (serial *SerialPort) GetNetworkKey() (string, error) {
 	}
 }
 
-func (serial *SerialPort) SetNetworkKey(key string) (bool, error) {
+func (serial *SerialPort) SetNetworkKey(ctx context.Context, key string) (bool, error) {
 	log.Println("[DEBUG] SetNetworkKey - Setting Network Key")
-	currentValue, err := serial.GetNetworkKey()
+	currentValue, err := serial.GetNetworkKey(ctx)
 	if err != nil {
 		log.Println("[ERROR] SetNetworkKey - Error retrieving: " + err.Error())
 		return false, err
 	}
-	log.Println("[DEBUG] SetNetworkKey - Current value = " + currentValue)
-	log.Println("[DEBUG] SetNetworkKey - Value to set = " + key)
+	log.Println("[DEBUG] SetNetworkKey - Current value = " + RedactSecret(currentValue))
+	log.Println("[DEBUG] SetNetworkKey - Value to set = " + RedactSecret(key))
 	if currentValue == key {
-		log.Println("[INFO] SetNetworkKey - Unchanged = " + key)
+		log.Println("[INFO] SetNetworkKey - Unchanged = " + RedactSecret(key))
 		return false, nil
 	}
-	if _, err := serial.SendATCommand(NetworkKeyCmd + "=0," + key); err != nil {
+	if _, err := serial.SendATCommand(ctx, NetworkKeyCmd+"=0,"+key); err != nil {
 		log.Println("[ERROR] SetNetworkKey - Error setting: " + err.Error())
 		return false, err
 	}
-	log.Println("[INFO] SetNetworkKey - Set to " + key)
+	log.Println("[INFO] SetNetworkKey - Set to " + RedactSecret(key))
 	return true, nil
 }
 
-func (serial *SerialPort) GetFrequencySubBand() (string, error) {
+func (serial *SerialPort) GetFrequencySubBand(ctx context.Context) (string, error) {
 	log.Println("[DEBUG] GetFrequencySubBand - Retrieving Frequency Sub-Band")
-	if response, err := serial.SendATCommand(FrequencySubBandCmd); err != nil {
+	if response, err := serial.SendATCommand(ctx, FrequencySubBandCmd); err != nil {
 		log.Println("[ERROR] GetFrequencySubBand - Error retrieving: " + err.Error())
 		return "", err
 	} else {
@@ -519,9 +645,9 @@ func (serial *SerialPort) GetFrequencySubBand() (string, error) {
 	}
 }
 
-func (serial *SerialPort) SetFrequencySubBand(subBand string) (bool, error) {
+func (serial *SerialPort) SetFrequencySubBand(ctx context.Context, subBand string) (bool, error) {
 	log.Println("[DEBUG] SetFrequencySubBand - Setting Frequency Sub-Band")
-	currentValue, err := serial.GetFrequencySubBand()
+	currentValue, err := serial.GetFrequencySubBand(ctx)
 	if err != nil {
 		log.Println("[ERROR] SetFrequencySubBand - Error retrieving: " + err.Error())
 		return false, err
@@ -532,7 +658,7 @@ func (serial *SerialPort) SetFrequencySubBand(subBand string) (bool, error) {
 		log.Println("[INFO] SetFrequencySubBand - Unchanged = " + subBand)
 		return false, nil
 	}
-	if _, err := serial.SendATCommand(FrequencySubBandCmd + "=" + subBand); err != nil {
+	if _, err := serial.SendATCommand(ctx, FrequencySubBandCmd+"="+subBand); err != nil {
 		log.Println("[ERROR] SetFrequencySubBand - Error setting: " + err.Error())
 		return false, err
 	}
@@ -540,46 +666,63 @@ func (serial *SerialPort) SetFrequencySubBand(subBand string) (bool, error) {
 	return true, nil
 }
 
-func (serial *SerialPort) JoinNetwork() error {
+func (serial *SerialPort) JoinNetwork(ctx context.Context) error {
 	log.Println("[DEBUG] JoinNetwork - Joining Network")
-	if _, err := serial.SendATCommand(NetworkJoinCmd); err != nil {
+	if _, err := serial.SendATCommand(ctx, NetworkJoinCmd); err != nil {
 		log.Println("[ERROR] JoinNetwork - Failed to join network: " + err.Error())
 		return err
 	}
 	return nil
 }
 
-func (serial *SerialPort) SendData(data string) error {
+func (serial *SerialPort) SendData(ctx context.Context, data string) error {
 	log.Println("[DEBUG] SendData - Sending Data")
 	command := SendDataCmd
 	if data != "" {
 		command += data
 	}
-	if _, err := serial.SendATCommand(command); err != nil {
+	if _, err := serial.SendATCommand(ctx, command); err != nil {
 		log.Println("[ERROR] SendData - failed to send data: " + err.Error())
 		return err
 	}
 	return nil
 }
 
-func (serial *SerialPort) SaveConfiguration() error {
+func (serial *SerialPort) SaveConfiguration(ctx context.Context) error {
 	log.Println("[INFO] SaveConfiguration - Saving serial configuration")
-	if _, err := serial.SendATCommand(SaveConfigurationCmd); err != nil {
+	if _, err := serial.SendATCommand(ctx, SaveConfigurationCmd); err != nil {
 		log.Println("[ERROR] SaveConfiguration - Error saving serial configuration: " + err.Error())
 		return err
 	}
 	return nil
 }
 
-func (serial *SerialPort) ResetSerialCPU() error {
+//SetDeviceFlowControl : Enables (AT&K3) or disables (AT&K0) hardware flow control on the device
+func (serial *SerialPort) SetDeviceFlowControl(ctx context.Context, enabled bool) error {
+	cmd := DisableHWFlowControlCmd
+	if enabled {
+		cmd = EnableHWFlowControlCmd
+	}
+
+	log.Printf("[DEBUG] SetDeviceFlowControl - Setting device hardware flow control to %t\n", enabled)
+	if _, err := serial.SendATCommand(ctx, cmd); err != nil {
+		log.Println("[ERROR] SetDeviceFlowControl - Error setting device hardware flow control: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+func (serial *SerialPort) ResetSerialCPU(ctx context.Context) error {
 	log.Println("[DEBUG] ResetSerialCPU - Resetting the CPU")
-	if _, err := serial.SendATCommand(ResetCPUCmd); err != nil {
+	if _, err := serial.SendATCommand(ctx, ResetCPUCmd); err != nil {
 		log.Println("[ERROR] ResetSerialCPU - Error resetting CPU: " + err.Error())
 		return err
 	}
 
 	//Wait a few seconds and then continually try to open the port
-	time.Sleep(3 * time.Second)
+	if err := sleepContext(ctx, 3*time.Second); err != nil {
+		return err
+	}
 
 	//This may not be necessary
 	// err := serial.OpenSerialPort()
@@ -601,12 +744,23 @@ func (serial *SerialPort) ResetSerialCPU() error {
 	return nil
 }
 
-func (serial *SerialPort) StartSerialDataMode() error {
+//StartSerialDataMode : Enters serial data mode. Returns ErrSerialDataModeTimeout if the
+//device has not answered with CONNECT before the context is done.
+func (serial *SerialPort) StartSerialDataMode(ctx context.Context) error {
 	log.Println("[INFO] StartSerialDataMode - Starting serial data mode")
 
 	loop := true
 	for loop {
-		if response, err := serial.SendATCommand(SerialDataModeCmd); err != nil {
+		if ctx.Err() != nil {
+			log.Println("[ERROR] StartSerialDataMode - Timed out starting serial data mode")
+			return ErrSerialDataModeTimeout
+		}
+
+		if response, err := serial.SendATCommand(ctx, SerialDataModeCmd); err != nil {
+			if ctx.Err() != nil {
+				log.Println("[ERROR] StartSerialDataMode - Timed out starting serial data mode")
+				return ErrSerialDataModeTimeout
+			}
 			log.Println("[ERROR] StartSerialDataMode - Error starting serial data mode: " + err.Error())
 			return err
 		} else {
@@ -623,37 +777,90 @@ func (serial *SerialPort) StartSerialDataMode() error {
 	return nil
 }
 
-func (serial *SerialPort) StopSerialDataMode() error {
+//StopSerialDataMode : Leaves serial data mode. The escape sequence is tried first. If the
+//device does not respond, a break signal and then a DTR toggle are sent before giving up.
+//Returns ErrSerialDataModeTimeout if the device never responds or the context is done.
+//
+//Each attempt has its own deadline, and the escape sequence is only retried while the context
+//leaves enough time for the break and DTR steps.
+func (serial *SerialPort) StopSerialDataMode(ctx context.Context) error {
 	log.Println("[INFO] StopSerialDataMode - Stopping serial data mode")
 
-	err := errors.New("")
-	for err != nil {
-		//Flush serial port before attempting to exit serial data mode
-		if err := serial.FlushSerialPort(); err != nil {
-			log.Println("[ERROR] StopSerialDataMode - Error flushing serial port: " + err.Error())
-		}
+	escalations := []struct {
+		description string
+		attempts    int
+		signal      func() error
+	}{
+		{"escape sequence", StopSerialDataModeAttempts, nil},
+		{"break signal", 1, serial.SendBreak},
+		{"DTR toggle", 1, func() error { return serial.ToggleDTR(DtrToggleDelay * time.Millisecond) }},
+	}
 
-		//Send stop command to the serial port
-		if err = serial.sendStopCommand(); err != nil {
-			log.Println("[ERROR] StopSerialDataMode - Error sending stop command: " + err.Error())
-			continue
+	//A read started just before the attempt deadline can block for the port timeout
+	attemptTimeout := StopSerialDataModeAttemptTimeout * time.Second
+	attemptBudget := attemptTimeout + serial.timeout
+
+	for index, escalation := range escalations {
+		if escalation.signal != nil {
+			log.Printf("[WARN] StopSerialDataMode - Device not responding, sending %s\n", escalation.description)
+			if err := escalation.signal(); err != nil {
+				log.Printf("[WARN] StopSerialDataMode - Unable to send %s: %s\n", escalation.description, err.Error())
+				continue
+			}
 		}
 
-		//Wait a few seconds and then continually try to read from the serial port
-		time.Sleep(1 * time.Second)
+		for attempt := 0; attempt < escalation.attempts; attempt++ {
+			if ctx.Err() != nil {
+				log.Println("[ERROR] StopSerialDataMode - Timed out stopping serial data mode")
+				return ErrSerialDataModeTimeout
+			}
 
-		_, err = serial.readCommandResponse()
+			remaining := len(escalations) - 1 - index
+			if deadline, ok := ctx.Deadline(); ok && attempt > 0 && remaining > 0 &&
+				time.Until(deadline) < time.Duration(remaining+1)*attemptBudget {
+				log.Printf("[WARN] StopSerialDataMode - Keeping the remaining time for the %s\n", escalations[index+1].description)
+				break
+			}
 
-		//Ignore "command not found" errors. These indicate the device is not in serial data mode
-		if err != nil && strings.Contains(err.Error(), "Command not found!") {
-			break
+			attemptCtx, cancel := context.WithTimeout(ctx, attemptTimeout)
+			stopped := serial.tryStopSerialDataMode(attemptCtx)
+			cancel()
+			if stopped {
+				return nil
+			}
 		}
 	}
 
-	return nil
+	log.Println("[ERROR] StopSerialDataMode - Device did not leave serial data mode")
+	return ErrSerialDataModeTimeout
 }
 
-func (serial *SerialPort) sendStopCommand() error {
+//tryStopSerialDataMode : Sends the escape sequence once. Returns true if the device answered
+//with either OK or "Command not found!", which indicates it is not in serial data mode.
+func (serial *SerialPort) tryStopSerialDataMode(ctx context.Context) bool {
+	//Flush serial port before attempting to exit serial data mode
+	if err := serial.FlushSerialPort(); err != nil {
+		log.Println("[ERROR] StopSerialDataMode - Error flushing serial port: " + err.Error())
+	}
+
+	//Send stop command to the serial port
+	if err := serial.sendStopCommand(ctx); err != nil {
+		log.Println("[ERROR] StopSerialDataMode - Error sending stop command: " + err.Error())
+		return false
+	}
+
+	//Wait a few seconds and then continually try to read from the serial port
+	if err := sleepContext(ctx, 1*time.Second); err != nil {
+		return false
+	}
+
+	_, err := serial.readCommandResponse(ctx, false)
+
+	//Ignore "command not found" errors. These indicate the device is not in serial data mode
+	return err == nil || strings.Contains(err.Error(), "Command not found!")
+}
+
+func (serial *SerialPort) sendStopCommand(ctx context.Context) error {
 	log.Println("[INFO] sendStopCommand - Sending stop command")
 
 	//This appears to be the only way to terminate serial data mode reliably.
@@ -666,11 +873,15 @@ func (serial *SerialPort) sendStopCommand() error {
 			log.Printf("[DEBUG] sendStopCommand - Number of bytes written: %d\n", n)
 		}
 
-		time.Sleep(SendStopDelay * time.Millisecond)
+		if err := sleepContext(ctx, SendStopDelay*time.Millisecond); err != nil {
+			return err
+		}
 	}
 
 	//The carriage return needs to be sent in the event that serial data mode is not active
-	time.Sleep(SendStopCarriageReturnDelay * time.Millisecond)
+	if err := sleepContext(ctx, SendStopCarriageReturnDelay*time.Millisecond); err != nil {
+		return err
+	}
 	log.Println("[INFO] sendStopCommand - Sending carriage return")
 	if n, err := serial.serialPort.Write([]byte("\r")); err != nil {
 		log.Println("[ERROR] sendStopCommand - Error writing \r to serial port: " + err.Error())
@@ -681,7 +892,14 @@ func (serial *SerialPort) sendStopCommand() error {
 	return nil
 }
 
-func (serial *SerialPort) ReadSerialPort() (string, error) {
+//ReadSerialPort : Reads the data currently available on the serial port. A read blocks for at
+//most the port timeout, the context is checked before the read is started.
+func (serial *SerialPort) ReadSerialPort(ctx context.Context) (string, error) {
+	if err := ctx.Err(); err != nil {
+		log.Println("[DEBUG] readSerialPort - Read cancelled: " + err.Error())
+		return "", err
+	}
+
 	buff := make([]byte, 128)
 	n, err := serial.serialPort.Read(buff)
 
@@ -695,18 +913,68 @@ func (serial *SerialPort) ReadSerialPort() (string, error) {
 	}
 
 	log.Printf("[DEBUG] readSerialPort - Number of bytes read: %d\n", n)
+
+	serial.observerLock.Lock()
+	if serial.readObserver != nil && n > 0 {
+		serial.readObserver(append([]byte(nil), buff[:n]...))
+	}
+	serial.observerLock.Unlock()
+
 	return string(buff[:n]), nil
 }
 
-func (serial *SerialPort) WriteSerialPort(data string) error {
-	n, err := serial.serialPort.Write([]byte(data))
-	if err != nil {
-		log.Printf("[ERROR] WriteSerialPort - ERROR writing to serial port: %s\n", err.Error())
-		return err
-	} else {
-		log.Printf("[DEBUG] SendATCommand - Number of bytes written: %d\n", n)
+//ReadUntil : Reads from the serial port until match is received or ctx is done. Returns the data
+//received before and after match. If ctx is done first, all of the data received is returned as
+//before along with ErrReadUntilTimeout.
+func (serial *SerialPort) ReadUntil(ctx context.Context, match string) (string, string, error) {
+	received := ""
+
+	for {
+		if index := strings.Index(received, match); index >= 0 {
+			log.Printf("[DEBUG] ReadUntil - Expected response received after %d bytes\n", index+len(match))
+			return received[:index], received[index+len(match):], nil
+		}
+
+		if ctx.Err() != nil {
+			log.Printf("[DEBUG] ReadUntil - Expected response not received, %d bytes read\n", len(received))
+			return received, "", ErrReadUntilTimeout
+		}
+
+		buff, err := serial.ReadSerialPort(ctx)
+		if err != nil && ctx.Err() == nil && !strings.Contains(err.Error(), "EOF") {
+			return received + buff, "", err
+		}
+		received += buff
 	}
-	return nil
+}
+
+//WriteSerialPort : Writes the data to the serial port in chunks, checking the context
+//between chunks so a cancelled write stops at a chunk boundary. Returns the number of bytes written.
+func (serial *SerialPort) WriteSerialPort(ctx context.Context, data string) (int, error) {
+	buff := []byte(data)
+	written := 0
+
+	for written < len(buff) {
+		if err := ctx.Err(); err != nil {
+			log.Printf("[ERROR] WriteSerialPort - Write cancelled after %d of %d bytes: %s\n", written, len(buff), err.Error())
+			return written, err
+		}
+
+		end := written + WriteChunkSize
+		if end > len(buff) {
+			end = len(buff)
+		}
+
+		n, err := serial.serialPort.Write(buff[written:end])
+		if err != nil {
+			log.Printf("[ERROR] WriteSerialPort - ERROR writing to serial port: %s\n", err.Error())
+			return written + n, err
+		}
+		written += n
+	}
+
+	log.Printf("[DEBUG] WriteSerialPort - Number of bytes written: %d\n", written)
+	return written, nil
 }
 
 func (serial *SerialPort) FlushSerialPort() error {
@@ -748,6 +1016,23 @@ func (serial *SerialPort) ExtractResponseData(atCmd string, cmdResp string) stri
 	//Remove any line feeds
 	parsedResp = strings.Replace(parsedResp, "\n", "", -1)
 
-	log.Println("[DEBUG] extractResponseData - " + parsedResp + " extracted from serial response")
+	if isSecretATCommand(atCmd) {
+		log.Println("[DEBUG] extractResponseData - " + RedactSecret(parsedResp) + " extracted from serial response")
+	} else {
+		log.Println("[DEBUG] extractResponseData - " + parsedResp + " extracted from serial response")
+	}
 	return parsedResp
 }
+
+//sleepContext : Sleeps for the given duration, returning early with the context error if the context is done
+func sleepContext(ctx context.Context, duration time.Duration) error {
+	timer := time.NewTimer(duration)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
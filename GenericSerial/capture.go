@@ -0,0 +1,178 @@
+package GenericSerial
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+//Directions of a CaptureRecord
+const (
+	CaptureReceived = "rx" //Read from the device
+	CaptureSent     = "tx" //Written to the device
+)
+
+//Capture file rotation defaults
+const (
+	DefaultCaptureMaxSize  = 10 * 1024 * 1024 //bytes
+	DefaultCaptureMaxFiles = 5
+)
+
+//CaptureRecord : One line of a capture file. Capture files contain one JSON object per line:
+//
+// {"time": "2026-01-01T00:00:00.123456789Z", "direction": "rx", "data": "VD0yMS40DQo="}
+//
+// data holds the bytes read or written, base64 encoded. Records are written in the order the
+// reads and writes completed.
+type CaptureRecord struct {
+	Time      time.Time `json:"time"`
+	Direction string    `json:"direction"`
+	Data      []byte    `json:"data"`
+}
+
+//CaptureSettings : Where the serial traffic is recorded. When the file reaches MaxSize bytes it is
+//renamed to Path.1, the previous Path.1 to Path.2 and so on, keeping at most MaxFiles old files.
+type CaptureSettings struct {
+	Path     string
+	MaxSize  int64
+	MaxFiles int
+}
+
+//captureRecorder : Appends capture records to a rotating file
+type captureRecorder struct {
+	lock     sync.Mutex
+	settings CaptureSettings
+	file     *os.File
+	size     int64
+}
+
+func openCaptureRecorder(settings CaptureSettings) (*captureRecorder, error) {
+	if settings.MaxSize <= 0 {
+		settings.MaxSize = DefaultCaptureMaxSize
+	}
+	if settings.MaxFiles < 0 {
+		settings.MaxFiles = 0
+	}
+
+	recorder := &captureRecorder{settings: settings}
+	if err := recorder.open(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("[INFO] openCaptureRecorder - Capturing serial traffic to %s\n", settings.Path)
+	return recorder, nil
+}
+
+func (recorder *captureRecorder) open() error {
+	file, err := os.OpenFile(recorder.settings.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		log.Println("[ERROR] captureRecorder - Error opening capture file: " + err.Error())
+		return err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	recorder.file = file
+	recorder.size = info.Size()
+	return nil
+}
+
+//record : Appends a record. Capture errors are logged and never fail the read or write.
+func (recorder *captureRecorder) record(direction string, data []byte) {
+	line, err := json.Marshal(CaptureRecord{Time: time.Now().UTC(), Direction: direction, Data: data})
+	if err != nil {
+		log.Println("[ERROR] captureRecorder - Error encoding capture record: " + err.Error())
+		return
+	}
+	line = append(line, '\n')
+
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	if recorder.file == nil {
+		return
+	}
+
+	if recorder.size > 0 && recorder.size+int64(len(line)) > recorder.settings.MaxSize {
+		if err := recorder.rotate(); err != nil {
+			log.Println("[ERROR] captureRecorder - Error rotating capture file, capture stopped: " + err.Error())
+			return
+		}
+	}
+
+	n, err := recorder.file.Write(line)
+	recorder.size += int64(n)
+	if err != nil {
+		log.Println("[ERROR] captureRecorder - Error writing capture record: " + err.Error())
+	}
+}
+
+func (recorder *captureRecorder) rotate() error {
+	recorder.file.Close()
+	recorder.file = nil
+
+	path := recorder.settings.Path
+	if recorder.settings.MaxFiles == 0 {
+		os.Remove(path)
+	} else {
+		os.Remove(fmt.Sprintf("%s.%d", path, recorder.settings.MaxFiles))
+		for index := recorder.settings.MaxFiles - 1; index >= 1; index-- {
+			os.Rename(fmt.Sprintf("%s.%d", path, index), fmt.Sprintf("%s.%d", path, index+1))
+		}
+		if err := os.Rename(path, path+".1"); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("[DEBUG] captureRecorder - Rotated capture file %s\n", path)
+	return recorder.open()
+}
+
+func (recorder *captureRecorder) close() error {
+	recorder.lock.Lock()
+	defer recorder.lock.Unlock()
+
+	if recorder.file == nil {
+		return nil
+	}
+	err := recorder.file.Close()
+	recorder.file = nil
+	return err
+}
+
+//captureTransport : Records every read and write of the wrapped transport
+type captureTransport struct {
+	Transport
+	recorder *captureRecorder
+}
+
+func (transport *captureTransport) Read(buff []byte) (int, error) {
+	n, err := transport.Transport.Read(buff)
+	if n > 0 {
+		transport.recorder.record(CaptureReceived, buff[:n])
+	}
+	return n, err
+}
+
+func (transport *captureTransport) Write(buff []byte) (int, error) {
+	n, err := transport.Transport.Write(buff)
+	if n > 0 {
+		transport.recorder.record(CaptureSent, buff[:n])
+	}
+	return n, err
+}
+
+func (transport *captureTransport) Close() error {
+	err := transport.Transport.Close()
+	if closeErr := transport.recorder.close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
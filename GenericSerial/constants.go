@@ -143,3 +143,10 @@ const AtCmdConnectText = "CONNECT\r\n"
 
 const SendStopDelay = 250               //milliseconds
 const SendStopCarriageReturnDelay = 750 //milliseconds
+
+const SerialDataModeTimeout = 30           //seconds
+const StopSerialDataModeAttempts = 3       //escape sequence attempts before sending a break
+const StopSerialDataModeAttemptTimeout = 4 //seconds allowed for each attempt to leave serial data mode
+const DtrToggleDelay = 250                 //milliseconds
+
+const WriteChunkSize = 256 //bytes written between context checks
@@ -0,0 +1,145 @@
+package GenericSerial
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+//Parity values of LineSettings
+const (
+	ParityNone  = "none"
+	ParityOdd   = "odd"
+	ParityEven  = "even"
+	ParityMark  = "mark"
+	ParitySpace = "space"
+)
+
+//Flow control values of LineSettings
+const (
+	FlowNone = "none"
+	//FlowRTSCTS : Hardware flow control, the UART stops sending while CTS is cleared
+	FlowRTSCTS = "rtscts"
+	//FlowXONXOFF : Software flow control, XON and XOFF bytes are removed from the data read
+	FlowXONXOFF = "xonxoff"
+)
+
+//LineSettings : The character format and speed of the serial line
+type LineSettings struct {
+	BaudRate    int
+	DataBits    int     //5 to 8
+	Parity      string  //none, odd, even, mark or space
+	StopBits    float64 //1, 1.5 or 2
+	FlowControl string  //none, rtscts or xonxoff
+}
+
+var tarmParity = map[string]serial.Parity{
+	ParityNone:  serial.ParityNone,
+	ParityOdd:   serial.ParityOdd,
+	ParityEven:  serial.ParityEven,
+	ParityMark:  serial.ParityMark,
+	ParitySpace: serial.ParitySpace,
+}
+
+var tarmStopBits = map[float64]serial.StopBits{
+	1:   serial.Stop1,
+	1.5: serial.Stop1Half,
+	2:   serial.Stop2,
+}
+
+//defaultLineSettings : 8 data bits, no parity and 1 stop bit (8N1) without flow control
+func defaultLineSettings(baud int) LineSettings {
+	return LineSettings{BaudRate: baud, DataBits: 8, Parity: ParityNone, StopBits: 1, FlowControl: FlowNone}
+}
+
+//Validate : Returns an error describing the first invalid setting
+func (settings LineSettings) Validate() error {
+	if settings.BaudRate <= 0 {
+		return fmt.Errorf("Invalid baud rate %d", settings.BaudRate)
+	}
+	if settings.DataBits < 5 || settings.DataBits > 8 {
+		return fmt.Errorf("Invalid data bits %d, expected 5 to 8", settings.DataBits)
+	}
+	if _, ok := tarmParity[settings.Parity]; !ok {
+		return fmt.Errorf("Invalid parity %q, expected none, odd, even, mark or space", settings.Parity)
+	}
+	if _, ok := tarmStopBits[settings.StopBits]; !ok {
+		return fmt.Errorf("Invalid stop bits %g, expected 1, 1.5 or 2", settings.StopBits)
+	}
+	switch settings.FlowControl {
+	case FlowNone, FlowRTSCTS, FlowXONXOFF:
+	default:
+		return fmt.Errorf("Invalid flow control %q, expected none, rtscts or xonxoff", settings.FlowControl)
+	}
+	return nil
+}
+
+//String : The settings in the usual notation followed by the flow control, ex. 9600 8N1 or 115200 8N1 rtscts
+func (settings LineSettings) String() string {
+	parity := "N"
+	if settings.Parity != "" {
+		parity = string(tarmParity[settings.Parity])
+	}
+	notation := fmt.Sprintf("%d %d%s%g", settings.BaudRate, settings.DataBits, parity, settings.StopBits)
+	if settings.FlowControl != "" && settings.FlowControl != FlowNone {
+		notation += " " + settings.FlowControl
+	}
+	return notation
+}
+
+//config : The tarm/serial configuration of the port
+func (settings LineSettings) config(portName string, timeout time.Duration) *serial.Config {
+	return &serial.Config{
+		Name:        portName,
+		Baud:        settings.BaudRate,
+		ReadTimeout: timeout,
+		Size:        byte(settings.DataBits),
+		Parity:      tarmParity[settings.Parity],
+		StopBits:    tarmStopBits[settings.StopBits],
+	}
+}
+
+//LineSettings : The current speed and character format of the port
+func (serialDevice *SerialPort) LineSettings() LineSettings {
+	return serialDevice.line
+}
+
+//SetLineSettings : Changes the speed and character format. An open port is closed and reopened
+//with the new settings, data not yet read is lost. When the port cannot be reopened with the new
+//settings it is reopened with the previous ones and the error is returned.
+func (serialDevice *SerialPort) SetLineSettings(settings LineSettings) error {
+	if err := settings.Validate(); err != nil {
+		log.Println("[ERROR] SetLineSettings - " + err.Error())
+		return err
+	}
+	if serialDevice.IsReplaying() {
+		return ErrReplayLineControl
+	}
+
+	previous := serialDevice.line
+	serialDevice.line = settings
+	if !serialDevice.IsOpen() {
+		return nil
+	}
+
+	log.Printf("[INFO] SetLineSettings - Reopening serial port with %s\n", settings.String())
+	if err := serialDevice.CloseSerialPort(); err != nil {
+		serialDevice.line = previous
+		return err
+	}
+
+	err := serialDevice.OpenSerialPort()
+	if err == nil {
+		return nil
+	}
+
+	log.Printf("[ERROR] SetLineSettings - Unable to apply %s, restoring %s\n", settings.String(), previous.String())
+	serialDevice.line = previous
+	if restoreErr := serialDevice.OpenSerialPort(); restoreErr != nil {
+		return errors.New(err.Error() + ", the serial port could not be reopened: " + restoreErr.Error())
+	}
+	return err
+}
@@ -0,0 +1,240 @@
+//go:build linux
+// +build linux
+
+package GenericSerial
+
+import (
+	"log"
+	"os"
+	"time"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+//Modem control lines set by setModemLine
+const (
+	dtrLine = unix.TIOCM_DTR
+	rtsLine = unix.TIOCM_RTS
+)
+
+//serialRS485 : struct serial_rs485 of linux/serial.h, used with TIOCSRS485
+type serialRS485 struct {
+	flags              uint32
+	delayRTSBeforeSend uint32 //milliseconds
+	delayRTSAfterSend  uint32 //milliseconds
+	padding            [5]uint32
+}
+
+//Flags of serialRS485
+const (
+	serRS485Enabled      = 1 << 0
+	serRS485RTSOnSend    = 1 << 1
+	serRS485RTSAfterSend = 1 << 2
+)
+
+//withControlFd : tarm/serial does not expose the file descriptor of the port, so line control
+//ioctls are issued on a second descriptor opened on the same tty. The descriptor is opened on
+//first use and closed with the serial port. It is held while control runs so it is not closed
+//under a line monitor polling from another go routine.
+func (serial *SerialPort) withControlFd(control func(fd int) error) error {
+	if serial.IsReplaying() {
+		return ErrReplayLineControl
+	}
+
+	serial.controlLock.Lock()
+	defer serial.controlLock.Unlock()
+
+	if serial.controlFile == nil {
+		file, err := os.OpenFile(serial.portName, os.O_RDWR|unix.O_NOCTTY|unix.O_NONBLOCK, 0)
+		if err != nil {
+			log.Println("[ERROR] withControlFd - Error opening serial port control descriptor: " + err.Error())
+			return err
+		}
+		serial.controlFile = file
+	}
+	return control(int(serial.controlFile.Fd()))
+}
+
+//SendBreak : Holds the transmit line low for 0.25 to 0.5 seconds
+func (serial *SerialPort) SendBreak() error {
+	log.Println("[DEBUG] SendBreak - Sending break signal")
+
+	err := serial.withControlFd(func(fd int) error {
+		return unix.IoctlSetInt(fd, unix.TCSBRK, 0)
+	})
+	if err != nil {
+		log.Println("[ERROR] SendBreak - Error sending break signal: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+//SendBreakFor : Holds the transmit line low for the given duration
+func (serial *SerialPort) SendBreakFor(duration time.Duration) error {
+	log.Printf("[DEBUG] SendBreakFor - Sending break signal for %s\n", duration)
+
+	err := serial.withControlFd(func(fd int) error {
+		if err := unix.IoctlSetInt(fd, unix.TIOCSBRK, 0); err != nil {
+			return err
+		}
+		time.Sleep(duration)
+		return unix.IoctlSetInt(fd, unix.TIOCCBRK, 0)
+	})
+	if err != nil {
+		log.Println("[ERROR] SendBreakFor - Error sending break signal: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+//SetDTR : Asserts (true) or clears (false) the Data Terminal Ready line
+func (serial *SerialPort) SetDTR(asserted bool) error {
+	log.Printf("[DEBUG] SetDTR - Setting DTR to %t\n", asserted)
+
+	if err := serial.setModemLine(dtrLine, asserted); err != nil {
+		log.Println("[ERROR] SetDTR - Error setting DTR: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+//SetRTS : Asserts (true) or clears (false) the Request To Send line. Returns ErrRTSDirectionControl
+//while RTS switches an RS-485 transceiver and ErrRTSFlowControl with hardware flow control.
+func (serial *SerialPort) SetRTS(asserted bool) error {
+	log.Printf("[DEBUG] SetRTS - Setting RTS to %t\n", asserted)
+
+	if serial.rs485 != nil && serial.rs485.usesRTS() {
+		return ErrRTSDirectionControl
+	}
+	if serial.line.FlowControl == FlowRTSCTS {
+		return ErrRTSFlowControl
+	}
+	if err := serial.setModemLine(rtsLine, asserted); err != nil {
+		log.Println("[ERROR] SetRTS - Error setting RTS: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+//ReadModemLines : Returns the state of the modem control lines
+func (serial *SerialPort) ReadModemLines() (ModemLines, error) {
+	var status int
+	err := serial.withControlFd(func(fd int) error {
+		var err error
+		status, err = unix.IoctlGetInt(fd, unix.TIOCMGET)
+		return err
+	})
+	if err != nil {
+		//Polled by line monitors, the caller decides how often to report the failure
+		log.Println("[DEBUG] ReadModemLines - Error reading modem control lines: " + err.Error())
+		return ModemLines{}, err
+	}
+
+	return ModemLines{
+		CTS: status&unix.TIOCM_CTS != 0,
+		DSR: status&unix.TIOCM_DSR != 0,
+		DCD: status&unix.TIOCM_CD != 0,
+		RI:  status&unix.TIOCM_RI != 0,
+		DTR: status&unix.TIOCM_DTR != 0,
+		RTS: status&unix.TIOCM_RTS != 0,
+	}, nil
+}
+
+//setModemLine : Asserts or clears a modem control output line
+func (serial *SerialPort) setModemLine(line int, asserted bool) error {
+	return serial.withControlFd(func(fd int) error {
+		if asserted {
+			return unix.IoctlSetPointerInt(fd, unix.TIOCMBIS, line)
+		}
+		return unix.IoctlSetPointerInt(fd, unix.TIOCMBIC, line)
+	})
+}
+
+//applyFlowControl : Enables the flow control of the line settings in the UART
+func (serial *SerialPort) applyFlowControl() error {
+	err := serial.withControlFd(func(fd int) error {
+		termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+		if err != nil {
+			return err
+		}
+
+		termios.Cflag &^= unix.CRTSCTS
+		termios.Iflag &^= unix.IXON | unix.IXOFF | unix.IXANY
+		switch serial.line.FlowControl {
+		case FlowRTSCTS:
+			termios.Cflag |= unix.CRTSCTS
+		case FlowXONXOFF:
+			termios.Iflag |= unix.IXON | unix.IXOFF
+		}
+		return unix.IoctlSetTermios(fd, unix.TCSETS, termios)
+	})
+	if err != nil {
+		log.Printf("[ERROR] applyFlowControl - Unable to enable %s flow control: %s\n", serial.line.FlowControl, err.Error())
+		return err
+	}
+	log.Printf("[INFO] applyFlowControl - %s flow control enabled\n", serial.line.FlowControl)
+	return nil
+}
+
+//drain : Waits until the data written to the port has been transmitted (tcdrain)
+func (serial *SerialPort) drain() error {
+	return serial.withControlFd(func(fd int) error {
+		return unix.IoctlSetInt(fd, unix.TCSBRK, 1)
+	})
+}
+
+//enableKernelRS485 : Lets the UART driver switch the transceiver with RTS around each transmission
+func (serial *SerialPort) enableKernelRS485(settings RS485Settings) error {
+	config := serialRS485{
+		flags:              serRS485Enabled,
+		delayRTSBeforeSend: uint32(settings.DelayBeforeSend / time.Millisecond),
+		delayRTSAfterSend:  uint32(settings.DelayAfterSend / time.Millisecond),
+	}
+	if settings.ActiveLow {
+		config.flags |= serRS485RTSAfterSend
+	} else {
+		config.flags |= serRS485RTSOnSend
+	}
+
+	err := serial.withControlFd(func(fd int) error {
+		if _, _, errno := unix.Syscall(unix.SYS_IOCTL, uintptr(fd), uintptr(unix.TIOCSRS485), uintptr(unsafe.Pointer(&config))); errno != 0 {
+			return errno
+		}
+		return nil
+	})
+	if err != nil {
+		log.Println("[ERROR] enableKernelRS485 - The serial driver does not support RS-485 mode: " + err.Error())
+		return err
+	}
+	return nil
+}
+
+//ToggleDTR : Clears DTR for the given duration and then asserts it again. Many devices
+//treat a DTR drop as a hang up or reset request.
+func (serial *SerialPort) ToggleDTR(duration time.Duration) error {
+	if err := serial.SetDTR(false); err != nil {
+		return err
+	}
+	time.Sleep(duration)
+	return serial.SetDTR(true)
+}
+
+//ToggleRTS : Clears RTS for the given duration and then asserts it again
+func (serial *SerialPort) ToggleRTS(duration time.Duration) error {
+	if err := serial.SetRTS(false); err != nil {
+		return err
+	}
+	time.Sleep(duration)
+	return serial.SetRTS(true)
+}
+
+func (serial *SerialPort) closeControlFd() {
+	serial.controlLock.Lock()
+	defer serial.controlLock.Unlock()
+
+	if serial.controlFile != nil {
+		serial.controlFile.Close()
+		serial.controlFile = nil
+	}
+}
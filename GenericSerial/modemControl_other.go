@@ -0,0 +1,71 @@
+//go:build !linux
+// +build !linux
+
+package GenericSerial
+
+import (
+	"errors"
+	"time"
+)
+
+//ErrLineControlNotSupported : Returned by line control operations on platforms other than linux
+var ErrLineControlNotSupported = errors.New("Serial line control is only supported on linux")
+
+//SendBreak : Not supported on this platform
+func (serial *SerialPort) SendBreak() error {
+	return ErrLineControlNotSupported
+}
+
+//SendBreakFor : Not supported on this platform
+func (serial *SerialPort) SendBreakFor(duration time.Duration) error {
+	return ErrLineControlNotSupported
+}
+
+//SetDTR : Not supported on this platform
+func (serial *SerialPort) SetDTR(asserted bool) error {
+	return ErrLineControlNotSupported
+}
+
+//SetRTS : Not supported on this platform
+func (serial *SerialPort) SetRTS(asserted bool) error {
+	return ErrLineControlNotSupported
+}
+
+//ReadModemLines : Not supported on this platform
+func (serial *SerialPort) ReadModemLines() (ModemLines, error) {
+	return ModemLines{}, ErrLineControlNotSupported
+}
+
+//ToggleDTR : Not supported on this platform
+func (serial *SerialPort) ToggleDTR(duration time.Duration) error {
+	return ErrLineControlNotSupported
+}
+
+//ToggleRTS : Not supported on this platform
+func (serial *SerialPort) ToggleRTS(duration time.Duration) error {
+	return ErrLineControlNotSupported
+}
+
+//Modem control lines set by setModemLine
+const (
+	dtrLine = iota
+	rtsLine
+)
+
+func (serial *SerialPort) setModemLine(line int, asserted bool) error {
+	return ErrLineControlNotSupported
+}
+
+func (serial *SerialPort) applyFlowControl() error {
+	return ErrLineControlNotSupported
+}
+
+func (serial *SerialPort) drain() error {
+	return ErrLineControlNotSupported
+}
+
+func (serial *SerialPort) enableKernelRS485(settings RS485Settings) error {
+	return ErrLineControlNotSupported
+}
+
+func (serial *SerialPort) closeControlFd() {}
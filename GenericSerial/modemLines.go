@@ -0,0 +1,27 @@
+package GenericSerial
+
+import "errors"
+
+//ErrRTSDirectionControl : Returned by SetRTS while RTS switches an RS-485 transceiver
+var ErrRTSDirectionControl = errors.New("RTS is used for RS-485 direction control")
+
+//ErrRTSFlowControl : Returned by SetRTS while the UART drives RTS for hardware flow control
+var ErrRTSFlowControl = errors.New("RTS is used for hardware flow control")
+
+//ModemLines : State of the modem control lines, true when the line is asserted
+type ModemLines struct {
+	//Inputs
+	CTS bool `json:"cts"`
+	DSR bool `json:"dsr"`
+	DCD bool `json:"dcd"`
+	RI  bool `json:"ri"`
+
+	//Outputs
+	DTR bool `json:"dtr"`
+	RTS bool `json:"rts"`
+}
+
+//Inputs : The input lines by name (cts, dsr, dcd, ri)
+func (lines ModemLines) Inputs() map[string]bool {
+	return map[string]bool{"cts": lines.CTS, "dsr": lines.DSR, "dcd": lines.DCD, "ri": lines.RI}
+}
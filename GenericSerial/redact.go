@@ -0,0 +1,38 @@
+package GenericSerial
+
+import (
+	"strings"
+)
+
+const redactedText = "********"
+
+//secretATCommands : Commands that read or write network keys. Their values are never written to the log.
+var secretATCommands = []string{NetworkSessionKeyCmd, NetworkDataKeyCmd, NetworkKeyCmd}
+
+func isSecretATCommand(cmd string) bool {
+	for _, secretCmd := range secretATCommands {
+		if cmd == secretCmd || strings.HasPrefix(cmd, secretCmd+"=") || strings.HasPrefix(cmd, secretCmd+"\r") {
+			return true
+		}
+	}
+	return false
+}
+
+//RedactSecret : Replaces a secret value with a fixed placeholder for logging
+func RedactSecret(value string) string {
+	if value == "" {
+		return ""
+	}
+	return redactedText
+}
+
+//redactATCommand : Removes the value from AT commands that set a network key
+func redactATCommand(cmd string) string {
+	if !isSecretATCommand(cmd) {
+		return cmd
+	}
+	if index := strings.Index(cmd, "="); index >= 0 {
+		return cmd[:index+1] + redactedText
+	}
+	return cmd
+}
@@ -0,0 +1,130 @@
+package GenericSerial
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+//ErrReplayLineControl : Returned by the line control functions while a recording is replayed
+var ErrReplayLineControl = errors.New("Serial line control is not available while replaying a recording")
+
+//replayTransport : Plays back the data received in a capture file. The time between received
+//records is divided by speed, a speed of 0 plays the records back as fast as they are read.
+//Writes are accepted and discarded. Once the recording ends every read times out.
+type replayTransport struct {
+	lock    sync.Mutex
+	records []CaptureRecord
+	next    int
+	pending []byte
+	speed   float64
+	timeout time.Duration
+
+	//Playback time of the first record, set by the first read
+	started time.Time
+	closed  bool
+}
+
+func openReplayTransport(path string, speed float64, timeout time.Duration) (*replayTransport, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		log.Println("[ERROR] openReplayTransport - Error opening recording: " + err.Error())
+		return nil, err
+	}
+	defer file.Close()
+
+	transport := &replayTransport{speed: speed, timeout: timeout}
+
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var record CaptureRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			return nil, fmt.Errorf("Invalid record on line %d of %s: %s", line, path, err.Error())
+		}
+		if record.Direction == CaptureReceived && len(record.Data) > 0 {
+			transport.records = append(transport.records, record)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	log.Printf("[INFO] openReplayTransport - Replaying %d received records from %s at speed %g\n", len(transport.records), path, speed)
+	return transport, nil
+}
+
+//due : When the record at index is played back
+func (transport *replayTransport) due(index int) time.Time {
+	if transport.speed <= 0 {
+		return time.Time{}
+	}
+	offset := transport.records[index].Time.Sub(transport.records[0].Time)
+	return transport.started.Add(time.Duration(float64(offset) / transport.speed))
+}
+
+func (transport *replayTransport) Read(buff []byte) (int, error) {
+	transport.lock.Lock()
+	defer transport.lock.Unlock()
+
+	if transport.closed {
+		return 0, os.ErrClosed
+	}
+
+	if transport.started.IsZero() {
+		transport.started = time.Now()
+	}
+
+	if len(transport.pending) == 0 {
+		if transport.next >= len(transport.records) {
+			if transport.next == len(transport.records) {
+				log.Println("[INFO] replayTransport - Recording complete")
+				transport.next++
+			}
+			time.Sleep(transport.timeout)
+			return 0, io.EOF
+		}
+
+		wait := time.Until(transport.due(transport.next))
+		if wait > transport.timeout {
+			time.Sleep(transport.timeout)
+			return 0, io.EOF
+		}
+		if wait > 0 {
+			time.Sleep(wait)
+		}
+		transport.pending = transport.records[transport.next].Data
+		transport.next++
+	}
+
+	n := copy(buff, transport.pending)
+	transport.pending = transport.pending[n:]
+	return n, nil
+}
+
+func (transport *replayTransport) Write(buff []byte) (int, error) {
+	log.Printf("[DEBUG] replayTransport - Discarding %d bytes written during replay\n", len(buff))
+	return len(buff), nil
+}
+
+func (transport *replayTransport) Flush() error {
+	return nil
+}
+
+func (transport *replayTransport) Close() error {
+	transport.lock.Lock()
+	defer transport.lock.Unlock()
+
+	transport.closed = true
+	return nil
+}
@@ -0,0 +1,228 @@
+package GenericSerial
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+//Direction control modes of RS485Settings
+const (
+	//RS485Kernel : The UART driver switches the transceiver (TIOCSRS485)
+	RS485Kernel = "kernel"
+	//RS485RTS : The transceiver driver enable is wired to RTS
+	RS485RTS = "rts"
+	//RS485GPIO : The transceiver driver enable is wired to a GPIO line
+	RS485GPIO = "gpio"
+)
+
+//RS485Settings : Half-duplex direction control of an RS-485 transceiver
+type RS485Settings struct {
+	//kernel, rts, gpio or empty when the transceiver switches itself
+	Mode string
+
+	//Time the driver is enabled before the first byte is sent and kept enabled after the last byte
+	DelayBeforeSend time.Duration
+	DelayAfterSend  time.Duration
+
+	//sysfs value file of the GPIO line (ex. /sys/class/gpio/gpio17/value), the line must already be exported as an output
+	GPIO string
+
+	//The driver is enabled by a low level (RTS cleared or GPIO 0) rather than a high level
+	ActiveLow bool
+
+	//Transceivers that keep the receiver enabled while sending read back every byte sent. The
+	//echo is read and discarded after each write so it is not mistaken for the reply.
+	DiscardEcho bool
+}
+
+//Validate : Returns an error describing the first invalid setting
+func (settings RS485Settings) Validate() error {
+	switch settings.Mode {
+	case "", RS485Kernel, RS485RTS:
+	case RS485GPIO:
+		if settings.GPIO == "" {
+			return errors.New("The gpio RS-485 mode requires the GPIO value file")
+		}
+	default:
+		return fmt.Errorf("Invalid RS-485 mode %q, expected kernel, rts or gpio", settings.Mode)
+	}
+	if settings.DelayBeforeSend < 0 || settings.DelayAfterSend < 0 {
+		return errors.New("RS-485 delays must not be negative")
+	}
+	return nil
+}
+
+//usesRTS : The transceiver is switched with RTS
+func (settings RS485Settings) usesRTS() bool {
+	return settings.Mode == RS485RTS || settings.Mode == RS485Kernel
+}
+
+//SetRS485 : Configures RS-485 direction control and echo suppression once the port is opened.
+//Must be called before OpenSerialPort.
+func (serialDevice *SerialPort) SetRS485(settings RS485Settings) error {
+	if err := settings.Validate(); err != nil {
+		log.Println("[ERROR] SetRS485 - " + err.Error())
+		return err
+	}
+	serialDevice.rs485 = &settings
+	return nil
+}
+
+//rs485Transport : Enables the transceiver driver around each write and discards the echo of the
+//bytes sent. In kernel mode the UART driver switches the transceiver and only the echo is handled here.
+type rs485Transport struct {
+	Transport
+	serial   *SerialPort
+	settings RS485Settings
+	driver   driverEnable //nil in kernel mode or without direction control
+}
+
+//driverEnable : Switches the transceiver between sending and receiving
+type driverEnable interface {
+	Set(enabled bool) error
+	Close() error
+}
+
+//openRS485Transport : Wraps transport and leaves the transceiver receiving
+func openRS485Transport(transport Transport, serial *SerialPort, settings RS485Settings) (*rs485Transport, error) {
+	rs485 := &rs485Transport{Transport: transport, serial: serial, settings: settings}
+	if settings.usesRTS() && serial.line.FlowControl == FlowRTSCTS {
+		log.Printf("[ERROR] openRS485Transport - The RS-485 %s mode switches the transceiver with RTS, it cannot be combined with rtscts flow control\n", settings.Mode)
+		return nil, ErrRTSFlowControl
+	}
+
+	var err error
+	switch settings.Mode {
+	case RS485Kernel:
+		err = serial.enableKernelRS485(settings)
+	case RS485RTS:
+		rs485.driver = &rtsDriverEnable{serial: serial, activeLow: settings.ActiveLow}
+	case RS485GPIO:
+		rs485.driver, err = openGPIODriverEnable(settings.GPIO, settings.ActiveLow)
+	}
+	if err == nil && rs485.driver != nil {
+		err = rs485.driver.Set(false)
+	}
+	if err != nil {
+		log.Printf("[ERROR] openRS485Transport - Unable to configure RS-485 %s mode: %s\n", settings.Mode, err.Error())
+		if rs485.driver != nil {
+			rs485.driver.Close()
+		}
+		return nil, err
+	}
+
+	log.Printf("[INFO] openRS485Transport - RS-485 direction control: %s, discard echo: %t\n", settings.Mode, settings.DiscardEcho)
+	return rs485, nil
+}
+
+func (transport *rs485Transport) Write(buff []byte) (int, error) {
+	if transport.driver != nil {
+		if err := transport.driver.Set(true); err != nil {
+			log.Println("[ERROR] rs485Transport - Error enabling the transceiver driver: " + err.Error())
+			return 0, err
+		}
+		time.Sleep(transport.settings.DelayBeforeSend)
+	}
+
+	n, err := transport.Transport.Write(buff)
+
+	if transport.driver != nil {
+		//The driver must stay enabled until the last stop bit has left the UART
+		if drainErr := transport.serial.drain(); drainErr != nil {
+			log.Println("[WARN] rs485Transport - Error waiting for the transmission to complete: " + drainErr.Error())
+		}
+		time.Sleep(transport.settings.DelayAfterSend)
+		if disableErr := transport.driver.Set(false); disableErr != nil {
+			log.Println("[ERROR] rs485Transport - Error disabling the transceiver driver: " + disableErr.Error())
+			if err == nil {
+				err = disableErr
+			}
+		}
+	}
+
+	if transport.settings.DiscardEcho && n > 0 {
+		transport.discardEcho(buff[:n])
+	}
+	return n, err
+}
+
+//discardEcho : Reads back the bytes just sent. Only the length of the echo is read so a reply
+//that follows it is left for the next read.
+func (transport *rs485Transport) discardEcho(sent []byte) {
+	echo := make([]byte, 0, len(sent))
+	buff := make([]byte, len(sent))
+	for len(echo) < len(sent) {
+		n, err := transport.Transport.Read(buff[:len(sent)-len(echo)])
+		echo = append(echo, buff[:n]...)
+		if n == 0 || err != nil {
+			break
+		}
+	}
+
+	switch {
+	case len(echo) < len(sent):
+		log.Printf("[WARN] rs485Transport - Only %d of %d echoed bytes received, check the discardEcho setting\n", len(echo), len(sent))
+	case !bytes.Equal(echo, sent):
+		log.Println("[WARN] rs485Transport - Echo differs from the data sent, another node may have been sending")
+	default:
+		log.Printf("[DEBUG] rs485Transport - Discarded %d echoed bytes\n", len(echo))
+	}
+}
+
+func (transport *rs485Transport) Close() error {
+	err := transport.Transport.Close()
+	if transport.driver != nil {
+		if closeErr := transport.driver.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+//rtsDriverEnable : Driver enable wired to RTS
+type rtsDriverEnable struct {
+	serial    *SerialPort
+	activeLow bool
+}
+
+func (driver *rtsDriverEnable) Set(enabled bool) error {
+	return driver.serial.setModemLine(rtsLine, enabled != driver.activeLow)
+}
+
+func (driver *rtsDriverEnable) Close() error {
+	return nil
+}
+
+//gpioDriverEnable : Driver enable wired to a GPIO line controlled through sysfs
+type gpioDriverEnable struct {
+	file      *os.File
+	activeLow bool
+}
+
+func openGPIODriverEnable(path string, activeLow bool) (driverEnable, error) {
+	file, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		return nil, err
+	}
+	return &gpioDriverEnable{file: file, activeLow: activeLow}, nil
+}
+
+func (driver *gpioDriverEnable) Set(enabled bool) error {
+	value := "0"
+	if enabled != driver.activeLow {
+		value = "1"
+	}
+	if _, err := driver.file.Seek(0, 0); err != nil {
+		return err
+	}
+	_, err := driver.file.Write([]byte(value))
+	return err
+}
+
+func (driver *gpioDriverEnable) Close() error {
+	return driver.file.Close()
+}
@@ -0,0 +1,12 @@
+package GenericSerial
+
+//Transport : The byte stream behind a SerialPort. OpenSerialPort uses the tty, a recording
+//when replay is enabled, and wraps either with the capture recorder when capture is enabled.
+//
+//Read blocks for at most the port timeout and returns an error containing EOF when nothing was received.
+type Transport interface {
+	Read(buff []byte) (int, error)
+	Write(buff []byte) (int, error)
+	Flush() error
+	Close() error
+}
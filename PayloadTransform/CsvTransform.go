@@ -0,0 +1,130 @@
+package PayloadTransform
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+//CsvTransformName : Decodes comma separated lines into typed JSON objects
+const CsvTransformName = "csv"
+
+func init() {
+	RegisterTransform(CsvTransformName, newCsvTransform)
+}
+
+//csvTransform : Each column of a line becomes an attribute of the decoded object
+//
+// {"type": "csv", "delimiter": ",", "columns": ["station", {"name": "temperature", "type": "float", "unit": "C"}]}
+//
+// Lines must have exactly one value per column. Quoted values are supported. Lines that repeat
+// the column names are treated as a header and rejected. Encode writes the attributes of a JSON
+// object in column order.
+type csvTransform struct {
+	delimiter rune
+	columns   []typedField
+	trim      string
+	suffix    string
+}
+
+func newCsvTransform(settings map[string]interface{}) (Transform, error) {
+	delimiter := settingString(settings, "delimiter", ",")
+	if utf8.RuneCountInString(delimiter) != 1 || delimiter == "\"" || delimiter == "\r" || delimiter == "\n" {
+		return nil, errors.New("The csv transform delimiter must be a single character")
+	}
+
+	columns, err := parseTypedFields(settings, "columns", false)
+	if err != nil {
+		return nil, errors.New("Invalid csv transform: " + err.Error())
+	}
+
+	transform := &csvTransform{
+		columns: columns,
+		trim:    settingString(settings, "trim", "\r\n"),
+		suffix:  settingString(settings, "suffix", "\r\n"),
+	}
+	transform.delimiter, _ = utf8.DecodeRuneInString(delimiter)
+	return transform, nil
+}
+
+func (transform *csvTransform) Name() string {
+	return CsvTransformName
+}
+
+func (transform *csvTransform) Decode(frame string) (interface{}, error) {
+	line := strings.Trim(frame, transform.trim)
+	if line == "" {
+		return nil, errors.New("Empty line")
+	}
+
+	reader := csv.NewReader(strings.NewReader(line))
+	reader.Comma = transform.delimiter
+	reader.FieldsPerRecord = -1
+	reader.LazyQuotes = true
+
+	values, err := reader.Read()
+	if err != nil {
+		return nil, errors.New("Malformed csv line: " + err.Error())
+	}
+	if len(values) != len(transform.columns) {
+		return nil, fmt.Errorf("Expected %d columns, received %d", len(transform.columns), len(values))
+	}
+	if transform.isHeader(values) {
+		return nil, errors.New("Header line")
+	}
+
+	result := make(map[string]interface{}, len(values))
+	for index, column := range transform.columns {
+		if strings.TrimSpace(values[index]) == "" {
+			if column.required {
+				return nil, fmt.Errorf("Column %s is empty", column.name)
+			}
+			continue
+		}
+
+		value, err := column.convert(values[index])
+		if err != nil {
+			return nil, err
+		}
+		result[column.name] = value
+	}
+	return result, nil
+}
+
+func (transform *csvTransform) isHeader(values []string) bool {
+	for index, column := range transform.columns {
+		if strings.TrimSpace(values[index]) != column.name {
+			return false
+		}
+	}
+	return true
+}
+
+func (transform *csvTransform) Encode(command interface{}) (string, error) {
+	object, ok := command.(map[string]interface{})
+	if !ok {
+		return "", errors.New("The csv transform can only encode JSON objects")
+	}
+
+	record := make([]string, len(transform.columns))
+	for index, column := range transform.columns {
+		value, ok := object[column.name]
+		if !ok && column.required {
+			return "", errors.New("Command is missing " + column.name)
+		}
+		record[index] = column.format(value)
+	}
+
+	buffer := &bytes.Buffer{}
+	writer := csv.NewWriter(buffer)
+	writer.Comma = transform.delimiter
+	if err := writer.Write(record); err != nil {
+		return "", err
+	}
+	writer.Flush()
+
+	return strings.TrimSuffix(buffer.String(), "\n") + transform.suffix, writer.Error()
+}
@@ -0,0 +1,108 @@
+package PayloadTransform
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+//FixedWidthTransformName : Decodes lines made of fixed width columns into typed JSON objects
+const FixedWidthTransformName = "fixedwidth"
+
+func init() {
+	RegisterTransform(FixedWidthTransformName, newFixedWidthTransform)
+}
+
+//fixedWidthTransform : Cuts each line into consecutive fields of the given widths
+//
+// {"type": "fixedwidth", "fields": [{"name": "id", "width": 4}, {"name": "level", "type": "float", "width": 8, "unit": "m"}]}
+//
+// Values are trimmed of spaces. Lines shorter than the total width are rejected unless the
+// missing fields are not required. Encode pads strings on the right and numbers on the left.
+type fixedWidthTransform struct {
+	fields []typedField
+	trim   string
+	suffix string
+}
+
+func newFixedWidthTransform(settings map[string]interface{}) (Transform, error) {
+	fields, err := parseTypedFields(settings, "fields", true)
+	if err != nil {
+		return nil, errors.New("Invalid fixedwidth transform: " + err.Error())
+	}
+
+	return &fixedWidthTransform{
+		fields: fields,
+		trim:   settingString(settings, "trim", "\r\n"),
+		suffix: settingString(settings, "suffix", "\r\n"),
+	}, nil
+}
+
+func (transform *fixedWidthTransform) Name() string {
+	return FixedWidthTransformName
+}
+
+func (transform *fixedWidthTransform) Decode(frame string) (interface{}, error) {
+	line := strings.Trim(frame, transform.trim)
+	if line == "" {
+		return nil, errors.New("Empty line")
+	}
+
+	result := map[string]interface{}{}
+	start := 0
+	for _, field := range transform.fields {
+		end := start + field.width
+		raw := ""
+		if start < len(line) {
+			if end > len(line) {
+				end = len(line)
+			}
+			raw = line[start:end]
+		}
+		start += field.width
+
+		if strings.TrimSpace(raw) == "" {
+			if field.required {
+				return nil, fmt.Errorf("Field %s is empty", field.name)
+			}
+			continue
+		}
+
+		value, err := field.convert(raw)
+		if err != nil {
+			return nil, err
+		}
+		result[field.name] = value
+	}
+
+	if len(line) > start {
+		return nil, fmt.Errorf("Line is %d characters, expected at most %d", len(line), start)
+	}
+	return result, nil
+}
+
+func (transform *fixedWidthTransform) Encode(command interface{}) (string, error) {
+	object, ok := command.(map[string]interface{})
+	if !ok {
+		return "", errors.New("The fixedwidth transform can only encode JSON objects")
+	}
+
+	encoded := ""
+	for _, field := range transform.fields {
+		value, ok := object[field.name]
+		if !ok && field.required {
+			return "", errors.New("Command is missing " + field.name)
+		}
+
+		text := field.format(value)
+		if len(text) > field.width {
+			return "", fmt.Errorf("Field %s is %d characters, the width is %d", field.name, len(text), field.width)
+		}
+		if field.fieldType == fieldTypeString {
+			encoded += fmt.Sprintf("%-*s", field.width, text)
+		} else {
+			encoded += fmt.Sprintf("%*s", field.width, text)
+		}
+	}
+	return encoded + transform.suffix, nil
+}
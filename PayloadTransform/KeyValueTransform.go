@@ -0,0 +1,153 @@
+package PayloadTransform
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+//KeyValueTransformName : Decodes key=value pairs into typed JSON objects
+const KeyValueTransformName = "keyvalue"
+
+func init() {
+	RegisterTransform(KeyValueTransformName, newKeyValueTransform)
+}
+
+//keyValueTransform : Each key=value pair of a line becomes an attribute of the decoded object
+//
+// {"type": "keyvalue", "separator": ",", "fields": [{"name": "T", "type": "float", "unit": "C"}, {"name": "ALARM", "type": "bool", "required": false}]}
+//
+// Keys that are not listed in fields are decoded as numbers when possible, unless strict is true in
+// which case the line is rejected. Encode writes the listed fields in order followed by any other
+// attributes sorted by key.
+type keyValueTransform struct {
+	separator         string
+	keyValueSeparator string
+	fields            []typedField
+	strict            bool
+	trim              string
+	suffix            string
+}
+
+func newKeyValueTransform(settings map[string]interface{}) (Transform, error) {
+	transform := &keyValueTransform{
+		separator:         settingString(settings, "separator", ","),
+		keyValueSeparator: settingString(settings, "keyValueSeparator", "="),
+		strict:            settingBool(settings, "strict", false),
+		trim:              settingString(settings, "trim", "\r\n"),
+		suffix:            settingString(settings, "suffix", "\r\n"),
+	}
+
+	if transform.separator == "" || transform.keyValueSeparator == "" {
+		return nil, errors.New("The keyvalue transform separators must not be empty")
+	}
+	if transform.separator == transform.keyValueSeparator {
+		return nil, errors.New("The keyvalue transform separator and keyValueSeparator must differ")
+	}
+
+	if settings["fields"] != nil {
+		fields, err := parseTypedFields(settings, "fields", false)
+		if err != nil {
+			return nil, errors.New("Invalid keyvalue transform: " + err.Error())
+		}
+		transform.fields = fields
+	} else if transform.strict {
+		return nil, errors.New("The keyvalue transform requires fields when strict is true")
+	}
+
+	return transform, nil
+}
+
+func (transform *keyValueTransform) Name() string {
+	return KeyValueTransformName
+}
+
+func (transform *keyValueTransform) field(name string) (typedField, bool) {
+	for _, field := range transform.fields {
+		if field.name == name {
+			return field, true
+		}
+	}
+	return typedField{}, false
+}
+
+func (transform *keyValueTransform) Decode(frame string) (interface{}, error) {
+	line := strings.Trim(frame, transform.trim)
+	if line == "" {
+		return nil, errors.New("Empty line")
+	}
+
+	result := map[string]interface{}{}
+	for _, pair := range strings.Split(line, transform.separator) {
+		if strings.TrimSpace(pair) == "" {
+			continue
+		}
+
+		parts := strings.SplitN(pair, transform.keyValueSeparator, 2)
+		key := strings.TrimSpace(parts[0])
+		if len(parts) != 2 || key == "" {
+			return nil, fmt.Errorf("Malformed pair %q", pair)
+		}
+		if _, ok := result[key]; ok {
+			return nil, fmt.Errorf("Key %s appears more than once", key)
+		}
+
+		field, ok := transform.field(key)
+		if !ok {
+			if transform.strict {
+				return nil, fmt.Errorf("Unknown key %s", key)
+			}
+			result[key] = convertValue(parts[1], true)
+			continue
+		}
+
+		value, err := field.convert(parts[1])
+		if err != nil {
+			return nil, err
+		}
+		result[key] = value
+	}
+
+	for _, field := range transform.fields {
+		if _, ok := result[field.name]; !ok && field.required {
+			return nil, fmt.Errorf("Key %s is missing", field.name)
+		}
+	}
+	return result, nil
+}
+
+func (transform *keyValueTransform) Encode(command interface{}) (string, error) {
+	object, ok := command.(map[string]interface{})
+	if !ok {
+		return "", errors.New("The keyvalue transform can only encode JSON objects")
+	}
+
+	var pairs []string
+	for _, field := range transform.fields {
+		value, ok := object[field.name]
+		if !ok {
+			if field.required {
+				return "", errors.New("Command is missing " + field.name)
+			}
+			continue
+		}
+		pairs = append(pairs, field.name+transform.keyValueSeparator+field.format(value))
+	}
+
+	var others []string
+	for key := range object {
+		if _, ok := transform.field(key); !ok {
+			if transform.strict {
+				return "", errors.New("Unknown key " + key)
+			}
+			others = append(others, key)
+		}
+	}
+	sort.Strings(others)
+	for _, key := range others {
+		pairs = append(pairs, key+transform.keyValueSeparator+formatValue(object[key]))
+	}
+
+	return strings.Join(pairs, transform.separator) + transform.suffix, nil
+}
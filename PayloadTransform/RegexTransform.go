@@ -0,0 +1,101 @@
+package PayloadTransform
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+//RegexTransformName : Maps the named groups of a regular expression to a JSON object
+const RegexTransformName = "regex"
+
+func init() {
+	RegisterTransform(RegexTransformName, newRegexTransform)
+}
+
+var formatPlaceholder = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+//regexTransform : Each named group of pattern becomes an attribute of the decoded object
+//
+// {"type": "regex", "pattern": "T=(?P<temperature>[-0-9.]+);H=(?P<humidity>[0-9]+)", "numbers": true, "format": "SET T={temperature}\r\n"}
+//
+// Encode replaces the {name} placeholders of format with the attributes of a JSON object.
+type regexTransform struct {
+	pattern *regexp.Regexp
+	numbers bool
+	format  string
+}
+
+func newRegexTransform(settings map[string]interface{}) (Transform, error) {
+	pattern := settingString(settings, "pattern", "")
+	if pattern == "" {
+		return nil, errors.New("The regex transform requires a pattern")
+	}
+
+	compiled, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, errors.New("Invalid regex transform pattern: " + err.Error())
+	}
+
+	hasNames := false
+	for _, name := range compiled.SubexpNames() {
+		if name != "" {
+			hasNames = true
+		}
+	}
+	if !hasNames {
+		return nil, errors.New("The regex transform pattern must contain named groups, ex. (?P<temperature>[0-9.]+)")
+	}
+
+	return &regexTransform{
+		pattern: compiled,
+		numbers: settingBool(settings, "numbers", true),
+		format:  settingString(settings, "format", ""),
+	}, nil
+}
+
+func (transform *regexTransform) Name() string {
+	return RegexTransformName
+}
+
+func (transform *regexTransform) Decode(frame string) (interface{}, error) {
+	match := transform.pattern.FindStringSubmatch(frame)
+	if match == nil {
+		return nil, errors.New("Frame does not match the regex transform pattern")
+	}
+
+	result := map[string]interface{}{}
+	for index, name := range transform.pattern.SubexpNames() {
+		if name != "" {
+			result[name] = convertValue(match[index], transform.numbers)
+		}
+	}
+	return result, nil
+}
+
+func (transform *regexTransform) Encode(command interface{}) (string, error) {
+	if transform.format == "" {
+		return "", ErrEncodeNotSupported
+	}
+
+	object, ok := command.(map[string]interface{})
+	if !ok {
+		return "", errors.New("The regex transform can only encode JSON objects")
+	}
+
+	var missing []string
+	encoded := formatPlaceholder.ReplaceAllStringFunc(transform.format, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		value, ok := object[name]
+		if !ok {
+			missing = append(missing, name)
+			return placeholder
+		}
+		return formatValue(value)
+	})
+
+	if len(missing) > 0 {
+		return "", errors.New("Command is missing " + strings.Join(missing, ", "))
+	}
+	return encoded, nil
+}
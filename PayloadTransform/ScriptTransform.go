@@ -0,0 +1,162 @@
+package PayloadTransform
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/robertkrimen/otto"
+)
+
+//ScriptTransformName : Custom parsers written in JavaScript (ECMAScript 5)
+const ScriptTransformName = "script"
+
+const defaultScriptTimeout = 1000 //milliseconds
+
+var errScriptTimeout = errors.New("Payload transform script timed out")
+
+func init() {
+	RegisterTransform(ScriptTransformName, newScriptTransform)
+}
+
+//scriptTransform : Runs the decode and encode functions of a JavaScript source
+//
+// {"type": "script", "script": "function decode(frame) { ... } function encode(command) { ... }", "timeout": 1000}
+//
+// decode(frame) receives the frame as a string and returns the value to publish. encode(command)
+// is optional, it receives the parsed JSON command and returns the string written to the device.
+type scriptTransform struct {
+	lock      sync.Mutex
+	vm        *otto.Otto
+	timeout   time.Duration
+	canEncode bool
+}
+
+func newScriptTransform(settings map[string]interface{}) (Transform, error) {
+	source := settingString(settings, "script", "")
+	if source == "" {
+		return nil, errors.New("The script transform requires a script")
+	}
+
+	timeout := time.Duration(defaultScriptTimeout) * time.Millisecond
+	if value, ok := settings["timeout"].(float64); ok && value > 0 {
+		timeout = time.Duration(value) * time.Millisecond
+	}
+
+	transform := &scriptTransform{vm: otto.New(), timeout: timeout}
+	transform.vm.Interrupt = make(chan func(), 1)
+
+	if _, err := transform.run(func() (otto.Value, error) { return transform.vm.Run(source) }); err != nil {
+		return nil, errors.New("Error loading payload transform script: " + err.Error())
+	}
+
+	if value, err := transform.vm.Get("decode"); err != nil || !value.IsFunction() {
+		return nil, errors.New("The payload transform script must define a decode(frame) function")
+	}
+	if value, err := transform.vm.Get("encode"); err == nil && value.IsFunction() {
+		transform.canEncode = true
+	}
+
+	return transform, nil
+}
+
+func (transform *scriptTransform) Name() string {
+	return ScriptTransformName
+}
+
+func (transform *scriptTransform) Decode(frame string) (interface{}, error) {
+	transform.lock.Lock()
+	defer transform.lock.Unlock()
+
+	//Round trip the result through JSON so the published value only contains JSON types
+	encoded, err := transform.run(func() (otto.Value, error) {
+		result, err := transform.vm.Call("decode", nil, frame)
+		if err != nil {
+			return result, err
+		}
+		if result.IsUndefined() {
+			return result, errors.New("decode returned undefined")
+		}
+		return transform.vm.Call("JSON.stringify", nil, result)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal([]byte(encoded.String()), &decoded); err != nil {
+		return nil, errors.New("decode returned a value that cannot be converted to JSON: " + err.Error())
+	}
+	return decoded, nil
+}
+
+func (transform *scriptTransform) Encode(command interface{}) (string, error) {
+	if !transform.canEncode {
+		return "", ErrEncodeNotSupported
+	}
+
+	commandJSON, err := json.Marshal(command)
+	if err != nil {
+		return "", err
+	}
+
+	transform.lock.Lock()
+	defer transform.lock.Unlock()
+
+	result, err := transform.run(func() (otto.Value, error) {
+		parsed, err := transform.vm.Call("JSON.parse", nil, string(commandJSON))
+		if err != nil {
+			return parsed, err
+		}
+		return transform.vm.Call("encode", nil, parsed)
+	})
+	if err != nil {
+		return "", err
+	}
+	if !result.IsString() {
+		return "", errors.New("encode must return a string")
+	}
+	return result.String(), nil
+}
+
+//run : Runs script code, interrupting it once the timeout expires
+func (transform *scriptTransform) run(code func() (otto.Value, error)) (value otto.Value, err error) {
+	//Prevents the timer from queuing an interrupt once the code has completed
+	interruptLock := &sync.Mutex{}
+	completed := false
+
+	timer := time.AfterFunc(transform.timeout, func() {
+		interruptLock.Lock()
+		defer interruptLock.Unlock()
+		if !completed {
+			transform.vm.Interrupt <- func() {
+				panic(errScriptTimeout)
+			}
+		}
+	})
+
+	defer func() {
+		interruptLock.Lock()
+		completed = true
+		interruptLock.Unlock()
+		timer.Stop()
+
+		//Discard an interrupt that was queued but not handled before the code completed
+		select {
+		case <-transform.vm.Interrupt:
+		default:
+		}
+
+		if caught := recover(); caught != nil {
+			if caught == errScriptTimeout {
+				err = errScriptTimeout
+				return
+			}
+			err = fmt.Errorf("Payload transform script failed: %v", caught)
+		}
+	}()
+
+	return code()
+}
@@ -0,0 +1,121 @@
+package PayloadTransform
+
+import (
+	"errors"
+	"sort"
+	"strings"
+)
+
+//SplitTransformName : Splits key/value pairs such as T=21.4;H=55 into a JSON object
+const SplitTransformName = "split"
+
+func init() {
+	RegisterTransform(SplitTransformName, newSplitTransform)
+}
+
+//splitTransform : Splits a frame into fields on separator and each field into a key and value
+//on keyValueSeparator. Fields without a key are named by fieldNames, in order.
+//
+// {"type": "split", "separator": ";", "keyValueSeparator": "=", "numbers": true, "trim": "\r\n"}
+//
+// Encode reverses the transform, joining the attributes of a JSON object sorted by key
+// (or in fieldNames order, every field is then required) and appending the suffix.
+type splitTransform struct {
+	separator         string
+	keyValueSeparator string
+	fieldNames        []string
+	numbers           bool
+	trim              string
+	suffix            string
+}
+
+func newSplitTransform(settings map[string]interface{}) (Transform, error) {
+	transform := &splitTransform{
+		separator:         settingString(settings, "separator", ";"),
+		keyValueSeparator: settingString(settings, "keyValueSeparator", "="),
+		numbers:           settingBool(settings, "numbers", true),
+		trim:              settingString(settings, "trim", "\r\n"),
+		suffix:            settingString(settings, "suffix", "\r\n"),
+	}
+
+	if transform.separator == "" {
+		return nil, errors.New("The split transform separator must not be empty")
+	}
+
+	if names, ok := settings["fieldNames"].([]interface{}); ok {
+		for _, name := range names {
+			nameString, ok := name.(string)
+			if !ok || nameString == "" {
+				return nil, errors.New("The split transform fieldNames must be strings")
+			}
+			transform.fieldNames = append(transform.fieldNames, nameString)
+		}
+	}
+
+	return transform, nil
+}
+
+func (transform *splitTransform) Name() string {
+	return SplitTransformName
+}
+
+func (transform *splitTransform) Decode(frame string) (interface{}, error) {
+	frame = strings.Trim(frame, transform.trim)
+	if frame == "" {
+		return nil, errors.New("Empty frame")
+	}
+
+	result := map[string]interface{}{}
+	for index, field := range strings.Split(frame, transform.separator) {
+		key, value := "", field
+		if transform.keyValueSeparator != "" {
+			if parts := strings.SplitN(field, transform.keyValueSeparator, 2); len(parts) == 2 {
+				key, value = strings.TrimSpace(parts[0]), parts[1]
+			}
+		}
+
+		if key == "" {
+			if index >= len(transform.fieldNames) {
+				return nil, errors.New("Field " + field + " has no key and no matching entry in fieldNames")
+			}
+			key = transform.fieldNames[index]
+		}
+		result[key] = convertValue(value, transform.numbers)
+	}
+
+	return result, nil
+}
+
+func (transform *splitTransform) Encode(command interface{}) (string, error) {
+	object, ok := command.(map[string]interface{})
+	if !ok {
+		return "", errors.New("The split transform can only encode JSON objects")
+	}
+
+	keys := transform.fieldNames
+	named := len(keys) > 0
+	if !named {
+		for key := range object {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+	}
+
+	fields := make([]string, 0, len(keys))
+	for _, key := range keys {
+		value, ok := object[key]
+		if !ok {
+			if named {
+				return "", errors.New("Command is missing " + key)
+			}
+			continue
+		}
+		if named {
+			fields = append(fields, formatValue(value))
+		} else {
+			fields = append(fields, key+transform.keyValueSeparator+formatValue(value))
+		}
+	}
+
+	return strings.Join(fields, transform.separator) + transform.suffix, nil
+}
@@ -0,0 +1,120 @@
+package PayloadTransform
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+//ErrEncodeNotSupported : Returned by transforms that have no reverse transform
+var ErrEncodeNotSupported = errors.New("The payload transform does not support encoding commands")
+
+//Transform : Converts between the bytes exchanged with the serial device and the JSON values
+//exchanged with the platform
+type Transform interface {
+	//Name : The name the transform was registered with
+	Name() string
+
+	//Decode : Converts a frame read from the serial device into a value published as JSON
+	Decode(frame string) (interface{}, error)
+
+	//Encode : Converts a JSON command into the bytes written to the serial device.
+	//Returns ErrEncodeNotSupported if the transform has no reverse transform.
+	Encode(command interface{}) (string, error)
+}
+
+//TransformFactory : Creates a transform from the "transform" adapter setting
+type TransformFactory func(settings map[string]interface{}) (Transform, error)
+
+var (
+	registryLock = &sync.Mutex{}
+	registry     = map[string]TransformFactory{}
+)
+
+//RegisterTransform : Makes a transform selectable by type. Registering the same type twice replaces the factory
+func RegisterTransform(name string, factory TransformFactory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	registry[strings.ToLower(name)] = factory
+}
+
+//CreateTransform : Creates the transform registered under the given type
+func CreateTransform(name string, settings map[string]interface{}) (Transform, error) {
+	registryLock.Lock()
+	factory, ok := registry[strings.ToLower(name)]
+	registryLock.Unlock()
+
+	if !ok {
+		log.Printf("[ERROR] CreateTransform - Unknown payload transform: %s\n", name)
+		return nil, fmt.Errorf("Unknown payload transform %q, available transforms: %s", name, strings.Join(TransformNames(), ", "))
+	}
+
+	if settings == nil {
+		settings = make(map[string]interface{})
+	}
+
+	log.Printf("[INFO] CreateTransform - Creating payload transform %s\n", name)
+	return factory(settings)
+}
+
+//TransformNames : The sorted names of all registered transforms
+func TransformNames() []string {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//settingString : Returns the setting as a string, or the default value when it is not present
+func settingString(settings map[string]interface{}, key string, defaultValue string) string {
+	if value, ok := settings[key]; ok && value != nil {
+		if str, ok := value.(string); ok {
+			return str
+		}
+		return fmt.Sprint(value)
+	}
+	return defaultValue
+}
+
+//settingBool : Returns the setting as a bool, or the default value when it is not present
+func settingBool(settings map[string]interface{}, key string, defaultValue bool) bool {
+	if value, ok := settings[key].(bool); ok {
+		return value
+	}
+	return defaultValue
+}
+
+//convertValue : Converts numeric strings to numbers when numbers is true
+func convertValue(value string, numbers bool) interface{} {
+	value = strings.TrimSpace(value)
+	if numbers {
+		if number, err := strconv.ParseFloat(value, 64); err == nil {
+			return number
+		}
+	}
+	return value
+}
+
+//formatValue : Formats a JSON value for writing to the device
+func formatValue(value interface{}) string {
+	switch typed := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return typed
+	case float64:
+		return strconv.FormatFloat(typed, 'f', -1, 64)
+	default:
+		return fmt.Sprint(typed)
+	}
+}
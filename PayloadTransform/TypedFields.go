@@ -0,0 +1,126 @@
+package PayloadTransform
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+//Field types supported by the declarative decoders
+const (
+	fieldTypeString = "string"
+	fieldTypeInt    = "int"
+	fieldTypeFloat  = "float"
+	fieldTypeBool   = "bool"
+)
+
+//typedField : A named field of a line and the type it is converted to
+//
+// {"name": "temperature", "type": "float", "unit": "C", "width": 6, "required": true}
+//
+// Fields with a unit are decoded as {"value": 21.4, "unit": "C"}. A field may also be given as
+// a string, which is a string field of that name.
+type typedField struct {
+	name      string
+	fieldType string
+	unit      string
+	width     int
+	required  bool
+}
+
+//parseTypedFields : Reads the field list named key from the transform settings
+func parseTypedFields(settings map[string]interface{}, key string, withWidth bool) ([]typedField, error) {
+	list, ok := settings[key].([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, fmt.Errorf("%s must be a non-empty array", key)
+	}
+
+	fields := make([]typedField, 0, len(list))
+	names := map[string]bool{}
+	for index, value := range list {
+		field := typedField{fieldType: fieldTypeString, required: true}
+
+		switch typed := value.(type) {
+		case string:
+			field.name = typed
+		case map[string]interface{}:
+			field.name = settingString(typed, "name", "")
+			field.fieldType = strings.ToLower(settingString(typed, "type", fieldTypeString))
+			field.unit = settingString(typed, "unit", "")
+			field.required = settingBool(typed, "required", true)
+			if width, ok := typed["width"].(float64); ok {
+				field.width = int(width)
+			}
+		default:
+			return nil, fmt.Errorf("%s[%d] must be a name or an object", key, index)
+		}
+
+		if field.name == "" {
+			return nil, fmt.Errorf("%s[%d] requires a name", key, index)
+		}
+		if names[field.name] {
+			return nil, fmt.Errorf("%s[%d] duplicates the field %s", key, index, field.name)
+		}
+		names[field.name] = true
+
+		switch field.fieldType {
+		case fieldTypeString, fieldTypeInt, fieldTypeFloat, fieldTypeBool:
+		default:
+			return nil, fmt.Errorf("%s[%d] has unknown type %s, expected string, int, float or bool", key, index, field.fieldType)
+		}
+		if withWidth && field.width <= 0 {
+			return nil, fmt.Errorf("%s[%d] requires a positive width", key, index)
+		}
+
+		fields = append(fields, field)
+	}
+	return fields, nil
+}
+
+//convert : Converts the raw text of the field to its type, wrapping it with the unit
+func (field typedField) convert(raw string) (interface{}, error) {
+	raw = strings.TrimSpace(raw)
+
+	var value interface{}
+	var err error
+	switch field.fieldType {
+	case fieldTypeInt:
+		value, err = strconv.ParseInt(raw, 10, 64)
+	case fieldTypeFloat:
+		value, err = strconv.ParseFloat(raw, 64)
+	case fieldTypeBool:
+		value, err = parseBool(raw)
+	default:
+		value = raw
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Field %s: %q is not a valid %s", field.name, raw, field.fieldType)
+	}
+
+	if field.unit != "" {
+		return map[string]interface{}{"value": value, "unit": field.unit}, nil
+	}
+	return value, nil
+}
+
+//format : Formats a command attribute for writing to the device. Values wrapped with a unit are unwrapped.
+func (field typedField) format(value interface{}) string {
+	if wrapped, ok := value.(map[string]interface{}); ok {
+		if inner, ok := wrapped["value"]; ok {
+			value = inner
+		}
+	}
+	return formatValue(value)
+}
+
+//parseBool : Accepts the usual spellings of a datalogger flag
+func parseBool(raw string) (bool, error) {
+	switch strings.ToLower(raw) {
+	case "1", "true", "t", "yes", "y", "on":
+		return true, nil
+	case "0", "false", "f", "no", "n", "off":
+		return false, nil
+	}
+	return false, errors.New("invalid bool")
+}
@@ -0,0 +1,109 @@
+package PlatformClient
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+
+	cb "github.com/clearblade/Go-SDK"
+	mqttTypes "github.com/clearblade/mqtt_parsing"
+	mqtt "github.com/clearblade/paho.mqtt.golang"
+)
+
+//mqttKeepAlive : Seconds between MQTT keep alive packets
+const mqttKeepAlive = 30
+
+//ClearBladeClient : A Client backed by the ClearBlade Go SDK device client
+type ClearBladeClient struct {
+	client *cb.DeviceClient
+}
+
+//NewClearBladeClient : Creates a client that authenticates as a device of the given system
+func NewClearBladeClient(platformURL, messagingURL, systemKey, systemSecret, deviceName, activeKey string) *ClearBladeClient {
+	return &ClearBladeClient{
+		client: cb.NewDeviceClientWithAddrs(platformURL, messagingURL, systemKey, systemSecret, deviceName, activeKey),
+	}
+}
+
+//Authenticate : Obtains a device token with the active key
+func (client *ClearBladeClient) Authenticate() error {
+	_, err := client.client.Authenticate()
+	return err
+}
+
+//Token : The device token
+func (client *ClearBladeClient) Token() string {
+	return client.client.DeviceToken
+}
+
+//SetToken : Uses a device token obtained elsewhere
+func (client *ClearBladeClient) SetToken(token string) {
+	client.client.DeviceToken = token
+}
+
+//ConnectMQTT : Connects to the platform MQTT broker. The SDK reconnects automatically after the
+//connection is lost. The SDK always requests a clean session, cleanSession is ignored.
+func (client *ClearBladeClient) ConnectMQTT(clientID string, tlsConfig *tls.Config, cleanSession bool, callbacks Callbacks) error {
+	cbCallbacks := cb.Callbacks{}
+	if callbacks.OnConnect != nil {
+		cbCallbacks.OnConnectCallback = func(mqtt.Client) { callbacks.OnConnect() }
+	}
+	if callbacks.OnConnectLost != nil {
+		cbCallbacks.OnConnectionLostCallback = func(_ mqtt.Client, err error) { callbacks.OnConnectLost(err) }
+	}
+
+	return client.client.InitializeMQTTWithCallback(clientID, "", mqttKeepAlive, tlsConfig, nil, &cbCallbacks)
+}
+
+//Disconnect : Closes the MQTT connection
+func (client *ClearBladeClient) Disconnect() error {
+	return client.client.Disconnect()
+}
+
+//IsConnected : True while the MQTT connection is established
+func (client *ClearBladeClient) IsConnected() bool {
+	return client.client.MQTTClient != nil && client.client.MQTTClient.IsConnected()
+}
+
+//Subscribe : Subscribes to a topic on the platform broker
+func (client *ClearBladeClient) Subscribe(topic string, qos int) (<-chan *mqttTypes.Publish, error) {
+	return client.client.Subscribe(topic, qos)
+}
+
+//Publish : Publishes to a topic on the platform broker
+func (client *ClearBladeClient) Publish(topic string, data []byte, qos int, retain bool) error {
+	if !retain {
+		return client.client.Publish(topic, data, qos)
+	}
+
+	//The SDK publish does not expose the retain flag, publish with the underlying MQTT client
+	if client.client.MQTTClient == nil {
+		return errors.New("MQTT client is not initialized")
+	}
+	token := client.client.MQTTClient.Publish(topic, byte(qos), true, data)
+	token.Wait()
+	return token.Error()
+}
+
+//QueryCollection : Retrieves the matching rows of a platform collection
+func (client *ClearBladeClient) QueryCollection(collection string, conditions map[string]interface{}) ([]map[string]interface{}, error) {
+	query := cb.NewQuery()
+	for column, value := range conditions {
+		query.EqualTo(column, value)
+	}
+
+	results, err := client.client.GetDataByName(collection, query)
+	if err != nil {
+		log.Printf("[DEBUG] QueryCollection - Error querying collection %s: %s\n", collection, err.Error())
+		return nil, err
+	}
+
+	data, _ := results["DATA"].([]interface{})
+	rows := make([]map[string]interface{}, 0, len(data))
+	for _, item := range data {
+		if row, ok := item.(map[string]interface{}); ok {
+			rows = append(rows, row)
+		}
+	}
+	return rows, nil
+}
@@ -0,0 +1,345 @@
+package PlatformClient
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+
+	mqttTypes "github.com/clearblade/mqtt_parsing"
+)
+
+const (
+	//AdapterConfigCollection : The collection the adapter reads its configuration from by default
+	AdapterConfigCollection = "adapter_config"
+
+	//fakeSubscriptionSize : Messages buffered per subscription before further messages are dropped
+	fakeSubscriptionSize = 100
+)
+
+//FakePlatform : An in-memory stand-in for the platform. It serves collections, such as the
+//adapter_config table, and routes messages between the clients created with NewClient so a test
+//harness can publish requests to the adapter and subscribe to its responses without a live platform.
+//
+//	platform := PlatformClient.NewFakePlatform()
+//	platform.AddAdapterConfig("SerialPortAdapter", "serial", map[string]interface{}{"serialPortName": "/dev/pts/3"})
+//	adapter, harness := platform.NewClient(), platform.NewClient()
+type FakePlatform struct {
+	lock        *sync.Mutex
+	collections map[string][]map[string]interface{}
+	retained    map[string]*mqttTypes.Publish
+	clients     []*FakeClient
+	authError   error
+	tokens      int
+	messageID   uint16
+}
+
+//NewFakePlatform : Creates a platform with no collections and no messages
+func NewFakePlatform() *FakePlatform {
+	return &FakePlatform{
+		lock:        &sync.Mutex{},
+		collections: map[string][]map[string]interface{}{},
+		retained:    map[string]*mqttTypes.Publish{},
+	}
+}
+
+//AddRow : Appends a row to a collection, creating the collection if needed
+func (platform *FakePlatform) AddRow(collection string, row map[string]interface{}) {
+	platform.lock.Lock()
+	defer platform.lock.Unlock()
+
+	platform.collections[collection] = append(platform.collections[collection], copyRow(row))
+}
+
+//AddAdapterConfig : Adds a row to the adapter_config collection. The settings are stored as the
+//JSON string the platform column holds.
+func (platform *FakePlatform) AddAdapterConfig(adapterName string, topicRoot string, settings map[string]interface{}) error {
+	row := map[string]interface{}{"adapter_name": adapterName, "topic_root": topicRoot}
+	if settings != nil {
+		settingsJSON, err := json.Marshal(settings)
+		if err != nil {
+			log.Printf("[ERROR] AddAdapterConfig - Unable to marshal the settings of %s: %s\n", adapterName, err.Error())
+			return err
+		}
+		row["adapter_settings"] = string(settingsJSON)
+	}
+
+	platform.AddRow(AdapterConfigCollection, row)
+	return nil
+}
+
+//RejectAuthentication : Fails authentication and new MQTT connections with err. A nil err accepts them again.
+func (platform *FakePlatform) RejectAuthentication(err error) {
+	platform.lock.Lock()
+	defer platform.lock.Unlock()
+
+	platform.authError = err
+}
+
+//NewClient : Creates a client of the platform
+func (platform *FakePlatform) NewClient() *FakeClient {
+	platform.lock.Lock()
+	defer platform.lock.Unlock()
+
+	client := &FakeClient{platform: platform}
+	platform.clients = append(platform.clients, client)
+	return client
+}
+
+//DropConnections : Disconnects every connected client as if the broker went away, invoking their
+//OnConnectLost callback with err. Their subscriptions are discarded.
+func (platform *FakePlatform) DropConnections(err error) {
+	platform.lock.Lock()
+	dropped := []*FakeClient{}
+	for _, client := range platform.clients {
+		if client.connected {
+			client.connected = false
+			client.dropped = true
+			client.subscriptions = nil
+			dropped = append(dropped, client)
+		}
+	}
+	platform.lock.Unlock()
+
+	for _, client := range dropped {
+		if client.callbacks.OnConnectLost != nil {
+			client.callbacks.OnConnectLost(err)
+		}
+	}
+}
+
+//RestoreConnections : Reconnects the clients disconnected by DropConnections, invoking their OnConnect callback
+func (platform *FakePlatform) RestoreConnections() {
+	platform.lock.Lock()
+	restored := []*FakeClient{}
+	for _, client := range platform.clients {
+		if client.dropped {
+			client.connected = true
+			client.dropped = false
+			restored = append(restored, client)
+		}
+	}
+	platform.lock.Unlock()
+
+	for _, client := range restored {
+		if client.callbacks.OnConnect != nil {
+			client.callbacks.OnConnect()
+		}
+	}
+}
+
+//FakeClient : A Client of a FakePlatform
+type FakeClient struct {
+	platform      *FakePlatform
+	token         string
+	connected     bool
+	dropped       bool
+	callbacks     Callbacks
+	subscriptions []*fakeSubscription
+}
+
+//fakeSubscription : Messages published to topics matching filter
+type fakeSubscription struct {
+	filter   string
+	messages chan *mqttTypes.Publish
+}
+
+//Authenticate : Issues a new token unless the platform rejects authentication
+func (client *FakeClient) Authenticate() error {
+	client.platform.lock.Lock()
+	defer client.platform.lock.Unlock()
+
+	if client.platform.authError != nil {
+		return client.platform.authError
+	}
+	client.platform.tokens++
+	client.token = "fake-token-" + strconv.Itoa(client.platform.tokens)
+	return nil
+}
+
+//Token : The token issued by Authenticate or set with SetToken
+func (client *FakeClient) Token() string {
+	client.platform.lock.Lock()
+	defer client.platform.lock.Unlock()
+
+	return client.token
+}
+
+//SetToken : Uses the given token. The fake platform accepts any token that is not empty.
+func (client *FakeClient) SetToken(token string) {
+	client.platform.lock.Lock()
+	defer client.platform.lock.Unlock()
+
+	client.token = token
+}
+
+//ConnectMQTT : Connects the client and invokes OnConnect from a separate go routine, as the MQTT client does
+func (client *FakeClient) ConnectMQTT(clientID string, tlsConfig *tls.Config, cleanSession bool, callbacks Callbacks) error {
+	client.platform.lock.Lock()
+	defer client.platform.lock.Unlock()
+
+	if client.platform.authError != nil {
+		return client.platform.authError
+	}
+	if client.token == "" {
+		return errors.New("Not Authorized")
+	}
+
+	client.connected = true
+	client.dropped = false
+	client.callbacks = callbacks
+	if callbacks.OnConnect != nil {
+		go callbacks.OnConnect()
+	}
+	return nil
+}
+
+//Disconnect : Disconnects the client and discards its subscriptions
+func (client *FakeClient) Disconnect() error {
+	client.platform.lock.Lock()
+	defer client.platform.lock.Unlock()
+
+	client.connected = false
+	client.dropped = false
+	client.subscriptions = nil
+	return nil
+}
+
+//IsConnected : True between ConnectMQTT and Disconnect, except while the connections are dropped
+func (client *FakeClient) IsConnected() bool {
+	client.platform.lock.Lock()
+	defer client.platform.lock.Unlock()
+
+	return client.connected
+}
+
+//Subscribe : Delivers the messages published to matching topics, starting with the matching retained messages
+func (client *FakeClient) Subscribe(topic string, qos int) (<-chan *mqttTypes.Publish, error) {
+	client.platform.lock.Lock()
+	defer client.platform.lock.Unlock()
+
+	if !client.connected {
+		return nil, errors.New("Not connected")
+	}
+	if err := validateFilter(topic); err != nil {
+		return nil, err
+	}
+
+	subscription := &fakeSubscription{filter: topic, messages: make(chan *mqttTypes.Publish, fakeSubscriptionSize)}
+	client.subscriptions = append(client.subscriptions, subscription)
+
+	for retainedTopic, message := range client.platform.retained {
+		if topicMatches(topic, retainedTopic) {
+			subscription.deliver(message)
+		}
+	}
+	return subscription.messages, nil
+}
+
+//Publish : Delivers data to every subscription of a connected client that matches topic. A retained
+//message replaces the previous one of the topic, an empty retained message clears it.
+func (client *FakeClient) Publish(topic string, data []byte, qos int, retain bool) error {
+	client.platform.lock.Lock()
+	defer client.platform.lock.Unlock()
+
+	if !client.connected {
+		return errors.New("Not connected")
+	}
+	if topic == "" || strings.ContainsAny(topic, "+#") {
+		return fmt.Errorf("Invalid topic %q", topic)
+	}
+
+	client.platform.messageID++
+	payload := make([]byte, len(data))
+	copy(payload, data)
+	message := &mqttTypes.Publish{
+		Header:    &mqttTypes.StaticHeader{QOS: uint8(qos)},
+		Topic:     mqttTypes.TopicPath{Whole: topic, Split: strings.Split(topic, "/")},
+		MessageId: client.platform.messageID,
+		Payload:   payload,
+	}
+
+	if retain {
+		if len(payload) == 0 {
+			delete(client.platform.retained, topic)
+		} else {
+			retained := *message
+			retained.Header = &mqttTypes.StaticHeader{QOS: uint8(qos), Retain: true}
+			client.platform.retained[topic] = &retained
+		}
+	}
+
+	for _, subscriber := range client.platform.clients {
+		if !subscriber.connected {
+			continue
+		}
+		for _, subscription := range subscriber.subscriptions {
+			if topicMatches(subscription.filter, topic) {
+				subscription.deliver(message)
+			}
+		}
+	}
+	return nil
+}
+
+//QueryCollection : The rows of the collection whose columns equal the conditions
+func (client *FakeClient) QueryCollection(collection string, conditions map[string]interface{}) ([]map[string]interface{}, error) {
+	client.platform.lock.Lock()
+	defer client.platform.lock.Unlock()
+
+	if client.token == "" {
+		return nil, errors.New("401 Unauthorized")
+	}
+
+	return queryRows(client.platform.collections, collection, conditions)
+}
+
+//deliver : Queues a message without blocking the publisher. The message is dropped when the subscriber is not keeping up.
+func (subscription *fakeSubscription) deliver(message *mqttTypes.Publish) {
+	select {
+	case subscription.messages <- message:
+	default:
+		log.Printf("[WARN] FakePlatform - Subscription %s is full, dropping message published to %s\n", subscription.filter, message.Topic.Whole)
+	}
+}
+
+//validateFilter : Checks that the + and # wildcards occupy whole levels and that # is the last level
+func validateFilter(filter string) error {
+	if filter == "" {
+		return errors.New("Topic filter must not be empty")
+	}
+
+	levels := strings.Split(filter, "/")
+	for index, level := range levels {
+		if strings.Contains(level, "#") && (level != "#" || index != len(levels)-1) {
+			return fmt.Errorf("Invalid topic filter %q, # must be the last level", filter)
+		}
+		if strings.Contains(level, "+") && level != "+" {
+			return fmt.Errorf("Invalid topic filter %q, + must occupy a whole level", filter)
+		}
+	}
+	return nil
+}
+
+//topicMatches : True when topic matches the MQTT topic filter
+func topicMatches(filter string, topic string) bool {
+	filterLevels := strings.Split(filter, "/")
+	topicLevels := strings.Split(topic, "/")
+
+	for index, level := range filterLevels {
+		if level == "#" {
+			return true
+		}
+		if index >= len(topicLevels) {
+			return false
+		}
+		if level != "+" && level != topicLevels[index] {
+			return false
+		}
+	}
+	return len(filterLevels) == len(topicLevels)
+}
@@ -0,0 +1,264 @@
+package PlatformClient
+
+import (
+	"crypto/tls"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	mqttTypes "github.com/clearblade/mqtt_parsing"
+	mqtt "github.com/clearblade/paho.mqtt.golang"
+)
+
+const (
+	//mqttSubscriptionSize : Messages buffered in the channel of a subscription
+	mqttSubscriptionSize = 100
+
+	//mqttSubscriptionLimit : Messages queued per subscription while the adapter falls behind. Only
+	//further messages are dropped, the paho callback never waits for the adapter: that would hold
+	//up the acknowledgements and keepalive responses the adapter's own publishes wait for.
+	mqttSubscriptionLimit = 10000
+
+	//mqttDisconnectQuiesce : Milliseconds given to in-flight messages when disconnecting
+	mqttDisconnectQuiesce = 250
+)
+
+//MQTTClient : A Client for a generic MQTT broker (ex. Mosquitto). The broker authenticates the
+//connection with the username and password, so Authenticate does nothing. Collections are not
+//available from the broker, QueryCollection serves the rows the client was created with instead.
+type MQTTClient struct {
+	brokerURL   string
+	username    string
+	password    string
+	collections map[string][]map[string]interface{}
+
+	lock   *sync.Mutex
+	client mqtt.Client
+
+	//The subscription of each topic, released when it is replaced or the client disconnects
+	subscriptions map[string]*mqttSubscription
+}
+
+//mqttSubscription : Queues the messages of a topic received by the paho callback and forwards them
+//to the channel read by the adapter
+type mqttSubscription struct {
+	topic    string
+	messages chan *mqttTypes.Publish
+
+	lock    sync.Mutex
+	queue   []*mqttTypes.Publish
+	dropped int
+
+	//Signalled when a message is queued
+	ready chan struct{}
+	//Closed when the channel will no longer be read
+	released chan struct{}
+}
+
+//NewMQTTClient : Creates a client of the broker at brokerURL (tcp://host:1883, ssl://host:8883 or
+//ws://host:port/path) that serves the given collections, keyed by collection name
+func NewMQTTClient(brokerURL, username, password string, collections map[string][]map[string]interface{}) *MQTTClient {
+	if collections == nil {
+		collections = map[string][]map[string]interface{}{}
+	}
+	return &MQTTClient{
+		brokerURL:     brokerURL,
+		username:      username,
+		password:      password,
+		collections:   collections,
+		lock:          &sync.Mutex{},
+		subscriptions: map[string]*mqttSubscription{},
+	}
+}
+
+//Authenticate : Nothing to do, the broker authenticates the connection
+func (client *MQTTClient) Authenticate() error {
+	return nil
+}
+
+//Token : The broker does not issue tokens
+func (client *MQTTClient) Token() string {
+	return ""
+}
+
+//SetToken : Ignored, the broker does not use tokens
+func (client *MQTTClient) SetToken(token string) {
+	log.Println("[DEBUG] MQTTClient - Tokens are not used by generic MQTT brokers, ignoring token")
+}
+
+//ConnectMQTT : Connects to the broker. The client reconnects automatically after the connection is lost.
+func (client *MQTTClient) ConnectMQTT(clientID string, tlsConfig *tls.Config, cleanSession bool, callbacks Callbacks) error {
+	options := mqtt.NewClientOptions().
+		AddBroker(client.brokerURL).
+		SetClientID(clientID).
+		SetUsername(client.username).
+		SetPassword(client.password).
+		SetCleanSession(cleanSession).
+		SetAutoReconnect(true).
+		SetKeepAlive(mqttKeepAlive * time.Second)
+	if tlsConfig != nil {
+		options.SetTLSConfig(tlsConfig)
+	}
+	if callbacks.OnConnect != nil {
+		options.SetOnConnectHandler(func(mqtt.Client) { callbacks.OnConnect() })
+	}
+	options.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		client.releaseSubscriptions()
+		if callbacks.OnConnectLost != nil {
+			callbacks.OnConnectLost(err)
+		}
+	})
+
+	mqttClient := mqtt.NewClient(options)
+	token := mqttClient.Connect()
+	token.Wait()
+	if err := token.Error(); err != nil {
+		log.Printf("[ERROR] ConnectMQTT - Unable to connect to %s: %s\n", client.brokerURL, err.Error())
+		return err
+	}
+
+	client.lock.Lock()
+	client.client = mqttClient
+	client.lock.Unlock()
+	return nil
+}
+
+//Disconnect : Closes the connection to the broker
+func (client *MQTTClient) Disconnect() error {
+	mqttClient, err := client.connection()
+	if err != nil {
+		return err
+	}
+	client.releaseSubscriptions()
+	mqttClient.Disconnect(mqttDisconnectQuiesce)
+	return nil
+}
+
+//IsConnected : True while the connection to the broker is established
+func (client *MQTTClient) IsConnected() bool {
+	mqttClient, err := client.connection()
+	return err == nil && mqttClient.IsConnected()
+}
+
+//Subscribe : Subscribes to a topic on the broker
+func (client *MQTTClient) Subscribe(topic string, qos int) (<-chan *mqttTypes.Publish, error) {
+	mqttClient, err := client.connection()
+	if err != nil {
+		return nil, err
+	}
+
+	subscription := &mqttSubscription{
+		topic:    topic,
+		messages: make(chan *mqttTypes.Publish, mqttSubscriptionSize),
+		ready:    make(chan struct{}, 1),
+		released: make(chan struct{}),
+	}
+	client.lock.Lock()
+	if previous, ok := client.subscriptions[topic]; ok {
+		close(previous.released)
+	}
+	client.subscriptions[topic] = subscription
+	client.lock.Unlock()
+	go subscription.forward()
+
+	token := mqttClient.Subscribe(topic, byte(qos), func(_ mqtt.Client, message mqtt.Message) {
+		subscription.deliver(&mqttTypes.Publish{
+			Header:    &mqttTypes.StaticHeader{DUP: message.Duplicate(), QOS: message.Qos(), Retain: message.Retained()},
+			Topic:     mqttTypes.TopicPath{Whole: message.Topic(), Split: strings.Split(message.Topic(), "/")},
+			MessageId: message.MessageID(),
+			Payload:   message.Payload(),
+		})
+	})
+	token.Wait()
+	if err := token.Error(); err != nil {
+		return nil, err
+	}
+	return subscription.messages, nil
+}
+
+//Publish : Publishes to a topic on the broker
+func (client *MQTTClient) Publish(topic string, data []byte, qos int, retain bool) error {
+	mqttClient, err := client.connection()
+	if err != nil {
+		return err
+	}
+
+	token := mqttClient.Publish(topic, byte(qos), retain, data)
+	token.Wait()
+	return token.Error()
+}
+
+//QueryCollection : The matching rows of the collections the client was created with
+func (client *MQTTClient) QueryCollection(collection string, conditions map[string]interface{}) ([]map[string]interface{}, error) {
+	return queryRows(client.collections, collection, conditions)
+}
+
+//releaseSubscriptions : Stops forwarding the messages of subscriptions that will not be read again
+func (client *MQTTClient) releaseSubscriptions() {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	for topic, subscription := range client.subscriptions {
+		close(subscription.released)
+		delete(client.subscriptions, topic)
+	}
+}
+
+//deliver : Queues a message received by the paho callback. Never waits, the message is dropped
+//and counted when mqttSubscriptionLimit messages are already queued.
+func (subscription *mqttSubscription) deliver(message *mqttTypes.Publish) {
+	subscription.lock.Lock()
+	if len(subscription.queue) >= mqttSubscriptionLimit {
+		subscription.dropped++
+		dropped := subscription.dropped
+		subscription.lock.Unlock()
+		log.Printf("[WARN] MQTTClient - %d messages of %s are waiting for the adapter, dropping message published to %s (%d dropped)\n", mqttSubscriptionLimit, subscription.topic, message.Topic.Whole, dropped)
+		return
+	}
+	subscription.queue = append(subscription.queue, message)
+	subscription.lock.Unlock()
+
+	select {
+	case subscription.ready <- struct{}{}:
+	default:
+	}
+}
+
+//forward : Moves the queued messages to the channel read by the adapter until the subscription is released
+func (subscription *mqttSubscription) forward() {
+	for {
+		subscription.lock.Lock()
+		if len(subscription.queue) == 0 {
+			subscription.lock.Unlock()
+			select {
+			case <-subscription.ready:
+				continue
+			case <-subscription.released:
+				return
+			}
+		}
+		message := subscription.queue[0]
+		subscription.queue[0] = nil
+		subscription.queue = subscription.queue[1:]
+		subscription.lock.Unlock()
+
+		select {
+		case subscription.messages <- message:
+		case <-subscription.released:
+			return
+		}
+	}
+}
+
+//connection : The MQTT client created by ConnectMQTT
+func (client *MQTTClient) connection() (mqtt.Client, error) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	if client.client == nil {
+		return nil, errors.New("MQTT client is not initialized")
+	}
+	return client.client, nil
+}
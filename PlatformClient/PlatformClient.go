@@ -0,0 +1,84 @@
+package PlatformClient
+
+import (
+	"crypto/tls"
+	"fmt"
+
+	mqttTypes "github.com/clearblade/mqtt_parsing"
+)
+
+//Callbacks : Invoked by the client as the MQTT connection changes state
+type Callbacks struct {
+	//OnConnect : The connection to the broker was established or re-established
+	OnConnect func()
+
+	//OnConnectLost : The connection to the broker was lost. The client keeps trying to reconnect.
+	OnConnectLost func(err error)
+}
+
+//Client : The platform operations used by the adapter. ClearBladeClient talks to a ClearBlade
+//platform, MQTTClient to a generic MQTT broker and FakePlatform provides clients that run entirely
+//in memory for integration tests.
+type Client interface {
+	//Authenticate : Obtains a token with the credentials the client was created with
+	Authenticate() error
+
+	//Token : The token used by the REST API and the MQTT connection
+	Token() string
+
+	//SetToken : Uses a token obtained elsewhere (ex. issued by the edge) instead of authenticating
+	SetToken(token string)
+
+	//ConnectMQTT : Connects to the MQTT broker with the current token. Clients that support it
+	//resume a persistent session (cleanSession false) when the broker has one for clientID, only
+	//the MQTTClient used with a generic broker does. The ClearBladeClient always starts a clean session.
+	ConnectMQTT(clientID string, tlsConfig *tls.Config, cleanSession bool, callbacks Callbacks) error
+
+	//Disconnect : Closes the MQTT connection and stops reconnecting
+	Disconnect() error
+
+	//IsConnected : True while the MQTT connection is established
+	IsConnected() bool
+
+	//Subscribe : Delivers the messages published to topic, which may contain the + and # wildcards
+	Subscribe(topic string, qos int) (<-chan *mqttTypes.Publish, error)
+
+	//Publish : Publishes data to topic
+	Publish(topic string, data []byte, qos int, retain bool) error
+
+	//QueryCollection : The rows of a collection whose columns equal the given values. Empty
+	//conditions return every row.
+	QueryCollection(collection string, conditions map[string]interface{}) ([]map[string]interface{}, error)
+}
+
+//queryRows : Copies of the rows of an in-memory collection whose columns equal the conditions
+func queryRows(collections map[string][]map[string]interface{}, collection string, conditions map[string]interface{}) ([]map[string]interface{}, error) {
+	rows, ok := collections[collection]
+	if !ok {
+		return nil, fmt.Errorf("Collection %s not found", collection)
+	}
+
+	matches := []map[string]interface{}{}
+	for _, row := range rows {
+		matched := true
+		for column, value := range conditions {
+			if row[column] != value {
+				matched = false
+				break
+			}
+		}
+		if matched {
+			matches = append(matches, copyRow(row))
+		}
+	}
+	return matches, nil
+}
+
+//copyRow : A shallow copy of a row, so callers cannot modify the stored collection
+func copyRow(row map[string]interface{}) map[string]interface{} {
+	copied := make(map[string]interface{}, len(row))
+	for column, value := range row {
+		copied[column] = value
+	}
+	return copied
+}
@@ -0,0 +1,285 @@
+package Simulator
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+)
+
+//ModbusDeviceName : A Modbus RTU slave with a register map
+const ModbusDeviceName = "modbus"
+
+func init() {
+	RegisterDevice(ModbusDeviceName, newModbusDevice)
+}
+
+//Modbus function codes answered by the slave
+const (
+	modbusReadCoils              = 0x01
+	modbusReadDiscreteInputs     = 0x02
+	modbusReadHoldingRegisters   = 0x03
+	modbusReadInputRegisters     = 0x04
+	modbusWriteSingleCoil        = 0x05
+	modbusWriteSingleRegister    = 0x06
+	modbusWriteMultipleRegisters = 0x10
+)
+
+//Modbus exception codes
+const (
+	modbusIllegalFunction  = 0x01
+	modbusIllegalAddress   = 0x02
+	modbusIllegalDataValue = 0x03
+)
+
+const (
+	modbusBroadcastUnitID    = 0x00
+	modbusMaxRegistersPerPDU = 125
+)
+
+//modbusDevice : Answers Modbus RTU requests addressed to unitId from a register map. Registers
+//that are not in the map answer with the illegal data address exception.
+//
+// {"unitId": 1, "holdingRegisters": {"0": 215, "1": 550}, "inputRegisters": {"0": 1}, "coils": {"0": true}}
+//
+// Discrete inputs are read from the coils. Writes update the holding registers and coils.
+// Broadcast writes (unit 0) are applied without a reply.
+type modbusDevice struct {
+	unitID           byte
+	holdingRegisters map[uint16]uint16
+	inputRegisters   map[uint16]uint16
+	coils            map[uint16]bool
+}
+
+func newModbusDevice(settings map[string]interface{}) (Device, error) {
+	unitID := settingNumber(settings, "unitId", 1)
+	if unitID < 1 || unitID > 247 {
+		return nil, errors.New("The modbus unitId must be between 1 and 247")
+	}
+
+	device := &modbusDevice{unitID: byte(unitID), coils: map[uint16]bool{}}
+
+	var err error
+	if device.holdingRegisters, err = parseRegisterMap(settings, "holdingRegisters"); err != nil {
+		return nil, err
+	}
+	if device.inputRegisters, err = parseRegisterMap(settings, "inputRegisters"); err != nil {
+		return nil, err
+	}
+	if coils, ok := settings["coils"].(map[string]interface{}); ok {
+		for address, value := range coils {
+			number, err := strconv.ParseUint(address, 0, 16)
+			state, ok := value.(bool)
+			if err != nil || !ok {
+				return nil, fmt.Errorf("Invalid coil %s, expected an address and true or false", address)
+			}
+			device.coils[uint16(number)] = state
+		}
+	}
+	return device, nil
+}
+
+//parseRegisterMap : Reads a map of register address to value
+func parseRegisterMap(settings map[string]interface{}, key string) (map[uint16]uint16, error) {
+	registers := map[uint16]uint16{}
+	registerJSON, ok := settings[key].(map[string]interface{})
+	if !ok {
+		return registers, nil
+	}
+
+	for address, value := range registerJSON {
+		number, err := strconv.ParseUint(address, 0, 16)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s address %s", key, address)
+		}
+		register, ok := value.(float64)
+		if !ok || register < -32768 || register > 65535 || register != float64(int(register)) {
+			return nil, fmt.Errorf("Invalid %s value at %s, expected a 16 bit integer", key, address)
+		}
+		registers[uint16(number)] = uint16(int32(register))
+	}
+	return registers, nil
+}
+
+func (device *modbusDevice) Name() string {
+	return ModbusDeviceName
+}
+
+func (device *modbusDevice) Run(ctx context.Context, port io.ReadWriter) error {
+	received := []byte{}
+
+	chunks := readChunks(ctx, port)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case chunk, ok := <-chunks:
+			if !ok {
+				return nil
+			}
+
+			received = append(received, chunk...)
+			for {
+				length := modbusRequestLength(received)
+				if length == 0 || len(received) < length {
+					break
+				}
+
+				frame := received[:length]
+				if modbusCRC(frame[:length-2]) != binary.LittleEndian.Uint16(frame[length-2:]) {
+					//Resynchronize on the next byte
+					log.Printf("[WARN] modbusDevice - CRC mismatch, discarding byte %#02x\n", received[0])
+					received = received[1:]
+					continue
+				}
+				received = received[length:]
+
+				if response := device.handle(frame[:length-2]); response != nil {
+					write(port, appendModbusCRC(response))
+				}
+			}
+		}
+	}
+}
+
+//modbusRequestLength : The length of the request at the start of data including the CRC, or 0 when
+//more data is needed to know. Unknown function codes are assumed to have no data.
+func modbusRequestLength(data []byte) int {
+	if len(data) < 2 {
+		return 0
+	}
+	switch data[1] {
+	case modbusReadCoils, modbusReadDiscreteInputs, modbusReadHoldingRegisters, modbusReadInputRegisters, modbusWriteSingleCoil, modbusWriteSingleRegister:
+		return 8
+	case modbusWriteMultipleRegisters:
+		if len(data) < 7 {
+			return 0
+		}
+		return 9 + int(data[6])
+	}
+	return 4
+}
+
+//handle : Returns the response PDU, without CRC, or nil when no reply is sent
+func (device *modbusDevice) handle(request []byte) []byte {
+	unit, function := request[0], request[1]
+	if unit != device.unitID && unit != modbusBroadcastUnitID {
+		return nil
+	}
+	log.Printf("[DEBUG] modbusDevice - Function %#02x received for unit %d\n", function, unit)
+
+	response, exception := device.execute(function, request[2:])
+	if unit == modbusBroadcastUnitID {
+		return nil
+	}
+	if exception != 0 {
+		log.Printf("[INFO] modbusDevice - Function %#02x failed with exception %d\n", function, exception)
+		return []byte{unit, function | 0x80, exception}
+	}
+	return append([]byte{unit, function}, response...)
+}
+
+//execute : Applies the function to the register map, returning the response data or an exception code
+func (device *modbusDevice) execute(function byte, data []byte) ([]byte, byte) {
+	switch function {
+	case modbusReadHoldingRegisters, modbusReadInputRegisters:
+		registers := device.holdingRegisters
+		if function == modbusReadInputRegisters {
+			registers = device.inputRegisters
+		}
+		address, count := binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+		if count == 0 || count > modbusMaxRegistersPerPDU {
+			return nil, modbusIllegalDataValue
+		}
+		response := []byte{byte(count * 2)}
+		for offset := uint16(0); offset < count; offset++ {
+			value, ok := registers[address+offset]
+			if !ok {
+				return nil, modbusIllegalAddress
+			}
+			response = append(response, byte(value>>8), byte(value))
+		}
+		return response, 0
+
+	case modbusReadCoils, modbusReadDiscreteInputs:
+		address, count := binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+		if count == 0 || count > 2000 {
+			return nil, modbusIllegalDataValue
+		}
+		response := make([]byte, 1+(count+7)/8)
+		response[0] = byte(len(response) - 1)
+		for offset := uint16(0); offset < count; offset++ {
+			state, ok := device.coils[address+offset]
+			if !ok {
+				return nil, modbusIllegalAddress
+			}
+			if state {
+				response[1+offset/8] |= 1 << (offset % 8)
+			}
+		}
+		return response, 0
+
+	case modbusWriteSingleCoil:
+		address, value := binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+		if value != 0xFF00 && value != 0x0000 {
+			return nil, modbusIllegalDataValue
+		}
+		if _, ok := device.coils[address]; !ok {
+			return nil, modbusIllegalAddress
+		}
+		device.coils[address] = value == 0xFF00
+		return data[:4], 0
+
+	case modbusWriteSingleRegister:
+		address, value := binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+		if _, ok := device.holdingRegisters[address]; !ok {
+			return nil, modbusIllegalAddress
+		}
+		device.holdingRegisters[address] = value
+		log.Printf("[INFO] modbusDevice - Holding register %d set to %d\n", address, value)
+		return data[:4], 0
+
+	case modbusWriteMultipleRegisters:
+		address, count := binary.BigEndian.Uint16(data), binary.BigEndian.Uint16(data[2:])
+		if count == 0 || count > 123 || int(data[4]) != int(count)*2 {
+			return nil, modbusIllegalDataValue
+		}
+		for offset := uint16(0); offset < count; offset++ {
+			if _, ok := device.holdingRegisters[address+offset]; !ok {
+				return nil, modbusIllegalAddress
+			}
+		}
+		for offset := uint16(0); offset < count; offset++ {
+			device.holdingRegisters[address+offset] = binary.BigEndian.Uint16(data[5+offset*2:])
+		}
+		log.Printf("[INFO] modbusDevice - %d holding registers set starting at %d\n", count, address)
+		return data[:4], 0
+	}
+
+	return nil, modbusIllegalFunction
+}
+
+//modbusCRC : CRC-16/MODBUS of data
+func modbusCRC(data []byte) uint16 {
+	crc := uint16(0xFFFF)
+	for _, value := range data {
+		crc ^= uint16(value)
+		for bit := 0; bit < 8; bit++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+//appendModbusCRC : Appends the CRC, low byte first
+func appendModbusCRC(pdu []byte) []byte {
+	crc := modbusCRC(pdu)
+	return append(pdu, byte(crc), byte(crc>>8))
+}
@@ -0,0 +1,167 @@
+package Simulator
+
+import (
+	"context"
+	"io"
+	"log"
+	"strings"
+)
+
+//ModemDeviceName : An AT command modem that behaves like an xDot
+const ModemDeviceName = "modem"
+
+func init() {
+	RegisterDevice(ModemDeviceName, newModemDevice)
+}
+
+//defaultModemValues : Answers to the queries issued by the xdot device profiles
+var defaultModemValues = map[string]string{
+	"AT+DI":   "00-80-00-00-00-00-00-01",
+	"AT+NJM":  "1",
+	"AT+DC":   "A",
+	"AT+PN":   "1",
+	"AT+NA":   "00:00:00:00",
+	"AT+NSK":  "00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00",
+	"AT+DSK":  "00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00",
+	"AT+NI":   "00-00-00-00-00-00-00-00",
+	"AT+NK":   "00:00:00:00:00:00:00:00:00:00:00:00:00:00:00:00",
+	"AT+FSB":  "0",
+	"AT+TXDR": "DR0",
+	"AT+TXF":  "915500000",
+}
+
+//modemDevice : Answers AT commands in command mode. AT+SD answers CONNECT and enters serial data
+//mode, where data is logged (and written back when loopback is true) until +++ is received.
+//
+// {"echo": true, "loopback": false, "values": {"AT+DI": "00-80-00-00-04-00-9f-00"}}
+//
+// Setting commands (AT+XXX=value) are stored and returned by later queries (AT+XXX or AT+XXX?).
+type modemDevice struct {
+	echo     bool
+	loopback bool
+	values   map[string]string
+}
+
+func newModemDevice(settings map[string]interface{}) (Device, error) {
+	device := &modemDevice{
+		echo:     settingBool(settings, "echo", true),
+		loopback: settingBool(settings, "loopback", false),
+		values:   map[string]string{},
+	}
+
+	for command, value := range defaultModemValues {
+		device.values[command] = value
+	}
+	if values, ok := settings["values"].(map[string]interface{}); ok {
+		for command := range values {
+			device.values[strings.ToUpper(command)] = settingString(values, command, "")
+		}
+	}
+	return device, nil
+}
+
+func (device *modemDevice) Name() string {
+	return ModemDeviceName
+}
+
+func (device *modemDevice) Run(ctx context.Context, port io.ReadWriter) error {
+	dataMode := false
+	command := ""
+	escapes := 0
+
+	chunks := readChunks(ctx, port)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case chunk, ok := <-chunks:
+			if !ok {
+				return nil
+			}
+
+			if dataMode {
+				//The escape sequence is +++ with a pause around each +, so each + arrives on its own
+				if strings.Trim(string(chunk), "+") == "" {
+					escapes += len(chunk)
+					if escapes >= 3 {
+						log.Println("[INFO] modemDevice - Escape sequence received, leaving serial data mode")
+						dataMode = false
+						escapes = 0
+						write(port, []byte("OK\r\n"))
+					}
+					continue
+				}
+				escapes = 0
+
+				log.Printf("[INFO] modemDevice - Data received: %q\n", chunk)
+				if device.loopback {
+					write(port, chunk)
+				}
+				continue
+			}
+
+			if device.echo {
+				write(port, chunk)
+			}
+
+			command += string(chunk)
+			for {
+				end := strings.IndexByte(command, '\r')
+				if end < 0 {
+					break
+				}
+				line := strings.TrimSpace(command[:end])
+				command = command[end+1:]
+
+				if line == "" {
+					continue
+				}
+				response, enterDataMode := device.handle(line)
+				write(port, []byte(response))
+				if enterDataMode {
+					log.Println("[INFO] modemDevice - Entering serial data mode")
+					dataMode = true
+					//Anything typed after AT+SD is serial data
+					command = ""
+					break
+				}
+			}
+		}
+	}
+}
+
+//handle : Returns the response to an AT command and whether serial data mode is entered
+func (device *modemDevice) handle(line string) (string, bool) {
+	log.Printf("[DEBUG] modemDevice - Command received: %s\n", line)
+
+	upper := strings.ToUpper(line)
+	if !strings.HasPrefix(upper, "AT") {
+		return "Command not found!\r\n\r\nERROR\r\n", false
+	}
+
+	switch upper {
+	case "AT", "ATZ", "AT&W", "AT&F", "AT&K0", "AT&K3", "ATE0", "ATE1", "ATV0", "ATV1":
+		if upper == "ATE0" || upper == "ATE1" {
+			device.echo = upper == "ATE1"
+		}
+		return "\r\nOK\r\n", false
+	case "AT+SD":
+		return "\r\nCONNECT\r\n", true
+	case "AT+JOIN":
+		return "\r\nSuccessfully joined network\r\n\r\nOK\r\n", false
+	}
+
+	if strings.HasPrefix(upper, "AT+SEND") {
+		return "\r\nOK\r\n", false
+	}
+
+	if index := strings.IndexByte(line, '='); index > 0 {
+		name := strings.ToUpper(line[:index])
+		device.values[name] = line[index+1:]
+		log.Printf("[INFO] modemDevice - %s set\n", name)
+		return "\r\nOK\r\n", false
+	}
+
+	name := strings.TrimSuffix(upper, "?")
+	return "\r\n" + device.values[name] + "\r\n\r\nOK\r\n", false
+}
@@ -0,0 +1,167 @@
+package Simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//SensorDeviceName : A line based sensor emitting periodic readings
+const SensorDeviceName = "sensor"
+
+func init() {
+	RegisterDevice(SensorDeviceName, newSensorDevice)
+}
+
+var sensorPlaceholder = regexp.MustCompile(`\{([A-Za-z0-9_]+)\}`)
+
+//sensorField : A reading that wanders randomly between min and max
+type sensorField struct {
+	min      float64
+	max      float64
+	step     float64
+	decimals int
+	value    float64
+}
+
+//sensorDevice : Writes a line built from format every interval. {name} placeholders are replaced
+//with the current value of the field of that name, {seq} with the line number and {time} with the
+//current time (RFC 3339). When query is set, receiving it answers with a reading immediately.
+//
+// {"interval": 5, "format": "T={temperature};H={humidity}\r\n", "query": "READ", "fields": {"temperature": {"min": 18, "max": 25, "step": 0.2, "decimals": 1}}}
+//
+// An interval of 0 only answers queries.
+type sensorDevice struct {
+	interval time.Duration
+	format   string
+	query    string
+	fields   map[string]*sensorField
+	sequence int
+	random   *rand.Rand
+}
+
+func newSensorDevice(settings map[string]interface{}) (Device, error) {
+	interval := settingNumber(settings, "interval", 5)
+	if interval < 0 {
+		return nil, errors.New("The sensor interval must not be negative")
+	}
+
+	device := &sensorDevice{
+		interval: time.Duration(interval * float64(time.Second)),
+		format:   settingString(settings, "format", "T={temperature};H={humidity}\r\n"),
+		query:    settingString(settings, "query", ""),
+		fields:   map[string]*sensorField{},
+		random:   rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+
+	fieldsJSON, ok := settings["fields"].(map[string]interface{})
+	if !ok {
+		fieldsJSON = map[string]interface{}{
+			"temperature": map[string]interface{}{"min": 18.0, "max": 25.0, "step": 0.2, "decimals": 1.0},
+			"humidity":    map[string]interface{}{"min": 30.0, "max": 60.0, "step": 1.0},
+		}
+	}
+	for name, value := range fieldsJSON {
+		fieldJSON, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Sensor field %s must be an object", name)
+		}
+		field := &sensorField{
+			min:      settingNumber(fieldJSON, "min", 0),
+			max:      settingNumber(fieldJSON, "max", 100),
+			decimals: int(settingNumber(fieldJSON, "decimals", 0)),
+		}
+		if field.max < field.min {
+			return nil, fmt.Errorf("Sensor field %s max must not be less than min", name)
+		}
+		field.step = settingNumber(fieldJSON, "step", (field.max-field.min)/10)
+		field.value = field.min + (field.max-field.min)/2
+		device.fields[name] = field
+	}
+
+	for _, match := range sensorPlaceholder.FindAllStringSubmatch(device.format, -1) {
+		if _, ok := device.fields[match[1]]; !ok && match[1] != "seq" && match[1] != "time" {
+			return nil, fmt.Errorf("The sensor format uses {%s}, which is not a field", match[1])
+		}
+	}
+
+	return device, nil
+}
+
+func (device *sensorDevice) Name() string {
+	return SensorDeviceName
+}
+
+func (device *sensorDevice) Run(ctx context.Context, port io.ReadWriter) error {
+	var ticks <-chan time.Time
+	if device.interval > 0 {
+		ticker := time.NewTicker(device.interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	received := ""
+	chunks := readChunks(ctx, port)
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticks:
+			write(port, []byte(device.reading()))
+		case chunk, ok := <-chunks:
+			if !ok {
+				return nil
+			}
+			if device.query == "" {
+				log.Printf("[DEBUG] sensorDevice - Ignoring %q\n", chunk)
+				continue
+			}
+
+			received += string(chunk)
+			for strings.Contains(received, device.query) {
+				received = received[strings.Index(received, device.query)+len(device.query):]
+				write(port, []byte(device.reading()))
+			}
+			//Keep only what could be the start of the next query
+			if len(received) > len(device.query) {
+				received = received[len(received)-len(device.query):]
+			}
+		}
+	}
+}
+
+//reading : Advances every field and formats the next line
+func (device *sensorDevice) reading() string {
+	device.sequence++
+	for _, field := range device.fields {
+		field.value += (device.random.Float64()*2 - 1) * field.step
+		if field.value < field.min {
+			field.value = field.min
+		}
+		if field.value > field.max {
+			field.value = field.max
+		}
+	}
+
+	line := sensorPlaceholder.ReplaceAllStringFunc(device.format, func(placeholder string) string {
+		name := placeholder[1 : len(placeholder)-1]
+		switch name {
+		case "seq":
+			return strconv.Itoa(device.sequence)
+		case "time":
+			return time.Now().UTC().Format(time.RFC3339)
+		}
+		field := device.fields[name]
+		return strconv.FormatFloat(field.value, 'f', field.decimals, 64)
+	})
+
+	log.Printf("[DEBUG] sensorDevice - Sending %q\n", line)
+	return line
+}
@@ -0,0 +1,131 @@
+package Simulator
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//Device : A serial device emulated on the device end of a pseudo-terminal
+type Device interface {
+	//Name : The name the device was registered with
+	Name() string
+
+	//Run : Exchanges data with the adapter over port until ctx is done
+	Run(ctx context.Context, port io.ReadWriter) error
+}
+
+//DeviceFactory : Creates a device from the JSON object of the simulator configuration file
+type DeviceFactory func(settings map[string]interface{}) (Device, error)
+
+var (
+	registryLock = &sync.Mutex{}
+	registry     = map[string]DeviceFactory{}
+)
+
+//RegisterDevice : Makes a device selectable by name. Registering the same name twice replaces the factory
+func RegisterDevice(name string, factory DeviceFactory) {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	registry[strings.ToLower(name)] = factory
+}
+
+//CreateDevice : Creates the device registered under the given name
+func CreateDevice(name string, settings map[string]interface{}) (Device, error) {
+	registryLock.Lock()
+	factory, ok := registry[strings.ToLower(name)]
+	registryLock.Unlock()
+
+	if !ok {
+		log.Printf("[ERROR] CreateDevice - Unknown simulated device: %s\n", name)
+		return nil, fmt.Errorf("Unknown simulated device %q, available devices: %s", name, strings.Join(DeviceNames(), ", "))
+	}
+
+	if settings == nil {
+		settings = make(map[string]interface{})
+	}
+
+	log.Printf("[INFO] CreateDevice - Creating simulated device %s\n", name)
+	return factory(settings)
+}
+
+//DeviceNames : The sorted names of all registered devices
+func DeviceNames() []string {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	names := make([]string, 0, len(registry))
+	for name := range registry {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//readChunks : Delivers the data the adapter writes to the port until the port is closed. Reads
+//are not interrupted by ctx, closing the port ends the go routine.
+func readChunks(ctx context.Context, port io.Reader) <-chan []byte {
+	chunks := make(chan []byte)
+	go func() {
+		defer close(chunks)
+		buff := make([]byte, 512)
+		for {
+			n, err := port.Read(buff)
+			if n > 0 {
+				chunk := make([]byte, n)
+				copy(chunk, buff[:n])
+				select {
+				case chunks <- chunk:
+				case <-ctx.Done():
+					return
+				}
+			}
+			if err != nil {
+				if err != io.EOF && ctx.Err() == nil {
+					log.Println("[ERROR] readChunks - Error reading from the pseudo-terminal: " + err.Error())
+				}
+				return
+			}
+		}
+	}()
+	return chunks
+}
+
+//write : Writes data to the adapter, logging failures
+func write(port io.Writer, data []byte) {
+	if _, err := port.Write(data); err != nil {
+		log.Println("[ERROR] Simulator - Error writing to the pseudo-terminal: " + err.Error())
+	}
+}
+
+//settingString : Returns the setting as a string, or the default value when it is not present
+func settingString(settings map[string]interface{}, key string, defaultValue string) string {
+	if value, ok := settings[key]; ok && value != nil {
+		if str, ok := value.(string); ok {
+			return str
+		}
+		return fmt.Sprint(value)
+	}
+	return defaultValue
+}
+
+//settingNumber : Returns the setting as a number, or the default value when it is not present
+func settingNumber(settings map[string]interface{}, key string, defaultValue float64) float64 {
+	if value, ok := settings[key].(float64); ok {
+		return value
+	}
+	return defaultValue
+}
+
+//settingBool : Returns the setting as a bool, or the default value when it is not present
+func settingBool(settings map[string]interface{}, key string, defaultValue bool) bool {
+	if value, ok := settings[key].(bool); ok {
+		return value
+	}
+	return defaultValue
+}
@@ -0,0 +1,78 @@
+//go:build linux
+// +build linux
+
+package Simulator
+
+import (
+	"log"
+	"os"
+	"strconv"
+
+	"golang.org/x/sys/unix"
+)
+
+//Pty : A pseudo-terminal pair. The simulator reads and writes Master, the adapter opens SlavePath.
+type Pty struct {
+	Master    *os.File
+	SlavePath string
+
+	//Held open so reads of the master do not fail while the adapter has the slave closed
+	slave *os.File
+}
+
+//OpenPty : Creates a pseudo-terminal pair with the slave in raw mode
+func OpenPty() (*Pty, error) {
+	master, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		log.Println("[ERROR] OpenPty - Error opening /dev/ptmx: " + err.Error())
+		return nil, err
+	}
+
+	fd := int(master.Fd())
+	if err := unix.IoctlSetPointerInt(fd, unix.TIOCSPTLCK, 0); err != nil {
+		master.Close()
+		log.Println("[ERROR] OpenPty - Error unlocking the pseudo-terminal: " + err.Error())
+		return nil, err
+	}
+
+	number, err := unix.IoctlGetInt(fd, unix.TIOCGPTN)
+	if err != nil {
+		master.Close()
+		log.Println("[ERROR] OpenPty - Error retrieving the pseudo-terminal number: " + err.Error())
+		return nil, err
+	}
+	slavePath := "/dev/pts/" + strconv.Itoa(number)
+
+	slave, err := os.OpenFile(slavePath, os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		log.Println("[ERROR] OpenPty - Error opening " + slavePath + ": " + err.Error())
+		return nil, err
+	}
+
+	//No echo or line editing, the same as the adapter configures when it opens the port
+	termios, err := unix.IoctlGetTermios(int(slave.Fd()), unix.TCGETS)
+	if err == nil {
+		termios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP | unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+		termios.Oflag &^= unix.OPOST
+		termios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+		termios.Cflag &^= unix.CSIZE | unix.PARENB
+		termios.Cflag |= unix.CS8
+		err = unix.IoctlSetTermios(int(slave.Fd()), unix.TCSETS, termios)
+	}
+	if err != nil {
+		slave.Close()
+		master.Close()
+		log.Println("[ERROR] OpenPty - Error setting raw mode: " + err.Error())
+		return nil, err
+	}
+
+	log.Printf("[INFO] OpenPty - Pseudo-terminal %s created\n", slavePath)
+	return &Pty{Master: master, SlavePath: slavePath, slave: slave}, nil
+}
+
+//Close : Closes both ends of the pseudo-terminal
+func (pty *Pty) Close() error {
+	pty.slave.Close()
+	return pty.Master.Close()
+}
@@ -0,0 +1,28 @@
+//go:build !linux
+// +build !linux
+
+package Simulator
+
+import (
+	"errors"
+	"os"
+)
+
+//ErrPtyNotSupported : Returned by OpenPty on platforms other than linux
+var ErrPtyNotSupported = errors.New("Pseudo-terminals are only supported on linux")
+
+//Pty : A pseudo-terminal pair. The simulator reads and writes Master, the adapter opens SlavePath.
+type Pty struct {
+	Master    *os.File
+	SlavePath string
+}
+
+//OpenPty : Not supported on this platform
+func OpenPty() (*Pty, error) {
+	return nil, ErrPtyNotSupported
+}
+
+//Close : Not supported on this platform
+func (pty *Pty) Close() error {
+	return ErrPtyNotSupported
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+//authState : Authentication state of the adapter reported in the health status
+type authState int
+
+const (
+	authUnauthenticated authState = iota
+	authAuthenticating
+	authAuthenticated
+	authBackoff
+)
+
+const authInitialBackoff = time.Second
+
+var errNoReauthCredentials = errors.New("The device token was rejected and no active key is available to re-authenticate")
+
+func (state authState) String() string {
+	switch state {
+	case authUnauthenticated:
+		return "unauthenticated"
+	case authAuthenticating:
+		return "authenticating"
+	case authAuthenticated:
+		return "authenticated"
+	case authBackoff:
+		return "backoff"
+	}
+	return "unknown"
+}
+
+//authManager : Authenticates with the platform, retrying with exponential backoff and jitter.
+//When the platform rejects the token mid-run, Reauthenticate obtains a new token and
+//re-initializes the MQTT connection, which re-establishes the subscriptions in OnConnect.
+type authManager struct {
+	lock      sync.Mutex
+	state     authState
+	lastError error
+	attempts  int
+
+	initialBackoff time.Duration
+	maxBackoff     time.Duration
+
+	//Obtains a new token and stores it in the platform client
+	authenticate func() error
+
+	//Only one re-authentication runs at a time
+	reauthLock       sync.Mutex
+	reauthenticating bool
+}
+
+func newAuthManager(authenticate func() error, maxBackoff time.Duration) *authManager {
+	if maxBackoff < authInitialBackoff {
+		maxBackoff = authInitialBackoff
+	}
+	return &authManager{
+		state:          authUnauthenticated,
+		initialBackoff: authInitialBackoff,
+		maxBackoff:     maxBackoff,
+		authenticate:   authenticate,
+	}
+}
+
+//State : The current authentication state
+func (manager *authManager) State() authState {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	return manager.state
+}
+
+//LastError : The error returned by the most recent failed attempt, nil once authenticated
+func (manager *authManager) LastError() error {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+	return manager.lastError
+}
+
+func (manager *authManager) setState(state authState, err error) {
+	manager.lock.Lock()
+	defer manager.lock.Unlock()
+
+	if manager.state != state {
+		log.Printf("[DEBUG] authManager - Authentication %s -> %s\n", manager.state, state)
+		manager.state = state
+	}
+	manager.lastError = err
+}
+
+//Authenticate : Retries until authentication succeeds or ctx is done
+func (manager *authManager) Authenticate(ctx context.Context) error {
+	manager.attempts = 0
+	return manager.retryAuthenticate(ctx)
+}
+
+//TryAuthenticate : A single authentication attempt
+func (manager *authManager) TryAuthenticate() error {
+	manager.setState(authAuthenticating, nil)
+	if err := manager.authenticate(); err != nil {
+		log.Printf("[ERROR] authManager.TryAuthenticate - Error authenticating ClearBlade: %s\n", err.Error())
+		manager.setState(authUnauthenticated, err)
+		return err
+	}
+
+	log.Println("[INFO] authManager.TryAuthenticate - Authenticated with the ClearBlade platform")
+	manager.setState(authAuthenticated, nil)
+	return nil
+}
+
+//retryAuthenticate : Authenticate without resetting the backoff
+func (manager *authManager) retryAuthenticate(ctx context.Context) error {
+	for {
+		manager.setState(authAuthenticating, nil)
+		err := manager.authenticate()
+		if err == nil {
+			log.Println("[INFO] authManager.Authenticate - Authenticated with the ClearBlade platform")
+			manager.setState(authAuthenticated, nil)
+			return nil
+		}
+
+		log.Printf("[ERROR] authManager.Authenticate - Error authenticating ClearBlade: %s\n", err.Error())
+		if err := manager.backoff(ctx, err); err != nil {
+			return err
+		}
+	}
+}
+
+//backoff : Waits before the next attempt after err. Returns the context error if ctx is done first.
+func (manager *authManager) backoff(ctx context.Context, err error) error {
+	wait := manager.nextBackoff()
+	log.Printf("[ERROR] authManager - Will retry in %s...\n", wait.String())
+	manager.setState(authBackoff, err)
+
+	timer := time.NewTimer(wait)
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		timer.Stop()
+		manager.setState(authUnauthenticated, err)
+		return ctx.Err()
+	}
+}
+
+//nextBackoff : Doubles the wait after each failed attempt up to maxBackoff. The wait is
+//randomized between half and all of that value so edge devices restarted together do not
+//retry in lockstep.
+func (manager *authManager) nextBackoff() time.Duration {
+	wait := manager.maxBackoff
+	if manager.attempts < 32 {
+		if backoff := manager.initialBackoff << uint(manager.attempts); backoff > 0 && backoff < wait {
+			wait = backoff
+		}
+	}
+	manager.attempts++
+
+	half := wait / 2
+	return half + time.Duration(rand.Int63n(int64(wait-half)+1))
+}
+
+//Reauthenticate : Obtains a new token and runs reconnect once authentication succeeds. A failed
+//reconnect is retried with the same backoff, authenticating again first, until it succeeds or ctx
+//is done. Calls made while a re-authentication is already in progress return immediately.
+func (manager *authManager) Reauthenticate(ctx context.Context, reconnect func() error) {
+	manager.reauthLock.Lock()
+	if manager.reauthenticating {
+		manager.reauthLock.Unlock()
+		log.Println("[DEBUG] authManager.Reauthenticate - Re-authentication already in progress")
+		return
+	}
+	manager.reauthenticating = true
+	manager.reauthLock.Unlock()
+
+	defer func() {
+		manager.reauthLock.Lock()
+		manager.reauthenticating = false
+		manager.reauthLock.Unlock()
+	}()
+
+	log.Println("[INFO] authManager.Reauthenticate - Token rejected by the platform, re-authenticating")
+	manager.setState(authUnauthenticated, nil)
+	manager.attempts = 0
+
+	for {
+		if err := manager.retryAuthenticate(ctx); err != nil {
+			log.Printf("[WARN] authManager.Reauthenticate - Re-authentication ended: %s\n", err.Error())
+			return
+		}
+
+		err := reconnect()
+		if err == nil {
+			return
+		}
+		log.Printf("[ERROR] authManager.Reauthenticate - Unable to reconnect after re-authenticating: %s\n", err.Error())
+		if err := manager.backoff(ctx, err); err != nil {
+			log.Printf("[WARN] authManager.Reauthenticate - Re-authentication ended: %s\n", err.Error())
+			return
+		}
+	}
+}
+
+//isAuthError : Determines if an error returned by the platform or MQTT broker indicates
+//that the token or credentials were rejected
+func isAuthError(err error) bool {
+	if err == nil {
+		return false
+	}
+
+	message := strings.ToLower(err.Error())
+	for _, text := range []string{"401", "unauthorized", "not authorized", "bad user name or password", "invalid token", "token expired"} {
+		if strings.Contains(message, text) {
+			return true
+		}
+	}
+	return false
+}
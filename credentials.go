@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+//Environment variables that may be used instead of the credential flags
+const (
+	systemKeyEnv    = "CB_SYSTEM_KEY"
+	systemSecretEnv = "CB_SYSTEM_SECRET"
+	deviceNameEnv   = "CB_DEVICE_NAME"
+	activeKeyEnv    = "CB_ACTIVE_KEY"
+	deviceTokenEnv  = "CB_DEVICE_TOKEN"
+	mqttUsernameEnv = "MQTT_USERNAME"
+	mqttPasswordEnv = "MQTT_PASSWORD"
+)
+
+//adapterCredentials : Contents of the file specified with -credentialsFile
+type adapterCredentials struct {
+	SystemKey    string `json:"systemKey"`
+	SystemSecret string `json:"systemSecret"`
+	DeviceName   string `json:"deviceName"`
+	ActiveKey    string `json:"password"`
+	DeviceToken  string `json:"deviceToken"`
+	MQTTUsername string `json:"mqttUsername"`
+	MQTTPassword string `json:"mqttPassword"`
+}
+
+//loadCredentials : Resolves the platform credentials. Flags specified on the command line take
+//precedence over environment variables, which take precedence over the credentials file. A device
+//token (from -deviceTokenFile, CB_DEVICE_TOKEN or the credentials file) is used in place of the
+//active key, in which case the adapter does not authenticate with the platform itself. The generic
+//MQTT broker username and password are resolved the same way.
+func loadCredentials() error {
+	fileCredentials := adapterCredentials{}
+
+	if credentialsFile != "" {
+		contents, err := readSecretFile(credentialsFile)
+		if err != nil {
+			return err
+		}
+		if err := json.Unmarshal(contents, &fileCredentials); err != nil {
+			return fmt.Errorf("Unable to parse credentials file %s: %s", credentialsFile, err.Error())
+		}
+	}
+
+	if deviceTokenFile != "" {
+		contents, err := readSecretFile(deviceTokenFile)
+		if err != nil {
+			return err
+		}
+		fileCredentials.DeviceToken = strings.TrimSpace(string(contents))
+	}
+
+	explicitFlags := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) {
+		explicitFlags[f.Name] = true
+	})
+
+	resolveCredential(&sysKey, explicitFlags["systemKey"], systemKeyEnv, fileCredentials.SystemKey)
+	resolveCredential(&sysSec, explicitFlags["systemSecret"], systemSecretEnv, fileCredentials.SystemSecret)
+	resolveCredential(&deviceName, explicitFlags["deviceName"], deviceNameEnv, fileCredentials.DeviceName)
+	resolveCredential(&activeKey, explicitFlags["password"], activeKeyEnv, fileCredentials.ActiveKey)
+	resolveCredential(&deviceToken, false, deviceTokenEnv, fileCredentials.DeviceToken)
+	resolveCredential(&mqttUsername, explicitFlags["mqttUsername"], mqttUsernameEnv, fileCredentials.MQTTUsername)
+	resolveCredential(&mqttPassword, explicitFlags["mqttPassword"], mqttPasswordEnv, fileCredentials.MQTTPassword)
+
+	if explicitFlags["password"] || explicitFlags["systemSecret"] || explicitFlags["mqttPassword"] {
+		log.Println("[WARN] loadCredentials - Secrets passed on the command line are visible to other users, use -credentialsFile or environment variables instead")
+	}
+
+	return nil
+}
+
+//resolveCredential : Replaces value with the environment variable or file value unless the flag was specified
+func resolveCredential(value *string, flagSpecified bool, envName string, fileValue string) {
+	if flagSpecified && *value != "" {
+		return
+	}
+	if envValue := os.Getenv(envName); envValue != "" {
+		*value = envValue
+		return
+	}
+	if fileValue != "" {
+		*value = fileValue
+	}
+}
+
+//readSecretFile : Reads a file containing credentials. Files readable by other users are rejected.
+//The permissions are checked on the open file so the file read is the file checked.
+func readSecretFile(fileName string) ([]byte, error) {
+	file, err := os.Open(fileName)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read credentials from %s: %s", fileName, err.Error())
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read credentials from %s: %s", fileName, err.Error())
+	}
+
+	mode := info.Mode().Perm()
+	if mode&0004 != 0 {
+		return nil, errors.New(fileName + " is world-readable (mode " + mode.String() + "), restrict it with chmod 600")
+	}
+	if mode&0040 != 0 {
+		log.Printf("[WARN] readSecretFile - %s is group-readable (mode %s)\n", fileName, mode.String())
+	}
+
+	contents, err := ioutil.ReadAll(file)
+	if err != nil {
+		return nil, fmt.Errorf("Unable to read credentials from %s: %s", fileName, err.Error())
+	}
+	return contents, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//cronSchedule : A standard 5 field cron expression (minute hour day-of-month month day-of-week).
+//Each field supports *, single values, ranges (1-5), steps (*/15, 0-30/5) and comma separated lists.
+type cronSchedule struct {
+	expression string
+	minutes    [60]bool
+	hours      [24]bool
+	days       [32]bool
+	months     [13]bool
+	weekdays   [7]bool
+
+	//Cron matches either the day of month or the day of week when both are restricted
+	daysRestricted     bool
+	weekdaysRestricted bool
+}
+
+//Searching further than this for the next run means the expression can never match (ex. 30 February)
+const cronSearchLimit = 5 * 366 * 24 * time.Hour
+
+func parseCronSchedule(expression string) (*cronSchedule, error) {
+	fields := strings.Fields(expression)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Cron expression %q must have 5 fields", expression)
+	}
+
+	schedule := &cronSchedule{expression: expression}
+	if err := parseCronField(fields[0], 0, 59, schedule.minutes[:]); err != nil {
+		return nil, fmt.Errorf("Invalid minute field in %q: %s", expression, err.Error())
+	}
+	if err := parseCronField(fields[1], 0, 23, schedule.hours[:]); err != nil {
+		return nil, fmt.Errorf("Invalid hour field in %q: %s", expression, err.Error())
+	}
+	if err := parseCronField(fields[2], 1, 31, schedule.days[:]); err != nil {
+		return nil, fmt.Errorf("Invalid day of month field in %q: %s", expression, err.Error())
+	}
+	if err := parseCronField(fields[3], 1, 12, schedule.months[:]); err != nil {
+		return nil, fmt.Errorf("Invalid month field in %q: %s", expression, err.Error())
+	}
+
+	//Both 0 and 7 are Sunday
+	weekdays := make([]bool, 8)
+	if err := parseCronField(fields[4], 0, 7, weekdays); err != nil {
+		return nil, fmt.Errorf("Invalid day of week field in %q: %s", expression, err.Error())
+	}
+	copy(schedule.weekdays[:], weekdays[:7])
+	schedule.weekdays[0] = schedule.weekdays[0] || weekdays[7]
+
+	//Like cron, a field starting with * (including */n) does not restrict the day
+	schedule.daysRestricted = !strings.HasPrefix(fields[2], "*")
+	schedule.weekdaysRestricted = !strings.HasPrefix(fields[4], "*")
+	return schedule, nil
+}
+
+//parseCronField : Sets values[n] for every n between min and max matched by field
+func parseCronField(field string, min int, max int, values []bool) error {
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if index := strings.Index(part, "/"); index >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[index+1:]); err != nil || step <= 0 {
+				return errors.New("invalid step in " + part)
+			}
+			part = part[:index]
+		}
+
+		start, end := min, max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return errors.New("invalid range " + part)
+			}
+			if end, err = strconv.Atoi(bounds[1]); err != nil {
+				return errors.New("invalid range " + part)
+			}
+		default:
+			value, err := strconv.Atoi(part)
+			if err != nil {
+				return errors.New("invalid value " + part)
+			}
+			start = value
+			//A single value with a step (5/10) runs from the value to the end of the range
+			if step > 1 {
+				end = max
+			} else {
+				end = value
+			}
+		}
+
+		if start < min || end > max || start > end {
+			return fmt.Errorf("%s is outside %d-%d", part, min, max)
+		}
+		for value := start; value <= end; value += step {
+			values[value] = true
+		}
+	}
+	return nil
+}
+
+//Next : The first time after from that matches the schedule. Returns the zero time if none is found.
+func (schedule *cronSchedule) Next(from time.Time) time.Time {
+	next := from.Truncate(time.Minute).Add(time.Minute)
+	limit := from.Add(cronSearchLimit)
+
+	for next.Before(limit) {
+		if !schedule.months[next.Month()] {
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, next.Location())
+			continue
+		}
+		if !schedule.dayMatches(next) {
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, next.Location())
+			continue
+		}
+		if !schedule.hours[next.Hour()] {
+			next = time.Date(next.Year(), next.Month(), next.Day(), next.Hour()+1, 0, 0, 0, next.Location())
+			continue
+		}
+		if !schedule.minutes[next.Minute()] {
+			next = next.Add(time.Minute)
+			continue
+		}
+		return next
+	}
+	return time.Time{}
+}
+
+func (schedule *cronSchedule) dayMatches(t time.Time) bool {
+	dayMatch := schedule.days[t.Day()]
+	weekdayMatch := schedule.weekdays[t.Weekday()]
+
+	if schedule.daysRestricted && schedule.weekdaysRestricted {
+		return dayMatch || weekdayMatch
+	}
+	return dayMatch && weekdayMatch
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseCronField(t *testing.T) {
+	tests := []struct {
+		field    string
+		min, max int
+		expected []int
+		invalid  bool
+	}{
+		{field: "*", min: 0, max: 5, expected: []int{0, 1, 2, 3, 4, 5}},
+		{field: "3", min: 0, max: 59, expected: []int{3}},
+		{field: "1,7,9", min: 0, max: 59, expected: []int{1, 7, 9}},
+		{field: "10-13", min: 0, max: 59, expected: []int{10, 11, 12, 13}},
+		{field: "*/15", min: 0, max: 59, expected: []int{0, 15, 30, 45}},
+		{field: "*/2", min: 1, max: 12, expected: []int{1, 3, 5, 7, 9, 11}},
+		{field: "0-30/10", min: 0, max: 59, expected: []int{0, 10, 20, 30}},
+		{field: "50/5", min: 0, max: 59, expected: []int{50, 55}},
+		{field: "1-2,*/20", min: 0, max: 59, expected: []int{0, 1, 2, 20, 40}},
+		{field: "60", min: 0, max: 59, invalid: true},
+		{field: "0", min: 1, max: 31, invalid: true},
+		{field: "5-1", min: 0, max: 59, invalid: true},
+		{field: "*/0", min: 0, max: 59, invalid: true},
+		{field: "*/x", min: 0, max: 59, invalid: true},
+		{field: "1-", min: 0, max: 59, invalid: true},
+		{field: "a", min: 0, max: 59, invalid: true},
+		{field: "", min: 0, max: 59, invalid: true},
+	}
+
+	for _, test := range tests {
+		values := make([]bool, test.max+1)
+		err := parseCronField(test.field, test.min, test.max, values)
+		if test.invalid {
+			if err == nil {
+				t.Errorf("parseCronField(%q) succeeded, expected an error", test.field)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseCronField(%q) returned %s", test.field, err.Error())
+			continue
+		}
+
+		matched := []int{}
+		for value, set := range values {
+			if set {
+				matched = append(matched, value)
+			}
+		}
+		if !reflect.DeepEqual(matched, test.expected) {
+			t.Errorf("parseCronField(%q) matched %v, expected %v", test.field, matched, test.expected)
+		}
+	}
+}
+
+func TestParseCronScheduleInvalid(t *testing.T) {
+	for _, expression := range []string{
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 32 * *",
+		"* * * 13 *",
+		"* * * * 8",
+	} {
+		if _, err := parseCronSchedule(expression); err == nil {
+			t.Errorf("parseCronSchedule(%q) succeeded, expected an error", expression)
+		}
+	}
+}
+
+func TestCronScheduleNext(t *testing.T) {
+	//Tuesday
+	from := time.Date(2026, time.October, 20, 13, 7, 30, 0, time.UTC)
+
+	tests := []struct {
+		expression string
+		expected   time.Time
+	}{
+		{"* * * * *", time.Date(2026, time.October, 20, 13, 8, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2026, time.October, 20, 13, 15, 0, 0, time.UTC)},
+		{"0 0 * * *", time.Date(2026, time.October, 21, 0, 0, 0, 0, time.UTC)},
+		{"30 9 * * 1-5", time.Date(2026, time.October, 21, 9, 30, 0, 0, time.UTC)},
+		{"0 12 1 * *", time.Date(2026, time.November, 1, 12, 0, 0, 0, time.UTC)},
+		{"0 12 * * 1", time.Date(2026, time.October, 26, 12, 0, 0, 0, time.UTC)},
+		{"0 12 * * 7", time.Date(2026, time.October, 25, 12, 0, 0, 0, time.UTC)},
+		{"0 12 * * 0", time.Date(2026, time.October, 25, 12, 0, 0, 0, time.UTC)},
+		{"0 0 1 1 *", time.Date(2027, time.January, 1, 0, 0, 0, 0, time.UTC)},
+		//Both restricted, either the day of month or the day of week matches
+		{"0 12 1 * 1", time.Date(2026, time.October, 26, 12, 0, 0, 0, time.UTC)},
+		{"0 12 22 * 1", time.Date(2026, time.October, 22, 12, 0, 0, 0, time.UTC)},
+		//A field starting with * is not a restriction, both must match: the first odd day that is a Monday
+		{"0 12 */2 * 1", time.Date(2026, time.November, 9, 12, 0, 0, 0, time.UTC)},
+		{"0 12 1 * */2", time.Date(2026, time.November, 1, 12, 0, 0, 0, time.UTC)},
+		//Never matches
+		{"0 0 30 2 *", time.Time{}},
+	}
+
+	for _, test := range tests {
+		schedule, err := parseCronSchedule(test.expression)
+		if err != nil {
+			t.Errorf("parseCronSchedule(%q) returned %s", test.expression, err.Error())
+			continue
+		}
+		if next := schedule.Next(from); !next.Equal(test.expected) {
+			t.Errorf("Next of %q is %s, expected %s", test.expression, next, test.expected)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"sync"
+	"time"
+
+	mqttTypes "github.com/clearblade/mqtt_parsing"
+)
+
+//duplicateFilter : Suppresses QoS 1 redeliveries of a message. A message is a duplicate when the
+//broker marked it as a redelivery (DUP flag) and a message with the same message ID and payload was
+//received within the window. Message IDs are reused, so a new message with the same ID and payload
+//(DUP not set) is never suppressed. QoS 0 messages are never redelivered and QoS 2 messages are
+//deduplicated by the broker, so neither is tracked.
+type duplicateFilter struct {
+	lock   sync.Mutex
+	window time.Duration
+	seen   map[string]time.Time
+}
+
+func newDuplicateFilter(window time.Duration) *duplicateFilter {
+	return &duplicateFilter{window: window, seen: make(map[string]time.Time)}
+}
+
+//IsDuplicate : Records the message and reports whether it was already received within the window
+func (filter *duplicateFilter) IsDuplicate(message *mqttTypes.Publish) bool {
+	if filter.window <= 0 || message.Header == nil || message.Header.QOS != 1 {
+		return false
+	}
+
+	hash := sha256.Sum256(message.Payload)
+	key := strconv.Itoa(int(message.MessageId)) + ":" + hex.EncodeToString(hash[:])
+	now := time.Now()
+
+	filter.lock.Lock()
+	defer filter.lock.Unlock()
+
+	//Expire old entries so the map stays bounded by the message rate
+	for seenKey, received := range filter.seen {
+		if now.Sub(received) > filter.window {
+			delete(filter.seen, seenKey)
+		}
+	}
+
+	if _, ok := filter.seen[key]; ok && message.Header.DUP {
+		return true
+	}
+	filter.seen[key] = now
+	return false
+}
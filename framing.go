@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultFrameTimeout = 2000 //milliseconds
+
+var errFrameTimeout = errors.New("Timed out waiting for a complete response frame")
+
+//responseFraming : Determines where a response read from the serial port ends
+//
+// {"terminator": "\r\n", "length": 0, "timeout": 2000}
+//
+// A frame ends after the terminator or once length bytes are received, whichever comes first.
+// Without a terminator or length, everything received until the device stops sending is one frame.
+type responseFraming struct {
+	terminator string
+	length     int
+	timeout    time.Duration
+}
+
+func defaultResponseFraming() responseFraming {
+	return responseFraming{timeout: defaultFrameTimeout * time.Millisecond}
+}
+
+//parseResponseFraming : Reads a framing rule from a JSON object
+func parseResponseFraming(framingJSON map[string]interface{}) (responseFraming, error) {
+	return defaultResponseFraming().override(framingJSON)
+}
+
+//override : Returns a copy of the framing with the attributes present in framingJSON replaced
+func (framing responseFraming) override(framingJSON map[string]interface{}) (responseFraming, error) {
+	if framingJSON == nil {
+		return framing, nil
+	}
+
+	if terminator, ok := framingJSON["terminator"].(string); ok {
+		framing.terminator = terminator
+	}
+	if length, ok := framingJSON["length"].(float64); ok {
+		if length < 0 || length != float64(int(length)) {
+			return framing, errors.New("framing length must be a positive whole number")
+		}
+		framing.length = int(length)
+	}
+	if timeout, ok := framingJSON["timeout"].(float64); ok {
+		if timeout <= 0 {
+			return framing, errors.New("framing timeout must be a positive number of milliseconds")
+		}
+		framing.timeout = time.Duration(timeout) * time.Millisecond
+	}
+	return framing, nil
+}
+
+//frameEnd : The length of the first complete frame in data, -1 if data does not contain one
+func (framing responseFraming) frameEnd(data string) int {
+	end := -1
+	if framing.terminator != "" {
+		if index := strings.Index(data, framing.terminator); index >= 0 {
+			end = index + len(framing.terminator)
+		}
+	}
+	if framing.length > 0 && len(data) >= framing.length && (end < 0 || end > framing.length) {
+		end = framing.length
+	}
+	return end
+}
+
+//unframed : Returns true if the framing has neither a terminator nor a length
+func (framing responseFraming) unframed() bool {
+	return framing.terminator == "" && framing.length <= 0
+}
+
+//serialReader : Reads the data available on the serial port, blocking for at most the port timeout
+type serialReader func(ctx context.Context) (string, error)
+
+//readFrame : Reads one frame with read, normally serialPort.ReadSerialPort. Data received after the
+//end of the frame is returned separately. If the frame is incomplete when the timeout expires, the
+//partial frame and errFrameTimeout are returned. Must be called while holding serialPortLock.
+func (framing responseFraming) readFrame(ctx context.Context, read serialReader) (frame string, extra string, err error) {
+	ctx, cancel := context.WithTimeout(ctx, framing.timeout)
+	defer cancel()
+
+	received := ""
+	for {
+		if end := framing.frameEnd(received); end >= 0 {
+			return received[:end], received[end:], nil
+		}
+
+		buff, readErr := read(ctx)
+		//Keep what the last read returned, even if the timeout expired during the read
+		received += buff
+		if ctx.Err() != nil {
+			break
+		}
+		if readErr != nil {
+			if !strings.Contains(readErr.Error(), "EOF") {
+				return received, "", readErr
+			}
+			//The device stopped sending, an unframed response is complete
+			if framing.unframed() && received != "" {
+				return received, "", nil
+			}
+		}
+	}
+
+	if end := framing.frameEnd(received); end >= 0 {
+		return received[:end], received[end:], nil
+	}
+	if framing.unframed() && received != "" {
+		return received, "", nil
+	}
+	log.Printf("[DEBUG] readFrame - Incomplete frame after %s, %d bytes read\n", framing.timeout.String(), len(received))
+	return received, "", errFrameTimeout
+}
+
+//frameSplitter : Splits the data read by the read worker into frames. Data after the last complete
+//frame is kept and prepended to the next read. Kept data that is not completed within the framing
+//timeout is returned as a frame of its own.
+type frameSplitter struct {
+	lock    sync.Mutex
+	framing responseFraming
+	pending string
+	since   time.Time
+}
+
+//loadReadFraming : Creates the splitter from the "framing" adapter setting. Without the setting,
+//everything returned by a read is one frame.
+//
+// "framing": {"terminator": "\r\n"}
+func loadReadFraming(adapterSettings map[string]interface{}) (*frameSplitter, error) {
+	framingJSON, ok := adapterSettings["framing"].(map[string]interface{})
+	if !ok && adapterSettings["framing"] != nil {
+		return nil, errors.New("framing must be an object")
+	}
+
+	framing, err := parseResponseFraming(framingJSON)
+	if err != nil {
+		return nil, err
+	}
+	return &frameSplitter{framing: framing}, nil
+}
+
+//Split : Returns the complete frames in the pending data followed by data
+func (splitter *frameSplitter) Split(data string) []string {
+	splitter.lock.Lock()
+	defer splitter.lock.Unlock()
+
+	if splitter.framing.unframed() {
+		return []string{data}
+	}
+
+	var frames []string
+	if splitter.pending != "" && time.Since(splitter.since) > splitter.framing.timeout {
+		log.Printf("[DEBUG] frameSplitter - Incomplete frame after %s, %d bytes\n", splitter.framing.timeout.String(), len(splitter.pending))
+		frames = append(frames, splitter.pending)
+		splitter.pending = ""
+	}
+
+	received := splitter.pending + data
+	completed := false
+	for {
+		end := splitter.framing.frameEnd(received)
+		if end < 0 {
+			break
+		}
+		frames = append(frames, received[:end])
+		received = received[end:]
+		completed = true
+	}
+
+	//The timeout starts when the first byte of a frame is received
+	if splitter.pending == "" || completed {
+		splitter.since = time.Now()
+	}
+	splitter.pending = received
+	if received != "" {
+		log.Printf("[DEBUG] frameSplitter - %d bytes waiting for the rest of the frame\n", len(received))
+	}
+	return frames
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"reflect"
+	"testing"
+	"time"
+)
+
+//scriptedRead : One result of a scriptedReader. With untilDone, the read returns once the context is done.
+type scriptedRead struct {
+	data      string
+	err       error
+	untilDone bool
+}
+
+//scriptedReader : A serialReader returning the reads in order, then EOF after a short delay like an idle port
+func scriptedReader(reads ...scriptedRead) serialReader {
+	return func(ctx context.Context) (string, error) {
+		if len(reads) == 0 {
+			select {
+			case <-time.After(5 * time.Millisecond):
+			case <-ctx.Done():
+			}
+			return "", io.EOF
+		}
+
+		read := reads[0]
+		reads = reads[1:]
+		if read.untilDone {
+			<-ctx.Done()
+		}
+		return read.data, read.err
+	}
+}
+
+func TestReadFrame(t *testing.T) {
+	errBroken := errors.New("input/output error")
+
+	tests := []struct {
+		name          string
+		framing       responseFraming
+		reads         []scriptedRead
+		frame, extra  string
+		expectedError error
+	}{
+		{
+			name:    "terminator",
+			framing: responseFraming{terminator: "\r\n"},
+			reads:   []scriptedRead{{data: "OK"}, {data: "\r"}, {data: "\nnext"}},
+			frame:   "OK\r\n",
+			extra:   "next",
+		},
+		{
+			name:    "length",
+			framing: responseFraming{length: 4},
+			reads:   []scriptedRead{{data: "ab"}, {data: "cdef"}},
+			frame:   "abcd",
+			extra:   "ef",
+		},
+		{
+			name:    "length before terminator",
+			framing: responseFraming{terminator: "\n", length: 3},
+			reads:   []scriptedRead{{data: "abcdef\n"}},
+			frame:   "abc",
+			extra:   "def\n",
+		},
+		{
+			name:    "terminator before length",
+			framing: responseFraming{terminator: "\n", length: 8},
+			reads:   []scriptedRead{{data: "ab\ncdefghij"}},
+			frame:   "ab\n",
+			extra:   "cdefghij",
+		},
+		{
+			name:    "unframed ends when the device stops sending",
+			framing: responseFraming{},
+			reads:   []scriptedRead{{data: "12"}, {data: "34"}},
+			frame:   "1234",
+		},
+		{
+			name:          "incomplete frame",
+			framing:       responseFraming{terminator: "\r\n"},
+			reads:         []scriptedRead{{data: "partial"}},
+			frame:         "partial",
+			expectedError: errFrameTimeout,
+		},
+		{
+			name:          "nothing received",
+			framing:       responseFraming{length: 2},
+			expectedError: errFrameTimeout,
+		},
+		{
+			name:    "frame completed by the read that ends at the timeout",
+			framing: responseFraming{terminator: "\r\n"},
+			reads:   []scriptedRead{{data: "VAL"}, {data: "UE\r\nmore", untilDone: true}},
+			frame:   "VALUE\r\n",
+			extra:   "more",
+		},
+		{
+			name:          "bytes of the read that ends at the timeout are kept",
+			framing:       responseFraming{terminator: "\r\n"},
+			reads:         []scriptedRead{{data: "VAL"}, {data: "UE", untilDone: true}},
+			frame:         "VALUE",
+			expectedError: errFrameTimeout,
+		},
+		{
+			name:    "unframed read that ends at the timeout",
+			framing: responseFraming{},
+			reads:   []scriptedRead{{data: "late", untilDone: true}},
+			frame:   "late",
+		},
+		{
+			name:          "read error",
+			framing:       responseFraming{terminator: "\n"},
+			reads:         []scriptedRead{{data: "ab"}, {data: "c", err: errBroken}},
+			frame:         "abc",
+			expectedError: errBroken,
+		},
+	}
+
+	for _, test := range tests {
+		test.framing.timeout = 50 * time.Millisecond
+		frame, extra, err := test.framing.readFrame(context.Background(), scriptedReader(test.reads...))
+		if frame != test.frame || extra != test.extra || err != test.expectedError {
+			t.Errorf("%s: readFrame returned (%q, %q, %v), expected (%q, %q, %v)", test.name, frame, extra, err, test.frame, test.extra, test.expectedError)
+		}
+	}
+}
+
+func TestParseResponseFraming(t *testing.T) {
+	framing, err := parseResponseFraming(map[string]interface{}{"terminator": "\n", "length": float64(16), "timeout": float64(250)})
+	if err != nil {
+		t.Fatalf("parseResponseFraming returned %s", err.Error())
+	}
+	expected := responseFraming{terminator: "\n", length: 16, timeout: 250 * time.Millisecond}
+	if framing != expected {
+		t.Errorf("parseResponseFraming returned %+v, expected %+v", framing, expected)
+	}
+
+	for _, framingJSON := range []map[string]interface{}{
+		{"length": float64(-1)},
+		{"length": float64(1.5)},
+		{"timeout": float64(0)},
+	} {
+		if _, err := parseResponseFraming(framingJSON); err == nil {
+			t.Errorf("parseResponseFraming(%v) succeeded, expected an error", framingJSON)
+		}
+	}
+}
+
+func TestFrameSplitter(t *testing.T) {
+	tests := []struct {
+		name     string
+		framing  responseFraming
+		reads    []string
+		expected [][]string
+	}{
+		{
+			name:     "unframed",
+			framing:  responseFraming{},
+			reads:    []string{"ab", "c"},
+			expected: [][]string{{"ab"}, {"c"}},
+		},
+		{
+			name:     "terminator across reads",
+			framing:  responseFraming{terminator: "\r\n"},
+			reads:    []string{"one\r", "\ntwo\r\nthr", "ee\r\n"},
+			expected: [][]string{nil, {"one\r\n", "two\r\n"}, {"three\r\n"}},
+		},
+		{
+			name:     "length",
+			framing:  responseFraming{length: 3},
+			reads:    []string{"abcdefg", "hi"},
+			expected: [][]string{{"abc", "def"}, {"ghi"}},
+		},
+	}
+
+	for _, test := range tests {
+		test.framing.timeout = time.Minute
+		splitter := &frameSplitter{framing: test.framing}
+		for i, read := range test.reads {
+			if frames := splitter.Split(read); !reflect.DeepEqual(frames, test.expected[i]) {
+				t.Errorf("%s: read %d split into %q, expected %q", test.name, i, frames, test.expected[i])
+			}
+		}
+	}
+}
+
+func TestFrameSplitterTimeout(t *testing.T) {
+	splitter := &frameSplitter{framing: responseFraming{terminator: "\n", timeout: 20 * time.Millisecond}}
+
+	if frames := splitter.Split("stale"); len(frames) != 0 {
+		t.Fatalf("Incomplete frame split into %q, expected none", frames)
+	}
+	time.Sleep(40 * time.Millisecond)
+
+	//The incomplete frame is returned on its own once the timeout has passed
+	expected := []string{"stale", "fresh\n"}
+	if frames := splitter.Split("fresh\n"); !reflect.DeepEqual(frames, expected) {
+		t.Errorf("Split returned %q after the timeout, expected %q", frames, expected)
+	}
+}
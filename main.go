@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"flag"
@@ -9,16 +11,16 @@ import (
 	"math/rand"
 	"os"
 	"os/signal"
+	"serialAdapter/DeviceProfiles"
 	"serialAdapter/GenericSerial"
+	"serialAdapter/PlatformClient"
 	"strconv"
 	"strings"
 	"sync"
 	"syscall"
 	"time"
 
-	cb "github.com/clearblade/Go-SDK"
 	mqttTypes "github.com/clearblade/mqtt_parsing"
-	mqtt "github.com/clearblade/paho.mqtt.golang"
 	"github.com/hashicorp/logutils"
 )
 
@@ -33,6 +35,7 @@ const (
 	//CONDUIT_PRODUCT_ID_PREFIX      = "MTCDT"        //TODO: remove multitech
 	//XDOT_PRODUCT_ID                = "MTAC-XDOT"    //TODO: remove multitech
 	adapterConfigCollectionDefault = "adapter_config"
+	adapterName                    = "SerialPortAdapter" //adapter_name of the adapter configuration row
 )
 
 var (
@@ -42,62 +45,143 @@ var (
 	sysSec                  string
 	deviceName              string //Defaults to xDotSerialAdapter //TODO: change default
 	activeKey               string
+	deviceToken             string
+	credentialsFile         string
+	deviceTokenFile         string
 	logLevel                string //Defaults to info
-	initLoRaWANPublic       bool   //TODO: remove
 	adapterConfigCollection string
 	readInterval            int
+	healthInterval          int
+	deviceProfileName       string
+	shutdownTimeout         int
+	authMaxBackoff          int
+	caFile                  string
+	clientCertFile          string
+	clientKeyFile           string
+	tlsServerName           string
+	captureFile             string
+	captureMaxSize          int
+	captureFiles            int
+	replayFile              string
+	replaySpeed             float64
+	brokerURL               string
+	mqttUsername            string
+	mqttPassword            string
+	configFile              string
+	settingsCacheFile       string
+	outboxFile              string
+	apiPort                 int
 	isReading               bool
 	isWriting               bool
 
 	serialPortName = ""
+	serialBaudRate = 115200
 
-	// peer 2 peer mode adapter setting defaults TODO: remove
-	networkAddress        = "00:11:22:33"
-	networkSessionKey     = "00:11:22:33:00:11:22:33:00:11:22:33:00:11:22:33"
-	networkDataKey        = "33:22:11:00:33:22:11:00:33:22:11:00:33:22:11:00"
-	transmissionDataRate  = "DR8"
-	transmissionFrequency = "915500000"
+	topicRoot = "serial" //Overridden by the topic_root column of the adapter configuration
 
-	// public ota lora mode adapter setting defaults (empty strings are required adapter settings) TODO: remove
-	networkID        = ""
-	networkKey       = ""
-	frequencySubBand = "0"
+	serialPort    *GenericSerial.SerialPort
+	deviceProfile DeviceProfiles.DeviceProfile
+	cbBroker      cbPlatformBroker
+	//Subscription channel of each request topic, keyed by topic name
+	requestSubscriptions map[string]<-chan *mqttTypes.Publish
+	auth                 *authManager
 
-	topicRoot = "serial/" //TODO: change
+	//Serial port writes waiting for the writeWorker
+	writes = newWriteQueue(defaultWriteQueueSize, defaultWriteSpacing*time.Millisecond)
 
-	serialPort          *GenericSerial.SerialPort
-	serialConfigChanged = false
-	cbBroker            cbPlatformBroker
-	cbSubscribeChannel  <-chan *mqttTypes.Publish
-	endWorkersChannel   chan string
+	//Acknowledgement options of write requests that do not specify their own
+	defaultWriteAck = defaultWriteAckOptions()
+
+	//Splits the data read from the serial port into frames
+	readFraming = &frameSplitter{framing: defaultResponseFraming()}
+
+	//Store-and-forward path of the polling job results
+	outbox = newMessageOutbox(defaultOutboxSize)
+
+	//Runs the polling jobs independently of the broker connection
+	scheduler        *pollScheduler
+	schedulerWorkers *workerSupervisor
+
+	//Tracks whether the device profile has been initialized
+	deviceProfileLock   = &sync.Mutex{}
+	deviceProfileActive bool
+
+	//Suppresses QoS 1 redeliveries of write requests
+	duplicateWrites = newDuplicateFilter(defaultDuplicateWindow * time.Second)
+
+	//Guards deviceToken and rejectedDeviceToken once the adapter is running. The token is reloaded
+	//by authenticateClient and marked rejected by onTokenRejected, from different go routines.
+	deviceTokenLock = &sync.Mutex{}
+
+	//Device token issued by the edge that the platform rejected. It is not used again until the token file changes.
+	rejectedDeviceToken string
+
+	//adapterContext is cancelled when the adapter receives SIGINT or SIGTERM. drainContext is
+	//cancelled once the shutdown timeout expires and bounds writes that are still in flight.
+	adapterContext, cancelAdapter = context.WithCancel(context.Background())
+	drainContext, cancelDrain     = context.WithCancel(context.Background())
+
+	//Runs the subscribeWorker, readWorker, writeWorker and controlWorker while the adapter is connected to the broker
+	workers *workerSupervisor
+
+	//Serializes OnConnect and OnConnectLost, the MQTT client invokes each callback from its own go routine
+	connectionLock = &sync.Mutex{}
 
 	serialPortLock = &sync.Mutex{}
+
+	//Creates the platform client. startTestAdapter (main_test.go) replaces it to run the adapter against a PlatformClient.FakePlatform.
+	newPlatformClient = func(platformBroker cbPlatformBroker) (PlatformClient.Client, error) {
+		if isStandalone() {
+			return newStandaloneClient()
+		}
+		return PlatformClient.NewClearBladeClient(*(platformBroker.platformURL), *(platformBroker.messagingURL), *(platformBroker.systemKey), *(platformBroker.systemSecret), *(platformBroker.username), *(platformBroker.password)), nil
+	}
 )
 
 type cbPlatformBroker struct {
 	name         string
 	clientID     string
-	client       *cb.DeviceClient
+	client       PlatformClient.Client
 	platformURL  *string
 	messagingURL *string
 	systemKey    *string
 	systemSecret *string
 	username     *string
 	password     *string
-	topic        string
-	qos          int
+	tlsConfig    *tls.Config
 }
 
 func init() {
 	flag.StringVar(&sysKey, "systemKey", "", "system key (required)")
 	flag.StringVar(&sysSec, "systemSecret", "", "system secret (required)")
 	flag.StringVar(&deviceName, "deviceName", "genericSerialAdapter", "name of device (optional)")
-	flag.StringVar(&activeKey, "password", "", "password (or active key) for device authentication (required)")
+	flag.StringVar(&activeKey, "password", "", "password (or active key) for device authentication (required unless a device token is provided)")
+	flag.StringVar(&credentialsFile, "credentialsFile", "", "JSON file containing systemKey, systemSecret, deviceName, password and/or deviceToken. Must not be world-readable (optional)")
+	flag.StringVar(&deviceTokenFile, "deviceTokenFile", "", "File containing a device token already issued by the edge, used instead of the password (optional)")
 	flag.StringVar(&platformURL, "platformURL", platURL, "platform url (optional)")
 	flag.StringVar(&messagingURL, "messagingURL", messURL, "messaging URL (optional)")
 	flag.StringVar(&logLevel, "logLevel", "info", "The level of logging to use. Available levels are 'debug, 'info', 'warn', 'error', 'fatal' (optional)")
 	flag.IntVar(&readInterval, "readInterval", 10, "The number of seconds to wait before each successive serial port read. (optional)")
-	//flag.BoolVar(&initLoRaWANPublic, "initLoRaWANPublic", false, "Initialize xdot card to use LoRaWAN Public (optional - peer to peer mode is default)") //TODO: remove
+	flag.StringVar(&deviceProfileName, "deviceProfile", "", "The device profile used to initialize the serial device, use 'raw' for devices that do not speak AT commands. Overrides adapter_settings (optional)")
+	flag.IntVar(&shutdownTimeout, "shutdownTimeout", 10, "The number of seconds to wait for in-flight serial writes to complete when the adapter is stopped. (optional)")
+	flag.IntVar(&authMaxBackoff, "authMaxBackoff", 300, "The maximum number of seconds to wait between authentication attempts. (optional)")
+	flag.IntVar(&healthInterval, "healthInterval", 60, "The number of seconds to wait before each successive device health check. (optional)")
+	flag.StringVar(&caFile, "caFile", "", "PEM bundle of the certificate authorities used to verify the platform and broker certificates (optional)")
+	flag.StringVar(&clientCertFile, "clientCertFile", "", "PEM client certificate used for mutual TLS, requires clientKeyFile (optional)")
+	flag.StringVar(&clientKeyFile, "clientKeyFile", "", "PEM private key of the client certificate used for mutual TLS (optional)")
+	flag.StringVar(&tlsServerName, "tlsServerName", "", "Overrides the server name used to verify the platform and broker certificates (optional)")
+	flag.StringVar(&captureFile, "captureFile", "", "Records every serial port read and write to this file, one JSON object per line (optional)")
+	flag.IntVar(&captureMaxSize, "captureMaxSize", 10, "The size in megabytes at which the capture file is rotated. (optional)")
+	flag.IntVar(&captureFiles, "captureFiles", GenericSerial.DefaultCaptureMaxFiles, "The number of rotated capture files to keep. (optional)")
+	flag.StringVar(&replayFile, "replayFile", "", "Plays back the data received in a capture file instead of opening the serial port (optional)")
+	flag.Float64Var(&replaySpeed, "replaySpeed", 1, "Replay speed, 1 is the original speed, 10 is ten times faster and 0 is as fast as possible. (optional)")
+	flag.StringVar(&brokerURL, "brokerURL", "", "Connects to a generic MQTT broker (ex. tcp://localhost:1883) instead of the ClearBlade platform, the platform flags are then not required (optional)")
+	flag.StringVar(&mqttUsername, "mqttUsername", "", "Username used to connect to the generic MQTT broker (optional)")
+	flag.StringVar(&mqttPassword, "mqttPassword", "", "Password used to connect to the generic MQTT broker (optional)")
+	flag.StringVar(&configFile, "configFile", "", "JSON file containing the adapter configuration used with -brokerURL in place of the adapter configuration collection (optional)")
+	flag.StringVar(&settingsCacheFile, "settingsCacheFile", "", "Keeps the adapter configuration retrieved from the platform in this file, so the adapter can start while the platform is unreachable (optional)")
+	flag.StringVar(&outboxFile, "outboxFile", "", "Keeps the messages waiting in the outbox in this file, so they survive a restart (optional)")
+	flag.IntVar(&apiPort, "apiPort", 0, "Serves the REST API on this localhost port, 0 disables the REST API. (optional)")
 	flag.StringVar(&adapterConfigCollection, "adapterConfigCollection", adapterConfigCollectionDefault, "The name of the data collection used to house adapter configuration (required)")
 }
 
@@ -109,15 +193,47 @@ func usage() {
 func validateFlags() {
 	flag.Parse()
 
-	if sysKey == "" || sysSec == "" || deviceName == "" || activeKey == "" {
+	if err := loadCredentials(); err != nil {
+		log.Printf("ERROR - %s\n\n", err.Error())
+		os.Exit(1)
+	}
+
+	if isStandalone() {
+		if deviceName == "" {
+			log.Printf("ERROR - deviceName is required, it is used as the MQTT client ID\n\n")
+			flag.Usage()
+			os.Exit(1)
+		}
+	} else if sysKey == "" || sysSec == "" || deviceName == "" || (activeKey == "" && deviceToken == "") {
 
 		log.Printf("ERROR - Missing required flags\n\n")
 		flag.Usage()
 		os.Exit(1)
 	}
+
+	if apiPort < 0 || apiPort > 65535 {
+		log.Printf("ERROR - apiPort must be between 0 and 65535\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	if replaySpeed < 0 || captureMaxSize <= 0 || captureFiles < 0 {
+		log.Printf("ERROR - replaySpeed, captureMaxSize and captureFiles must not be negative, captureMaxSize must be at least 1\n\n")
+		flag.Usage()
+		os.Exit(1)
+	}
 }
 
 func main() {
+	//serialAdapter simulate [options] runs the device simulator instead of the adapter
+	if len(os.Args) > 1 && os.Args[1] == simulateCommand {
+		os.Exit(runSimulator(os.Args[2:]))
+	}
+	//serialAdapter tunnel [options] bridges a local pseudo-terminal to a remote adapter
+	if len(os.Args) > 1 && os.Args[1] == tunnelCommand {
+		os.Exit(runTunnel(os.Args[2:]))
+	}
+
 	fmt.Println("Starting serialAdapter...")
 
 	//Validate the command line flags
@@ -137,9 +253,21 @@ func main() {
 
 	log.SetOutput(filter)
 
+	tlsConfig, err := loadTLSConfig()
+	if err != nil {
+		log.Println(err.Error())
+		log.Println("Unable to load TLS configuration. Exiting.")
+		return
+	}
+
+	brokerName := "ClearBlade Platform"
+	if isStandalone() {
+		brokerName = brokerURL
+	}
+
 	cbBroker = cbPlatformBroker{
 
-		name:         "ClearBlade",
+		name:         brokerName,
 		clientID:     deviceName + "client",
 		client:       nil,
 		platformURL:  &platformURL,
@@ -148,10 +276,19 @@ func main() {
 		systemSecret: &sysSec,
 		username:     &deviceName,
 		password:     &activeKey,
-		topic:        "serial/#",
-		qos:          msgSubscribeQos,
+		tlsConfig:    tlsConfig,
 	}
 
+	workers = newWorkerSupervisor(
+		supervisedWorker{name: "subscribeWorker", run: subscribeWorker},
+		supervisedWorker{name: "readWorker", run: readWorker},
+		supervisedWorker{name: "writeWorker", run: writeWorker},
+		supervisedWorker{name: "controlWorker", run: controlWorker},
+	)
+	schedulerWorkers = newWorkerSupervisor(
+		supervisedWorker{name: "pollScheduler", run: func(ctx context.Context) { scheduler.Run(ctx) }},
+	)
+
 	// Initialize ClearBlade Client
 	if err := initCbClient(cbBroker); err != nil {
 		log.Println(err.Error())
@@ -159,9 +296,6 @@ func main() {
 		return
 	}
 
-	defer close(endWorkersChannel)
-	endWorkersChannel = make(chan string)
-
 	//Handle OS interrupts to shut down gracefully
 	c := make(chan os.Signal, 1)
 	signal.Notify(c, syscall.SIGINT, syscall.SIGTERM)
@@ -169,50 +303,100 @@ func main() {
 
 	log.Printf("[INFO] OS signal %s received, ending go routines.", sig)
 
-	//stop serial data mode when adapter is killed
-	log.Println("[INFO] Stopping Serial Data Mode...")
-	if err := serialPort.StopSerialDataMode(); err != nil {
-		log.Println("[WARN] initCbClient - Error stopping serial data mode: " + err.Error())
-	}
+	//Stop accepting new work. Writes already in flight are given shutdownTimeout seconds to complete.
+	cancelAdapter()
+	drainTimer := time.AfterFunc(time.Duration(shutdownTimeout)*time.Second, cancelDrain)
+	defer drainTimer.Stop()
+	stopRESTAPI(time.Duration(shutdownTimeout) * time.Second)
+	bridge.Stop()
+	tunnel.Stop()
 
 	//End the existing goRoutines
-	endWorkersChannel <- "Stop Channel"
-	endWorkersChannel <- "Stop Channel"
+	if err := workers.Stop(time.Duration(shutdownTimeout) * time.Second); err != nil {
+		log.Println("[WARN] main - " + err.Error())
+	}
+	if err := schedulerWorkers.Stop(time.Duration(shutdownTimeout) * time.Second); err != nil {
+		log.Println("[WARN] main - " + err.Error())
+	}
+	failQueuedWrites()
+
+	//return the device to its idle state when adapter is killed. The drain deadline has been spent
+	//by the workers above, the device profile gets shutdownTimeout seconds of its own.
+	log.Printf("[INFO] Shutting down device profile %s...\n", deviceProfile.Name())
+	profileContext, cancelProfile := context.WithTimeout(context.Background(), time.Duration(shutdownTimeout)*time.Second)
+	if err := shutdownDeviceProfile(profileContext); err != nil {
+		log.Println("[WARN] main - Error shutting down device profile: " + err.Error())
+	}
+	cancelProfile()
+
 	os.Exit(0)
 }
 
 // ClearBlade Client init helper
 func initCbClient(platformBroker cbPlatformBroker) error {
-	log.Println("[INFO] initCbClient - Initializing the ClearBlade client")
+	log.Printf("[INFO] initCbClient - Initializing the %s client\n", platformBroker.name)
 
-	log.Printf("[DEBUG] initCbClient - Platform URL: %s\n", *(platformBroker.platformURL))
-	log.Printf("[DEBUG] initCbClient - Platform Messaging URL: %s\n", *(platformBroker.messagingURL))
-	log.Printf("[DEBUG] initCbClient - System Key: %s\n", *(platformBroker.systemKey))
-	log.Printf("[DEBUG] initCbClient - System Secret: %s\n", *(platformBroker.systemSecret))
-	log.Printf("[DEBUG] initCbClient - Username: %s\n", *(platformBroker.username))
-	log.Printf("[DEBUG] initCbClient - Password: %s\n", *(platformBroker.password))
+	if !isStandalone() {
+		log.Printf("[DEBUG] initCbClient - Platform URL: %s\n", *(platformBroker.platformURL))
+		log.Printf("[DEBUG] initCbClient - Platform Messaging URL: %s\n", *(platformBroker.messagingURL))
+		log.Printf("[DEBUG] initCbClient - System Key: %s\n", *(platformBroker.systemKey))
+		log.Printf("[DEBUG] initCbClient - System Secret: %s\n", GenericSerial.RedactSecret(*(platformBroker.systemSecret)))
+		log.Printf("[DEBUG] initCbClient - Username: %s\n", *(platformBroker.username))
+		log.Printf("[DEBUG] initCbClient - Password: %s\n", GenericSerial.RedactSecret(*(platformBroker.password)))
 
-	cbBroker.client = cb.NewDeviceClientWithAddrs(*(platformBroker.platformURL), *(platformBroker.messagingURL), *(platformBroker.systemKey), *(platformBroker.systemSecret), *(platformBroker.username), *(platformBroker.password))
-
-	// for _, err := cbBroker.client.Authenticate(); err != nil; {
-	// 	log.Printf("[ERROR] initCbClient - Error authenticating %s: %s\n", platformBroker.name, err.Error())
-	// 	log.Println("[ERROR] initCbClient - Will retry in 1 minute...")
-
-	// 	// sleep 1 minute
-	// 	time.Sleep(time.Duration(time.Minute * 1))
-	// 	_, err = cbBroker.client.Authenticate()
-	// }
+		applyHTTPTLSConfig(platformBroker.tlsConfig)
+	}
 
-	for _, err := cbBroker.client.Authenticate(); err != nil; {
-		log.Printf("[ERROR] initCbClient - Error authenticating ClearBlade: %s\n", err.Error())
-		time.Sleep(time.Duration(time.Second * 1)) //TODO 10 to 1
-		_, err = cbBroker.client.Authenticate()
+	client, err := newPlatformClient(platformBroker)
+	if err != nil {
+		log.Printf("[ERROR] initCbClient - Unable to create the %s client: %s\n", platformBroker.name, err.Error())
+		return err
+	}
+	cbBroker.client = client
+
+	//Retry with exponential backoff until authenticated or the adapter is stopped. When the
+	//configuration of a previous run is cached, a single attempt is made and the adapter starts
+	//from the cache if it fails, connecting once the platform is reachable.
+	auth = newAuthManager(authenticateClient, time.Duration(authMaxBackoff)*time.Second)
+	if !isStandalone() {
+		configCache = loadAdapterConfigCache(settingsCacheFile)
+	}
+	authenticated := true
+	if configCache.HasRows() {
+		if err := auth.TryAuthenticate(); err != nil {
+			log.Printf("[WARN] initCbClient - Unable to authenticate, starting from the adapter configuration cached in %s: %s\n", settingsCacheFile, err.Error())
+			authenticated = false
+		}
+	} else if err := auth.Authenticate(adapterContext); err != nil {
+		log.Printf("[ERROR] initCbClient - Authentication ended: %s\n", err.Error())
+		return err
 	}
 
 	//Retrieve adapter configuration data
 	log.Println("[INFO] initCbClient - Retrieving adapter configuration...")
 	adapter_settings := getAdapterConfig()
 
+	mqttConfig = loadMqttSettings(adapter_settings)
+	duplicateWrites = newDuplicateFilter(mqttConfig.duplicateWindow)
+	writes = loadWriteQueueSettings(adapter_settings)
+	defaultWriteAck = loadWriteAckSettings(adapter_settings)
+
+	//Select the device profile used to initialize the serial device. The command line wins over adapter_settings.
+	profileName := deviceProfileName
+	if profileName == "" {
+		profileName, _ = adapter_settings["deviceProfile"].(string)
+	}
+	if deviceProfile, err = DeviceProfiles.CreateProfile(profileName, adapter_settings); err != nil {
+		log.Fatalf("[FATAL] initCbClient - Unable to create device profile: %s", err.Error())
+		return err
+	}
+	log.Printf("[INFO] initCbClient - Using device profile %s\n", deviceProfile.Name())
+
+	//A replayed recording takes the place of the serial port
+	if serialPortName == "" && replayFile != "" {
+		serialPortName = replayFile
+	}
+
 	if serialPortName == "" {
 		log.Println("[DEBUG] initCbClient - Retrieving serial port name")
 		setSerialPortName(adapter_settings)
@@ -223,327 +407,463 @@ func initCbClient(platformBroker cbPlatformBroker) error {
 		}
 	}
 
-	serialPort = GenericSerial.CreateSerialPort(serialPortName, 115200, time.Millisecond*2500)
+	if baud, ok := adapter_settings["baudRate"].(float64); ok && baud > 0 {
+		serialBaudRate = int(baud)
+	}
+	lineSettings, err := loadLineSettings(adapter_settings)
+	if err != nil {
+		log.Fatalf("[FATAL] initCbClient - Invalid serial port settings: %s", err.Error())
+		return err
+	}
+	log.Printf("[INFO] initCbClient - Using serial port %s at %s\n", serialPortName, lineSettings.String())
+	rs485Settings, err := loadRS485Settings(adapter_settings)
+	if err != nil {
+		log.Fatalf("[FATAL] initCbClient - Invalid RS-485 configuration: %s", err.Error())
+		return err
+	}
+
+	//Build the topics from the templates now that the device and port names are known
+	if adapterTopics, err = resolveTopics(adapter_settings); err != nil {
+		log.Fatalf("[FATAL] initCbClient - Invalid topic configuration: %s", err.Error())
+		return err
+	}
+	logTopics()
+
+	if payloadTransform, err = loadPayloadTransform(adapter_settings); err != nil {
+		log.Fatalf("[FATAL] initCbClient - Invalid payload transform configuration: %s", err.Error())
+		return err
+	}
+	if readFraming, err = loadReadFraming(adapter_settings); err != nil {
+		log.Fatalf("[FATAL] initCbClient - Invalid framing configuration: %s", err.Error())
+		return err
+	}
+
+	outbox = loadOutboxSettings(adapter_settings)
+	bridgeSettings, err := loadTCPBridgeSettings(adapter_settings)
+	if err != nil {
+		log.Fatalf("[FATAL] initCbClient - Invalid TCP server configuration: %s", err.Error())
+		return err
+	}
+	if scheduler, err = loadPollJobs(adapter_settings); err != nil {
+		log.Fatalf("[FATAL] initCbClient - Invalid polling job configuration: %s", err.Error())
+		return err
+	}
+	lineMonitorInterval, err := loadLineMonitorInterval(adapter_settings)
+	if err != nil {
+		log.Fatalf("[FATAL] initCbClient - Invalid modemLines configuration: %s", err.Error())
+		return err
+	}
+	if tunnel.maxIdleTimeout, err = loadTunnelMaxIdleTimeout(adapter_settings); err != nil {
+		log.Fatalf("[FATAL] initCbClient - Invalid tunnel configuration: %s", err.Error())
+		return err
+	}
+
+	serialPort = GenericSerial.CreateSerialPort(serialPortName, serialBaudRate, time.Millisecond*2500)
+	if replayFile != "" {
+		log.Printf("[INFO] initCbClient - Replaying %s at speed %g instead of reading the serial port\n", replayFile, replaySpeed)
+		serialPort.SetReplay(replayFile, replaySpeed)
+	} else if err := serialPort.SetLineSettings(lineSettings); err != nil {
+		log.Fatalf("[FATAL] initCbClient - Invalid serial port settings: %s", err.Error())
+		return err
+	} else if rs485Settings != nil {
+		if err := serialPort.SetRS485(*rs485Settings); err != nil {
+			log.Fatalf("[FATAL] initCbClient - Invalid RS-485 configuration: %s", err.Error())
+			return err
+		}
+	}
+	if captureFile != "" {
+		serialPort.SetCapture(GenericSerial.CaptureSettings{
+			Path:     captureFile,
+			MaxSize:  int64(captureMaxSize) * 1024 * 1024,
+			MaxFiles: captureFiles,
+		})
+	}
 
 	log.Println("[DEBUG] initCbClient - Opening serial port")
 	if err := serialPort.OpenSerialPort(); err != nil {
 		log.Panic("[FATAL] initCbClient - Error opening serial port: " + err.Error())
 	}
 
-	//Turn off serial data mode in case it is currently on
-	if err := serialPort.StopSerialDataMode(); err != nil {
-		log.Println("[WARN] initCbClient - Error stopping serial data mode: " + err.Error())
+	//Return the device to its idle state in case a previous run left it active (ex. xDot serial data mode).
+	//The raw profile does not write anything to the port.
+	if err := shutdownDeviceProfile(adapterContext); err != nil {
+		log.Println("[WARN] initCbClient - Error resetting device profile: " + err.Error())
 	}
 
-	//Initialize xDot network settings and data rate
-	// if initLoRaWANPublic {
-	// 	log.Println("[INFO] initCbClient - Configuring xDot for LoRaWAN Public")
-	// 	initXDotLoRaWANPublic()
-	// } else {
-	// log.Println("[INFO] initCbClient - Configuring xDot for Peer To Peer")
-	// initXDotPeerToPeer()
-	// //}
-
-	log.Println("[INFO] initCbClient - Initializing MQTT")
-	callbacks := cb.Callbacks{OnConnectionLostCallback: OnConnectLost, OnConnectCallback: OnConnect}
-	if err := cbBroker.client.InitializeMQTTWithCallback(platformBroker.clientID+"-"+strconv.Itoa(rand.Intn(10000)), "", 30, nil, nil, &callbacks); err != nil {
-		log.Fatalf("[FATAL] initCbClient - Unable to initialize MQTT connection with %s: %s", platformBroker.name, err.Error())
-		return err
+	//Polling jobs run whether or not the broker is reachable, so the device is initialized now
+	//and stays initialized when the connection is lost
+	if scheduler.HasJobs() {
+		if err := initDeviceProfile(adapterContext); err != nil {
+			log.Printf("[ERROR] initCbClient - Error initializing device profile, retrying on connect: %s\n", err.Error())
+		}
+		schedulerWorkers.Start(adapterContext)
+	}
+
+	//Input line changes are queued in the outbox while the broker is unreachable
+	if lineMonitorInterval > 0 && replayFile == "" {
+		go monitorModemLines(adapterContext, lineMonitorInterval)
+	}
+
+	//Applications on the gateway can use the serial port whether or not the broker is reachable
+	if apiPort > 0 {
+		startRESTAPI(apiPort)
+	}
+	if bridgeSettings != nil {
+		if bridge, err = startTCPBridge(*bridgeSettings); err != nil {
+			log.Fatalf("[FATAL] initCbClient - Unable to start the TCP serial server: %s", err.Error())
+			return err
+		}
+	}
+
+	if !authenticated {
+		go connectWhenAuthenticated(platformBroker)
+		return nil
+	}
+
+	//The configuration is valid, keep it for a restart without the platform
+	if _, err := configCache.Save(); err != nil {
+		log.Printf("[WARN] initCbClient - Unable to cache the adapter configuration: %s\n", err.Error())
+	}
+
+	if err := initMQTT(platformBroker); err != nil {
+		if !isAuthError(err) {
+			log.Fatalf("[FATAL] initCbClient - Unable to initialize MQTT connection with %s: %s", platformBroker.name, err.Error())
+			return err
+		}
+		//The token was accepted by the REST API but rejected by the broker
+		onTokenRejected()
+	}
+
+	return nil
+}
+
+//connectWhenAuthenticated : Authenticates and connects to the broker after the adapter started from
+//the cached configuration, retrying with backoff until connected or the adapter is stopped. The
+//configuration retrieved from the platform then is cached and applies from the next restart.
+func connectWhenAuthenticated(platformBroker cbPlatformBroker) {
+	err := auth.Authenticate(adapterContext)
+	for err == nil {
+		refreshAdapterConfigCache()
+
+		if err = initMQTT(platformBroker); err == nil {
+			return
+		}
+		log.Printf("[ERROR] connectWhenAuthenticated - Unable to initialize MQTT connection with %s: %s\n", platformBroker.name, err.Error())
+		if err = auth.backoff(adapterContext, err); err == nil {
+			err = auth.retryAuthenticate(adapterContext)
+		}
+	}
+	log.Printf("[WARN] connectWhenAuthenticated - Stopped connecting to %s: %s\n", platformBroker.name, err.Error())
+}
+
+//refreshAdapterConfigCache : Retrieves the cached rows from the platform and saves them if they changed
+func refreshAdapterConfigCache() {
+	for _, name := range configCache.AdapterNames() {
+		queryAdapterConfig(map[string]interface{}{"adapter_name": name})
+	}
+
+	saved, err := configCache.Save()
+	if err != nil {
+		log.Printf("[WARN] refreshAdapterConfigCache - Unable to cache the adapter configuration: %s\n", err.Error())
+	} else if saved {
+		log.Println("[WARN] refreshAdapterConfigCache - The adapter configuration changed on the platform, restart the adapter to apply it")
+	}
+}
+
+//queryAdapterConfig : Queries the adapter configuration collection. The rows retrieved from the
+//platform are cached, the cached rows are used while the platform cannot be reached.
+func queryAdapterConfig(conditions map[string]interface{}) ([]map[string]interface{}, error) {
+	if !configCache.HasRows() || auth.State() == authAuthenticated {
+		rows, err := cbBroker.client.QueryCollection(adapterConfigCollection, conditions)
+		if err == nil {
+			if configCache != nil {
+				configCache.Store(conditions, rows)
+			}
+			return rows, nil
+		}
+		if !configCache.HasRows() {
+			return nil, err
+		}
+		log.Printf("[WARN] queryAdapterConfig - Using the cached adapter configuration: %s\n", err.Error())
+	}
+
+	return configCache.Query(conditions), nil
+}
+
+//initMQTT : Connects to the MQTT broker with the current token. OnConnect establishes the subscriptions.
+func initMQTT(platformBroker cbPlatformBroker) error {
+	log.Println("[INFO] initMQTT - Initializing MQTT")
+	//A persistent session is only resumed when the client ID matches the previous connection
+	clientID := platformBroker.clientID + "-" + strconv.Itoa(rand.Intn(10000))
+	if !mqttConfig.cleanSession {
+		clientID = platformBroker.clientID
 	}
 
+	callbacks := PlatformClient.Callbacks{OnConnectLost: OnConnectLost, OnConnect: OnConnect}
+	if err := cbBroker.client.ConnectMQTT(clientID, platformBroker.tlsConfig, mqttConfig.cleanSession, callbacks); err != nil {
+		log.Printf("[ERROR] initMQTT - Unable to initialize MQTT connection with %s: %s\n", platformBroker.name, err.Error())
+		return err
+	}
 	return nil
 }
 
+//authenticateClient : Obtains a token for the platform client. A token issued by the edge is used
+//until the platform rejects it, after which the active key is used to authenticate.
+func authenticateClient() error {
+	//A generic broker authenticates the MQTT connection with the username and password
+	if isStandalone() {
+		return cbBroker.client.Authenticate()
+	}
+
+	if token := currentDeviceToken(); token != "" {
+		log.Println("[INFO] authenticateClient - Using device token issued by the edge")
+		cbBroker.client.SetToken(token)
+		return nil
+	}
+
+	if activeKey == "" {
+		return errNoReauthCredentials
+	}
+
+	return cbBroker.client.Authenticate()
+}
+
+//currentDeviceToken : The device token issued by the edge, reloaded from the token file in case the
+//edge rotated it. Empty if there is no token or the platform rejected it.
+func currentDeviceToken() string {
+	deviceTokenLock.Lock()
+	defer deviceTokenLock.Unlock()
+
+	if deviceTokenFile != "" {
+		if contents, err := readSecretFile(deviceTokenFile); err != nil {
+			log.Printf("[WARN] currentDeviceToken - Unable to reload device token: %s\n", err.Error())
+		} else if token := strings.TrimSpace(string(contents)); token != "" {
+			deviceToken = token
+		}
+	}
+
+	if deviceToken == rejectedDeviceToken {
+		return ""
+	}
+	return deviceToken
+}
+
+//onTokenRejected : Re-authenticates in the background and reconnects to the broker. Called from
+//the MQTT callbacks and publish; while a re-authentication is running further calls only record
+//the rejected token.
+func onTokenRejected() {
+	deviceTokenLock.Lock()
+	if token := cbBroker.client.Token(); token != "" && token == deviceToken {
+		rejectedDeviceToken = deviceToken
+	}
+	deviceTokenLock.Unlock()
+
+	go auth.Reauthenticate(adapterContext, func() error {
+		//Stop the automatic reconnect that is still using the rejected token
+		if err := cbBroker.client.Disconnect(); err != nil {
+			log.Printf("[DEBUG] onTokenRejected - Error disconnecting from broker: %s\n", err.Error())
+		}
+		return initMQTT(cbBroker)
+	})
+}
+
 //OnConnectLost :
 //If the connection to the broker is lost, we need to reconnect and
 //re-establish all of the subscriptions
-func OnConnectLost(client mqtt.Client, connerr error) {
+func OnConnectLost(connerr error) {
 	log.Printf("[INFO] OnConnectLost - Connection to broker was lost: %s\n", connerr.Error())
 
+	connectionLock.Lock()
+	defer connectionLock.Unlock()
+
+	//The tunnel client cannot reach the serial port without the broker, release the port for the
+	//polling jobs
+	tunnel.CloseSession("Connection to the broker was lost")
+
+	//The callback can be invoked without a matching OnConnect, there is nothing to stop in that case
+	if workers.State() == workersStopped {
+		log.Println("[DEBUG] OnConnectLost - Workers are not running")
+		return
+	}
+
 	//End the existing goRoutines
-	endWorkersChannel <- "Stop Channel"
-	endWorkersChannel <- "Stop Channel"
+	if err := workers.Stop(time.Duration(shutdownTimeout) * time.Second); err != nil {
+		log.Println("[WARN] OnConnectLost - " + err.Error())
+	}
+
+	//The device profile stays initialized so OnConnect does not repeat Init on every broker
+	//flap (xdot-otaa would rejoin the network and send uplinks each time)
+
+	//The auto reconnect logic cannot recover from a rejected token, obtain a new one and reconnect
+	if isAuthError(connerr) {
+		onTokenRejected()
+		return
+	}
 
 	//We don't need to worry about manally re-initializing the mqtt client. The auto reconnect logic will
 	//automatically try and reconnect. The reconnect interval could be as much as 20 minutes.
 }
 
 //When the connection to the broker is complete, set up the subscriptions
-func OnConnect(client mqtt.Client) {
-	log.Println("[INFO] OnConnect - Connected to ClearBlade Platform MQTT broker")
+func OnConnect() {
+	log.Printf("[INFO] OnConnect - Connected to %s MQTT broker\n", cbBroker.name)
+
+	connectionLock.Lock()
+	defer connectionLock.Unlock()
+
+	//A reconnect can be reported without a preceding OnConnectLost. Stop any workers still
+	//bound to the previous subscription before the device profile touches the port.
+	if workers.State() != workersStopped {
+		log.Println("[INFO] OnConnect - Stopping workers from the previous connection")
+		if err := workers.Stop(time.Duration(shutdownTimeout) * time.Second); err != nil {
+			log.Println("[WARN] OnConnect - " + err.Error())
+		}
+	}
 
 	//CleanSession, by default, is set to true. This results in non-durable subscriptions.
 	//We therefore need to re-subscribe
 	log.Println("[DEBUG] OnConnect - Begin Configuring Subscription(s)")
 
-	var err error
-	for cbSubscribeChannel, err = subscribe(topicRoot + "/+/request"); err != nil; {
+	subscriptions, err := subscribeRequestTopics()
+	for err != nil {
 		//Wait 30 seconds and retry
 		log.Printf("[ERROR] OnConnect - Error subscribing to MQTT: %s\n", err.Error())
+		if isAuthError(err) {
+			onTokenRejected()
+			return
+		}
 		log.Println("[ERROR] OnConnect - Will retry in 30 seconds...")
-		time.Sleep(time.Duration(30 * time.Second))
-		cbSubscribeChannel, err = subscribe(topicRoot + "/+/request")
+		select {
+		case <-time.After(30 * time.Second):
+		case <-adapterContext.Done():
+			log.Println("[INFO] OnConnect - Adapter is shutting down, not subscribing")
+			return
+		}
+		subscriptions, err = subscribeRequestTopics()
 	}
+	requestSubscriptions = subscriptions
 
 	isReading = false
 	isWriting = false
 
-	log.Println("[DEBUG] OnConnect - about to flush serial port")
-	//Flush serial port one last time
-	if err := serialPort.FlushSerialPort(); err != nil {
-		log.Println("[ERROR] OnConnect - Error flushing serial port: " + err.Error())
+	//Let the device profile prepare the device for data exchange
+	if err := initDeviceProfile(adapterContext); err != nil {
+		if adapterContext.Err() != nil {
+			log.Println("[INFO] OnConnect - Adapter is shutting down, not starting workers")
+			return
+		}
+		log.Panic("[FATAL] OnConnect - Error initializing device profile: " + err.Error())
 	}
 
-	log.Println("[DEBUG] OnConnect - about to enter serial data mode")
-	//Enter serial data mode
-	log.Println("[DEBUG] OnConnect - Entering serial data mode...")
-	if err := serialPort.StartSerialDataMode(); err != nil {
-		log.Panic("[FATAL] OnConnect - Error starting serial data mode: " + err.Error())
+	if !workers.Start(adapterContext) {
+		log.Println("[WARN] OnConnect - Workers were already running")
 	}
 
-	//Start subscribe worker
-	go subscribeWorker()
-
-	//Start read loop
-	go readWorker()
+	//Publish the data stored while the broker was unreachable
+	go outbox.Flush(adapterContext)
 }
 
-// func initXDotPeerToPeer() {
-// 	//http://www.multitech.net/developer/software/mdot-software/peer-to-peer/
-
-// 	// In order to get the xDot card in Peer to Peer mode, we need to write AT commands
-// 	// to the serial port:
-// 	//
-// 	// AT+NJM=3 --> Set network join mode to peer to peer (3)
-// 	// AT+NA=00:11:22:33 --> Set network address: Must be the same for all xDots. devAddr in LoraMac
-// 	// AT+NSK=00:11:22:33:00:11:22:33:00:11:22:33:00:11:22:33 --> Set network session key: Must be the same for all xDots.
-// 	// AT+DSK=33:22:11:00:33:22:11:00:33:22:11:00:33:22:11:00 --> Set data session key: Must be the same for all xDots.
-// 	// AT+TXDR=DR8 (US:DR8-DR13,EU:DR0-DR6) --> Set the transmission data rate for all channels
-// 	// AT+TXF=915500000 (US-ONLY:915.5-919.7) --> Set the transmission frequency
-// 	// AT&W --> Save configuration to flash memory
-// 	// ATZ --> Reset CPU: Takes about 3 seconds
-// 	// AT+SD --> Serial Data Mode
-
-// 	//Set network join mode to peer to peer
-// 	log.Println("[INFO] initXDotPeerToPeer - Setting network join mode...")
-// 	if valueChanged, err := serialPort.SetNetworkJoinMode(xDotSerial.PeerToPeerMode); err != nil {
-// 		panic(err.Error())
-// 	} else {
-// 		if valueChanged == true {
-// 			serialConfigChanged = true
-// 		}
-// 	}
-
-// 	//Set the device class to class C
-// 	log.Println("[INFO] initXDotPeerToPeer - Setting device class...")
-// 	if valueChanged, err := serialPort.SetDeviceClass(xDotSerial.DeviceClassC); err != nil {
-// 		panic(err.Error())
-// 	} else {
-// 		if valueChanged == true {
-// 			serialConfigChanged = true
-// 		}
-// 	}
-
-// 	//Set network address
-// 	log.Println("[INFO] initXDotPeerToPeer - Setting network address...")
-// 	if valueChanged, err := serialPort.SetNetworkAddress(networkAddress); err != nil {
-// 		panic(err.Error())
-// 	} else {
-// 		if valueChanged == true {
-// 			serialConfigChanged = true
-// 		}
-// 	}
-
-// 	//Set network session key
-// 	log.Println("[INFO] initXDotPeerToPeer - Setting network session key...")
-// 	if valueChanged, err := serialPort.SetNetworkSessionKey(networkSessionKey); err != nil {
-// 		panic(err.Error())
-// 	} else {
-// 		if valueChanged == true {
-// 			serialConfigChanged = true
-// 		}
-// 	}
-
-// 	//Set data session key
-// 	log.Println("[INFO] initXDotPeerToPeer - Setting data session key...")
-// 	if valueChanged, err := serialPort.SetDataSessionKey(networkDataKey); err != nil {
-// 		panic(err.Error())
-// 	} else {
-// 		if valueChanged == true {
-// 			serialConfigChanged = true
-// 		}
-// 	}
+//initDeviceProfile : Flushes the serial port and initializes the device profile unless it is already initialized
+func initDeviceProfile(ctx context.Context) error {
+	deviceProfileLock.Lock()
+	defer deviceProfileLock.Unlock()
 
-// 	//Set transmission data rate
-// 	log.Println("[INFO] initXDotPeerToPeer - Setting transmission data rate...")
-// 	if valueChanged, err := serialPort.SetDataRate(transmissionDataRate); err != nil {
-// 		panic(err.Error())
-// 	} else {
-// 		if valueChanged == true {
-// 			serialConfigChanged = true
-// 		}
-// 	}
-
-// 	//Set transmission frequency
-// 	log.Println("[INFO] initXDotPeerToPeer - Setting transmission frequency...")
-// 	if valueChanged, err := serialPort.SetFrequency(transmissionFrequency); err != nil {
-// 		panic(err.Error())
-// 	} else {
-// 		if valueChanged == true {
-// 			serialConfigChanged = true
-// 		}
-// 	}
-
-// 	if serialConfigChanged == true {
-// 		log.Println("[DEBUG] initXDotPeerToPeer - xDot configuration changed, saving new values...")
-// 		//Save the xDot configuration
-// 		if err := serialPort.SaveConfiguration(); err != nil {
-// 			log.Println("[WARN] initXDotPeerToPeer - Error saving xDot configuration: " + err.Error())
-// 		}
-// 		// Temporarily comment this out as it appears this hangs the xDot
-// 		//
-// 		// else {
-// 		// 	//Reset the xDot CPU
-// 		// 	log.Println("[DEBUG] initXDotPeerToPeer - Resetting xDot CPU...")
-// 		// 	if err := serialPort.ResetSerialCPU(); err != nil {
-// 		// 		log.Panic("[FATAL] initXDotPeerToPeer - Error resetting xDot CPU: " + err.Error())
-// 		// 	}
-// 		// }
-// 	}
-// }
-
-// func initXDotLoRaWANPublic() {
-// 	// AT+NJM=1
-// 	// AT+NI=00-11-22-33-44-aa-bb-cc (from lora network server, all connecting lora device suse same)
-// 	// AT+NK=00.11.22.33.44.55.66.77.88.99.aa.bb.cc.dd.ee.ff (from lora network server, all connecting lora device suse same)
-// 	// AT+FSB=1 (based off lora network server, should come from config collection)
-// 	// AT+TXDR=3 (could depend on solution, pulling from config collection)
-// 	// save it!
-// 	// join it!
-
-// 	//Set network join mode to LoRaWAN Public
-// 	log.Println("[INFO] initXDotLoRaWANPublic - Setting network join mode...")
-// 	if valueChanged, err := serialPort.SetNetworkJoinMode(xDotSerial.OtaJoinMode); err != nil {
-// 		panic(err.Error())
-// 	} else {
-// 		if valueChanged == true {
-// 			serialConfigChanged = true
-// 		}
-// 	}
-
-// 	// Set public network mode to 1 AT+PN=1
-// 	log.Println("[INFO] initXDotLoRaWANPublic - Setting public network mode...")
-// 	if valueChanged, err := serialPort.SetPublicNetworkMode(xDotSerial.PublicLoRaWANNetworkMode); err != nil {
-// 		panic(err.Error())
-// 	} else {
-// 		if valueChanged == true {
-// 			serialConfigChanged = true
-// 		}
-// 	}
-
-// 	//Set the device class to class C
-// 	log.Println("[INFO] initXDotLoRaWANPublic - Setting device class...")
-// 	if valueChanged, err := serialPort.SetDeviceClass(xDotSerial.DeviceClassC); err != nil {
-// 		panic(err.Error())
-// 	} else {
-// 		if valueChanged == true {
-// 			serialConfigChanged = true
-// 		}
-// 	}
-
-// 	//Set Network ID
-// 	log.Println("[INFO] initXDotLoRaWANPublic - Setting Network ID...")
-// 	if valueChanged, err := serialPort.SetNetworkID(networkID); err != nil {
-// 		panic(err.Error())
-// 	} else {
-// 		if valueChanged {
-// 			serialConfigChanged = true
-// 		}
-// 	}
+	if deviceProfileActive {
+		log.Printf("[DEBUG] initDeviceProfile - Device profile %s already initialized\n", deviceProfile.Name())
+		return nil
+	}
 
-// 	//Set Network Key
-// 	log.Println("[INFO] initXDotLoRaWANPublic - Setting Network Key...")
-// 	if valueChanged, err := serialPort.SetNetworkKey(networkKey); err != nil {
-// 		panic(err.Error())
-// 	} else {
-// 		if valueChanged {
-// 			serialConfigChanged = true
-// 		}
-// 	}
+	serialPortLock.Lock()
+	defer serialPortLock.Unlock()
 
-// 	//Set Network Frequency Sub-Band
-// 	log.Println("[INFO] initXDotLoRaWANPublic - Setting Network Frequence Sub-Band...")
-// 	if valueChanged, err := serialPort.SetFrequencySubBand(frequencySubBand); err != nil {
-// 		panic(err.Error())
-// 	} else {
-// 		if valueChanged {
-// 			serialConfigChanged = true
-// 		}
-// 	}
+	//The device profile is initialized when the exclusive session releases the port
+	if serialPortOwner != "" {
+		log.Println("[DEBUG] initDeviceProfile - " + errSerialPortReserved.Error())
+		return nil
+	}
 
-// 	// Set Date RateAT+TXDR=3
-// 	log.Println("[INFO] initXDotLoRaWANPublic - Setting transmission data rate...")
-// 	if valueChanged, err := serialPort.SetDataRate(transmissionDataRate); err != nil {
-// 		panic(err.Error())
-// 	} else {
-// 		if valueChanged == true {
-// 			serialConfigChanged = true
-// 		}
-// 	}
+	log.Println("[DEBUG] initDeviceProfile - about to flush serial port")
+	//Flush serial port one last time
+	if err := serialPort.FlushSerialPort(); err != nil {
+		log.Println("[ERROR] initDeviceProfile - Error flushing serial port: " + err.Error())
+	}
 
-// 	if serialConfigChanged == true {
-// 		log.Println("[DEBUG] initXDotLoRaWANPublic - xDot configuration changed, saving new values...")
-// 		//Save the xDot configuration
-// 		if err := serialPort.SaveConfiguration(); err != nil {
-// 			log.Println("[WARN] initXDotLoRaWANPublic - Error saving xDot configuration: " + err.Error())
-// 		}
-// 	}
+	log.Printf("[DEBUG] initDeviceProfile - Initializing device profile %s...\n", deviceProfile.Name())
+	if err := deviceProfile.Init(ctx, serialPort); err != nil {
+		return err
+	}
+	deviceProfileActive = true
+	return nil
+}
 
-// 	//Join network
-// 	log.Println("[INFO] initXDotLoRaWANPublic - Joining Network...")
-// 	if err := serialPort.JoinNetwork(); err != nil {
-// 		panic(err.Error())
-// 	}
+//shutdownDeviceProfile : Returns the device to its idle state
+func shutdownDeviceProfile(ctx context.Context) error {
+	deviceProfileLock.Lock()
+	defer deviceProfileLock.Unlock()
 
-// 	// For us to start receiving downlinks, we need to AT+SEND 2 empty messages...
-// 	//  http://www.multitech.net/developer/software/lora/class-c-walkthrough/
-// 	// 1. AT+SEND to acknowledge Join Accept.
-// 	// 2. AT+SEND to acknowledge first downlink MAC commands
-// 	log.Println("[INFO] initXDotLoRaWANPublic - AT+SEND to ack Join Accept")
-// 	if err := serialPort.SendData(""); err != nil {
-// 		panic(err.Error())
-// 	}
+	serialPortLock.Lock()
+	defer serialPortLock.Unlock()
 
-// 	log.Println("[INFO] initXDotLoRaWANPublic - AT+SEND to ack first downlink MAC commands")
-// 	if err := serialPort.SendData(""); err != nil {
-// 		panic(err.Error())
-// 	}
+	deviceProfileActive = false
+	return deviceProfile.Shutdown(ctx, serialPort)
+}
 
-// }
+//isConnected : True if the MQTT client is connected to the broker
+func isConnected() bool {
+	return cbBroker.client != nil && cbBroker.client.IsConnected()
+}
 
-func subscribeWorker() {
+func subscribeWorker(ctx context.Context) {
 	log.Println("[INFO] subscribeWorker - Starting subscribeWorker")
 
-	defer serialPort.StopSerialDataMode()
+	requests := mergeSubscriptions(ctx, requestSubscriptions)
 
 	//Wait for subscriptions to be received
 	for {
 		select {
-		case message, ok := <-cbSubscribeChannel:
-			if ok {
-				//Determine if a read or write request was received
-				if strings.HasSuffix(message.Topic.Whole, serialRead+"/request") {
-					log.Println("[INFO] subscribeWorker - Handling read request...")
-					readFromSerialPort()
-				} else if strings.HasSuffix(message.Topic.Whole, serialWrite+"/request") {
-					// If write request...
-					log.Println("[INFO] subscribeWorker - Handling write request...")
-					writeToSerialPort(string(message.Payload))
-				} else {
-					log.Printf("[DEBUG] subscribeWorker - Unknown request received: topic = %s, payload = %#v\n", message.Topic.Whole, message.Payload)
+		case request, ok := <-requests:
+			if !ok {
+				//A nil channel blocks forever, leaving the worker waiting for the stop signal
+				log.Println("[WARN] subscribeWorker - Subscription channels closed")
+				requests = nil
+				continue
+			}
+
+			//Determine if a read or write request was received
+			message := request.message
+			switch request.name {
+			case readRequestTopicName:
+				log.Println("[INFO] subscribeWorker - Handling read request...")
+				readFromSerialPort(ctx)
+			case writeRequestTopicName:
+				if duplicateWrites.IsDuplicate(message) {
+					log.Printf("[INFO] subscribeWorker - Discarding redelivered write request, message ID = %d\n", message.MessageId)
+					continue
+				}
+				log.Println("[INFO] subscribeWorker - Handling write request...")
+				//Queue the write so a slow read or write does not stall the subscription
+				request, err := parseWriteRequest(message.Payload, defaultWriteAck, writes.envelope)
+				if err == nil {
+					err = encodeWriteCommand(request)
+				}
+				if err == nil {
+					err = writes.Push(request)
 				}
+				if err != nil {
+					log.Printf("[WARN] subscribeWorker - Rejecting write request %s: %s\n", request.requestID, err.Error())
+					publishWriteResponse(writeResult{RequestID: request.requestID, Error: err.Error()})
+				}
+			case tunnelSessionTopicName:
+				tunnel.HandleSession(message.Payload)
+			case tunnelTxTopicName:
+				tunnel.Write(message.Payload)
+			case controlRequestTopicName:
+				//Pulses and breaks take time, the subscription is not held up
+				queueControlRequest(message.Payload)
+			default:
+				log.Printf("[DEBUG] subscribeWorker - Unknown request received: topic = %s, payload = %#v\n", message.Topic.Whole, message.Payload)
 			}
-		case _ = <-endWorkersChannel:
+		case <-ctx.Done():
 			//End the current go routine when the stop signal is received
 			log.Println("[INFO] subscribeWorker - Stopping subscribeWorker")
 			return
@@ -551,27 +871,89 @@ func subscribeWorker() {
 	}
 }
 
-func readWorker() {
+//writeWorker : Writes queued requests to the serial port in priority order
+func writeWorker(ctx context.Context) {
+	log.Println("[INFO] writeWorker - Starting writeWorker")
+
+	for {
+		request := writes.Next(ctx)
+		if request == nil {
+			log.Println("[INFO] writeWorker - Stopping writeWorker")
+			return
+		}
+
+		log.Printf("[DEBUG] writeWorker - Writing request %s, priority = %d, queued for %s\n", request.requestID, request.priority, time.Since(request.enqueued).String())
+
+		//Writes are bounded by the drain context so a shutdown does not cut them short
+		started := time.Now()
+		written, confirmed, err := writeToSerialPort(drainContext, request)
+		writes.Completed(err)
+
+		result := newWriteResult(request, written, confirmed, started, err)
+		if request.done != nil {
+			request.done <- result
+		} else {
+			publishWriteResponse(result)
+		}
+
+		//Many devices need a gap between commands
+		if writes.spacing > 0 {
+			timer := time.NewTimer(writes.spacing)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+			}
+		}
+	}
+}
+
+//failQueuedWrites : Publishes a failure result for each write still queued when the adapter stops
+func failQueuedWrites() {
+	discarded := writes.Discard()
+	if len(discarded) > 0 {
+		log.Printf("[WARN] failQueuedWrites - Discarding %d queued writes\n", len(discarded))
+	}
+
+	for _, request := range discarded {
+		writes.Completed(errWriteDiscarded)
+		result := writeResult{RequestID: request.requestID, Error: errWriteDiscarded.Error()}
+		if request.done != nil {
+			request.done <- result
+		} else {
+			publishWriteResponse(result)
+		}
+	}
+}
+
+func readWorker(ctx context.Context) {
 	log.Println("[INFO] readWorker - Starting readWorker")
 	ticker := time.NewTicker(time.Duration(readInterval) * time.Second)
+	healthTicker := time.NewTicker(time.Duration(healthInterval) * time.Second)
 
 	for {
 		select {
 		case <-ticker.C:
 			log.Println("[DEBUG] readWorker - Reading from serial port")
-			readFromSerialPort()
-		case <-endWorkersChannel:
+			readFromSerialPort(ctx)
+		case <-healthTicker.C:
+			log.Println("[DEBUG] readWorker - Checking device health")
+			publishHealth(ctx)
+			//Retry stored messages whose publish failed while connected
+			outbox.Flush(ctx)
+		case <-ctx.Done():
 			log.Println("[DEBUG] readWorker - stopping ticker")
 			ticker.Stop()
+			healthTicker.Stop()
 			return
 		}
 	}
 }
 
 // Subscribes to a topic
-func subscribe(topic string) (<-chan *mqttTypes.Publish, error) {
-	log.Printf("[DEBUG] subscribe - Subscribing to topic %s\n", topic)
-	subscription, error := cbBroker.client.Subscribe(topic, cbBroker.qos)
+func subscribe(topic string, qos int) (<-chan *mqttTypes.Publish, error) {
+	log.Printf("[DEBUG] subscribe - Subscribing to topic %s with qos %d\n", topic, qos)
+	subscription, error := cbBroker.client.Subscribe(topic, qos)
 	if error != nil {
 		log.Printf("[ERROR] subscribe - Unable to subscribe to topic: %s due to error: %s\n", topic, error.Error())
 		return nil, error
@@ -581,12 +963,72 @@ func subscribe(topic string) (<-chan *mqttTypes.Publish, error) {
 	return subscription, nil
 }
 
+//topicMessage : A message received on one of the subscribed topics
+type topicMessage struct {
+	name    string
+	message *mqttTypes.Publish
+}
+
+//subscribeRequestTopics : Subscribes to each request topic with its configured QoS
+func subscribeRequestTopics() (map[string]<-chan *mqttTypes.Publish, error) {
+	subscriptions := map[string]<-chan *mqttTypes.Publish{}
+	for _, name := range subscribeTopicNames {
+		subscription, err := subscribe(topic(name), mqttConfig.options(name).qos)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions[name] = subscription
+	}
+	return subscriptions, nil
+}
+
+//mergeSubscriptions : Fans the subscription channels in to a single channel. The returned
+//channel is closed once every subscription channel is closed or ctx is done.
+func mergeSubscriptions(ctx context.Context, subscriptions map[string]<-chan *mqttTypes.Publish) <-chan topicMessage {
+	merged := make(chan topicMessage)
+	group := &sync.WaitGroup{}
+
+	for name, subscription := range subscriptions {
+		group.Add(1)
+		go func(name string, subscription <-chan *mqttTypes.Publish) {
+			defer group.Done()
+			for {
+				select {
+				case message, ok := <-subscription:
+					if !ok {
+						log.Printf("[DEBUG] mergeSubscriptions - Subscription to %s closed\n", name)
+						return
+					}
+					select {
+					case merged <- topicMessage{name: name, message: message}:
+					case <-ctx.Done():
+						return
+					}
+				case <-ctx.Done():
+					return
+				}
+			}
+		}(name, subscription)
+	}
+
+	go func() {
+		group.Wait()
+		close(merged)
+	}()
+
+	return merged
+}
+
 // Publishes data to a topic
-func publish(topic string, data string) error {
-	log.Printf("[DEBUG] publish - Publishing to topic %s\n", topic)
-	error := cbBroker.client.Publish(topic, []byte(data), cbBroker.qos)
+func publish(topic string, data string, options topicOptions) error {
+	log.Printf("[DEBUG] publish - Publishing to topic %s with qos %d, retain %t\n", topic, options.qos, options.retain)
+
+	error := cbBroker.client.Publish(topic, []byte(data), options.qos, options.retain)
 	if error != nil {
 		log.Printf("[ERROR] publish - Unable to publish to topic: %s due to error: %s\n", topic, error.Error())
+		if isAuthError(error) {
+			onTokenRejected()
+		}
 		return error
 	}
 
@@ -600,32 +1042,29 @@ func getAdapterConfig() map[string]interface{} {
 	log.Println("[INFO] getAdapterConfig - Retrieving adapter config")
 
 	//Retrieve the adapter configuration row
-	query := cb.NewQuery()
-	query.EqualTo("adapter_name", "SerialPortAdapter")
+	conditions := map[string]interface{}{"adapter_name": adapterName}
 
-	//A nil query results in all rows being returned
 	log.Println("[DEBUG] getAdapterConfig - Executing query against table " + adapterConfigCollection)
-	results, err := cbBroker.client.GetDataByName(adapterConfigCollection, query)
+	results, err := queryAdapterConfig(conditions)
 	if err != nil {
 		log.Println("[DEBUG] getAdapterConfig - Adapter configuration could not be retrieved. Using defaults")
 		log.Printf("[DEBUG] getAdapterConfig - Error: %s\n", err.Error())
 	} else {
-		if len(results["DATA"].([]interface{})) > 0 {
-			log.Printf("[DEBUG] getAdapterConfig - Adapter config retrieved: %#v\n", results)
+		if len(results) > 0 {
 			log.Println("[INFO] getAdapterConfig - Adapter config retrieved")
 
 			//topic root
-			if results["DATA"].([]interface{})[0].(map[string]interface{})["topic_root"] != nil {
-				log.Printf("[DEBUG] getAdapterConfig - Setting topicRoot to %s\n", results["DATA"].([]interface{})[0].(map[string]interface{})["topic_root"].(string))
-				topicRoot = results["DATA"].([]interface{})[0].(map[string]interface{})["topic_root"].(string)
+			if results[0]["topic_root"] != nil {
+				log.Printf("[DEBUG] getAdapterConfig - Setting topicRoot to %s\n", results[0]["topic_root"].(string))
+				topicRoot = strings.Trim(results[0]["topic_root"].(string), "/")
 			} else {
 				log.Printf("[DEBUG] getAdapterConfig - Topic root is nil. Using default value %s\n", topicRoot)
 			}
 
 			//adapter_settings
 			log.Println("[DEBUG] getAdapterConfig - Retrieving adapter settings...")
-			if results["DATA"].([]interface{})[0].(map[string]interface{})["adapter_settings"] != nil {
-				if err := json.Unmarshal([]byte(results["DATA"].([]interface{})[0].(map[string]interface{})["adapter_settings"].(string)), &settingsJson); err != nil {
+			if results[0]["adapter_settings"] != nil {
+				if err := json.Unmarshal([]byte(results[0]["adapter_settings"].(string)), &settingsJson); err != nil {
 					log.Printf("[DEBUG] getAdapterConfig - Error while unmarshalling json: %s. Defaulting all adapter settings.\n", err.Error())
 				}
 			} else {
@@ -640,88 +1079,9 @@ func getAdapterConfig() map[string]interface{} {
 		settingsJson = make(map[string]interface{})
 	}
 
-	applyAdapterSettings(settingsJson)
-
 	return settingsJson
 }
 
-func applyAdapterSettings(adapterSettings map[string]interface{}) {
-
-	if initLoRaWANPublic {
-		//networkID
-		if adapterSettings["networkID"] != nil {
-			networkID = adapterSettings["networkID"].(string)
-			log.Printf("[DEBUG] applyAdapterConfig - Setting networkID to %s", networkID)
-		} else {
-			log.Printf("[ERROR] applyAdapterSettings - A networkID value is expected, using a bogus value that likely will not work\n")
-			adapterSettings["networkID"] = networkID
-		}
-		//networkKey
-		if adapterSettings["networkKey"] != nil {
-			networkKey = adapterSettings["networkKey"].(string)
-			log.Printf("[DEBUG] applyAdapterConfig - Setting networkKey to %s", networkKey)
-		} else {
-			log.Printf("[ERROR] applyAdapterSettings - A networkKey value is expected, using a bogus value that likely will not work\n")
-			adapterSettings["networkKey"] = networkKey
-		}
-		//frequencySubBand
-		if adapterSettings["frequencySubBand"] != nil {
-			frequencySubBand = adapterSettings["frequencySubBand"].(string)
-			log.Printf("[DEBUG] applyAdapterConfig - Setting frequencySubBand to %s", frequencySubBand)
-		} else {
-			adapterSettings["frequencySubBand"] = frequencySubBand
-		}
-		//transmissionDataRate
-		if adapterSettings["transmissionDataRate"] != nil {
-			log.Printf("[DEBUG] applyAdapterConfig - Setting transmissionDataRate to %s", adapterSettings["transmissionDataRate"].(string)+"\n")
-			transmissionDataRate = adapterSettings["transmissionDataRate"].(string)
-		} else {
-			adapterSettings["transmissionDataRate"] = transmissionDataRate
-		}
-	} else {
-		//networkAddress
-		if adapterSettings["networkAddress"] != nil {
-			log.Printf("[DEBUG] applyAdapterConfig - Setting networkAddress to %s", adapterSettings["networkAddress"].(string)+"\n")
-			networkAddress = adapterSettings["networkAddress"].(string)
-		} else {
-			adapterSettings["networkAddress"] = networkAddress
-		}
-
-		//networkSessionKey
-		if adapterSettings["networkSessionKey"] != nil {
-			log.Printf("[DEBUG] applyAdapterConfig - Setting networkSessionKey to %s", adapterSettings["networkSessionKey"].(string)+"\n")
-			networkSessionKey = adapterSettings["networkSessionKey"].(string)
-		} else {
-			adapterSettings["networkSessionKey"] = networkSessionKey
-		}
-
-		//networkDataKey
-		if adapterSettings["networkDataKey"] != nil {
-			log.Printf("[DEBUG] applyAdapterConfig - Setting networkDataKey to %s", adapterSettings["networkDataKey"].(string)+"\n")
-			networkDataKey = adapterSettings["networkDataKey"].(string)
-		} else {
-			adapterSettings["networkDataKey"] = networkDataKey
-		}
-
-		//transmissionDataRate
-		if adapterSettings["transmissionDataRate"] != nil {
-			log.Printf("[DEBUG] applyAdapterConfig - Setting transmissionDataRate to %s", adapterSettings["transmissionDataRate"].(string)+"\n")
-			transmissionDataRate = adapterSettings["transmissionDataRate"].(string)
-		} else {
-			adapterSettings["transmissionDataRate"] = transmissionDataRate
-		}
-
-		//transmissionFrequency
-		if adapterSettings["transmissionFrequency"] != nil {
-			log.Printf("[DEBUG] applyAdapterConfig - Setting transmissionFrequency to %s", adapterSettings["transmissionFrequency"].(string)+"\n")
-			transmissionFrequency = adapterSettings["transmissionFrequency"].(string)
-		} else {
-			adapterSettings["transmissionFrequency"] = transmissionFrequency
-		}
-	}
-
-}
-
 func setSerialPortName(adapterSettings map[string]interface{}) {
 	// 1. Determine if we are running on a Multitech Conduit
 	// 2. Determine which port (ap1 or ap2) has a product ID of MTAC-MFSER-DTE
@@ -749,9 +1109,56 @@ func setSerialPortName(adapterSettings map[string]interface{}) {
 	// 	//to use at this time
 	// 	serialPortName = ""
 	// }
+	if portName, ok := adapterSettings["serialPortName"].(string); ok && portName != "" {
+		serialPortName = portName
+		return
+	}
 	serialPortName = "/dev/ttymxc0" //TODO: make dynamic. currrently using /dev/ttymxc0 for Ario-G
 }
 
+//loadLineSettings : The character format and flow control from the "dataBits", "parity", "stopBits"
+//and "flowControl" adapter settings at serialBaudRate, default is 8N1 without flow control
+func loadLineSettings(adapterSettings map[string]interface{}) (GenericSerial.LineSettings, error) {
+	settings := GenericSerial.LineSettings{BaudRate: serialBaudRate, DataBits: 8, Parity: GenericSerial.ParityNone, StopBits: 1, FlowControl: GenericSerial.FlowNone}
+	if dataBits, ok := adapterSettings["dataBits"].(float64); ok {
+		settings.DataBits = int(dataBits)
+	}
+	if parity, ok := adapterSettings["parity"].(string); ok && parity != "" {
+		settings.Parity = strings.ToLower(parity)
+	}
+	if stopBits, ok := adapterSettings["stopBits"].(float64); ok {
+		settings.StopBits = stopBits
+	}
+	if flowControl, ok := adapterSettings["flowControl"].(string); ok && flowControl != "" {
+		settings.FlowControl = strings.ToLower(flowControl)
+	}
+	return settings, settings.Validate()
+}
+
+//loadRS485Settings : The "rs485" adapter setting, nil when the port is not an RS-485 port
+//
+//	"rs485": {"mode": "rts", "delayBeforeSend": 1, "delayAfterSend": 1, "discardEcho": true}
+func loadRS485Settings(adapterSettings map[string]interface{}) (*GenericSerial.RS485Settings, error) {
+	config, ok := adapterSettings["rs485"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	settings := GenericSerial.RS485Settings{}
+	settings.Mode, _ = config["mode"].(string)
+	settings.Mode = strings.ToLower(settings.Mode)
+	if delay, ok := config["delayBeforeSend"].(float64); ok {
+		settings.DelayBeforeSend = time.Duration(delay * float64(time.Millisecond))
+	}
+	if delay, ok := config["delayAfterSend"].(float64); ok {
+		settings.DelayAfterSend = time.Duration(delay * float64(time.Millisecond))
+	}
+	settings.GPIO, _ = config["gpio"].(string)
+	settings.ActiveLow, _ = config["activeLow"].(bool)
+	settings.DiscardEcho, _ = config["discardEcho"].(bool)
+	return &settings, settings.Validate()
+}
+
 // func getProductId(portName string) string {
 // 	port := ""
 // 	if portName != "" {
@@ -776,10 +1183,9 @@ func setSerialPortName(adapterSettings map[string]interface{}) {
 // 	}
 // }
 
-func readFromSerialPort() {
+func readFromSerialPort(ctx context.Context) {
 	// 1. Read all data from serial port
 	// 2. Publish data to platform as string
-	var data string
 
 	// for isWriting {
 	// 	log.Println("[INFO] readFromSerialPort - Currently writing to serial port. Waiting 1 second...")
@@ -788,38 +1194,87 @@ func readFromSerialPort() {
 	log.Println("[DEBUG] readFromSerialPort - About to lock serialPortLock")
 	serialPortLock.Lock()
 	// isReading = true
-	buffer, err := serialPort.ReadSerialPort()
-	for err == nil {
-		data += buffer
-		buffer, err = serialPort.ReadSerialPort()
-	}
+	data, err := readSerialData(ctx)
 	serialPortLock.Unlock()
 	log.Println("[DEBUG] readFromSerialPort - Just unlocked serialPortLock")
 	// isReading = false
 
-	if err != nil && !strings.Contains(err.Error(), "EOF") {
+	if ctx.Err() != nil {
+		log.Println("[DEBUG] readFromSerialPort - Read cancelled, discarding data read from serial port")
+	} else if err == errSerialPortReserved {
+		log.Println("[DEBUG] readFromSerialPort - " + err.Error() + ", skipping read")
+	} else if err != nil && !strings.Contains(err.Error(), "EOF") {
 		log.Printf("[ERROR] readFromSerialPort - ERROR reading from serial port: %s\n", err.Error())
 	} else {
 		log.Printf("[DEBUG] readFromSerialPort - Data read from serial port: %s\n", data)
 
-		if data != "" {
-			//If there are any slashes in the data, we need to escape them so duktape
-			//doesn't throw a SyntaxError: unterminated string (line 1) error
-			data = strings.Replace(data, `\`, `\\`, -1)
+		if data == "" {
+			log.Println("[DEBUG] readFromSerialPort - No data read from serial port, skipping publish.")
+		}
+		//An empty read still publishes a partial frame whose framing timeout has expired
+		publishSerialData(data)
+	}
+}
+
+//readSerialData : Reads until the device stops sending. Must be called while holding serialPortLock.
+func readSerialData(ctx context.Context) (string, error) {
+	if serialPortOwner != "" {
+		return "", errSerialPortReserved
+	}
+	if err := deviceProfile.PreRead(ctx, serialPort); err != nil {
+		log.Printf("[WARN] readSerialData - Device profile pre-read failed: %s\n", err.Error())
+	}
 
-			//Publish data to message broker
-			log.Println("[INFO] readFromSerialPort - Data read from serial port: " + data)
-			err := publish(topicRoot+"/"+serialRead+"/response", data)
+	var data string
+	buffer, err := serialPort.ReadSerialPort(ctx)
+	for err == nil {
+		data += buffer
+		buffer, err = serialPort.ReadSerialPort(ctx)
+	}
+	return data, err
+}
+
+//publishSerialData : Splits data read from the serial port into frames and publishes each frame,
+//decoded by the payload transform, to the receive/response topic
+func publishSerialData(data string) {
+	for _, frame := range readFraming.Split(data) {
+		if frame == "" {
+			continue
+		}
+
+		var payload string
+		if payloadTransform != nil {
+			decoded, ok := decodeFrame(frame, "")
+			if !ok {
+				continue
+			}
+			receivedFrames.Broadcast(decoded)
+			encoded, err := json.Marshal(decoded)
 			if err != nil {
-				log.Printf("[ERROR] readFromSerialPort - ERROR publishing to topic: %s\n", err.Error())
+				log.Printf("[ERROR] publishSerialData - ERROR marshalling decoded frame: %s\n", err.Error())
+				continue
 			}
+			payload = string(encoded)
 		} else {
-			log.Println("[DEBUG] readFromSerialPort - No data read from serial port, skipping publish.")
+			receivedFrames.Broadcast(frame)
+			//If there are any slashes in the data, we need to escape them so duktape
+			//doesn't throw a SyntaxError: unterminated string (line 1) error
+			payload = strings.Replace(frame, `\`, `\\`, -1)
+		}
+
+		//Publish data to message broker
+		log.Println("[INFO] publishSerialData - Data read from serial port: " + payload)
+		err := publish(topic(readResponseTopicName), payload, mqttConfig.options(readResponseTopicName))
+		if err != nil {
+			log.Printf("[ERROR] publishSerialData - ERROR publishing to topic: %s\n", err.Error())
 		}
 	}
 }
 
-func writeToSerialPort(payload string) {
+//writeToSerialPort : Writes the request and waits for its confirmation. Returns the number of
+//bytes written and true if the device confirmed the write.
+func writeToSerialPort(ctx context.Context, request *writeRequest) (int, bool, error) {
+	payload := request.payload
 	// for isReading {
 	// 	log.Println("[INFO] writeToSerialPort - Currently reading from serial port. Waiting 1 second...")
 	// 	time.Sleep(1 * time.Second)
@@ -829,11 +1284,95 @@ func writeToSerialPort(payload string) {
 	// isWriting = true
 	log.Println("[DEBUG] writeToSerialPort - About to lock serialPortLock")
 	serialPortLock.Lock()
-	err := serialPort.WriteSerialPort(string(payload))
+	if serialPortOwner != "" {
+		serialPortLock.Unlock()
+		log.Printf("[ERROR] writeToSerialPort - %s\n", errSerialPortReserved.Error())
+		return 0, false, errSerialPortReserved
+	}
+	written, err := serialPort.WriteSerialPort(ctx, string(payload))
+	confirmed := false
+	var received []string
+	if err == nil {
+		//Confirm delivery before releasing the port so the read worker cannot consume the echo or ACK
+		confirmed, received, err = waitForConfirmation(ctx, request)
+	}
+	if written > 0 {
+		if profileErr := deviceProfile.PostWrite(ctx, serialPort); profileErr != nil {
+			log.Printf("[WARN] writeToSerialPort - Device profile post-write failed: %s\n", profileErr.Error())
+		}
+	}
 	serialPortLock.Unlock()
 	log.Println("[DEBUG] writeToSerialPort - Just unlocked serialPortLock")
 	// isWriting = false
+	for _, data := range received {
+		if data != "" {
+			publishSerialData(data)
+		}
+	}
 	if err != nil {
 		log.Printf("[ERROR] writeToSerialPort - ERROR writing to serial port: %s\n", err.Error())
 	}
+	return written, confirmed, err
+}
+
+//publishWriteResponse : Reports the outcome of a write request to the sender
+func publishWriteResponse(result writeResult) {
+	payload, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("[ERROR] publishWriteResponse - ERROR marshalling write response: %s\n", err.Error())
+		return
+	}
+
+	if err := publish(topic(writeResponseTopicName), string(payload), mqttConfig.options(writeResponseTopicName)); err != nil {
+		log.Printf("[ERROR] publishWriteResponse - ERROR publishing to topic: %s\n", err.Error())
+	}
+}
+
+//publishHealth : Runs the device profile health check and publishes the result
+func publishHealth(ctx context.Context) {
+	payload, err := json.Marshal(healthStatus(ctx))
+	if err != nil {
+		log.Printf("[ERROR] publishHealth - ERROR marshalling health status: %s\n", err.Error())
+		return
+	}
+
+	if err := publish(topic(healthTopicName), string(payload), mqttConfig.options(healthTopicName)); err != nil {
+		log.Printf("[ERROR] publishHealth - ERROR publishing to topic: %s\n", err.Error())
+	}
+}
+
+//healthStatus : The status published to the health topic, including the result of the device health check
+func healthStatus(ctx context.Context) map[string]interface{} {
+	status := map[string]interface{}{
+		"deviceProfile":  deviceProfile.Name(),
+		"serialPort":     serialPortName,
+		"healthy":        true,
+		"workers":        workers.State().String(),
+		"authentication": auth.State().String(),
+		"writeQueue":     writes.Metrics(),
+		"outbox":         outbox.Metrics(),
+		"pollJobs":       scheduler.Status(),
+		"tunnel":         tunnel.Status(),
+		"timestamp":      time.Now().UTC().Format(time.RFC3339),
+	}
+	if bridge != nil {
+		status["tcpBridge"] = bridge.Status()
+	}
+
+	//The device belongs to the exclusive session that reserved the port, it is not checked
+	serialPortLock.Lock()
+	var err error
+	if serialPortOwner == "" {
+		err = deviceProfile.HealthCheck(ctx, serialPort)
+	} else {
+		status["reservedBy"] = serialPortOwner
+	}
+	serialPortLock.Unlock()
+
+	if err != nil {
+		log.Printf("[WARN] healthStatus - Device health check failed: %s\n", err.Error())
+		status["healthy"] = false
+		status["error"] = err.Error()
+	}
+	return status
 }
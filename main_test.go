@@ -0,0 +1,236 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"os"
+	"runtime/pprof"
+	"serialAdapter/DeviceProfiles"
+	"serialAdapter/PlatformClient"
+	"serialAdapter/Simulator"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	mqttTypes "github.com/clearblade/mqtt_parsing"
+	"github.com/hashicorp/logutils"
+)
+
+//testAdapter : The adapter started by startTestAdapter. It runs against a FakePlatform and
+//a simulated modem on a pseudo-terminal, the harness client publishes requests and subscribes to responses.
+type testAdapter struct {
+	platform *PlatformClient.FakePlatform
+	harness  *PlatformClient.FakeClient
+	counters map[string]*workerCounter
+}
+
+var (
+	testAdapterOnce     sync.Once
+	testAdapterInstance *testAdapter
+	testAdapterError    error
+)
+
+//startTestAdapter : Starts the adapter the first time it is called. The adapter keeps its state in
+//package variables, so every test of the package shares the same instance.
+func startTestAdapter(t *testing.T) *testAdapter {
+	t.Helper()
+
+	testAdapterOnce.Do(func() {
+		testAdapterInstance, testAdapterError = newTestAdapter()
+	})
+	if testAdapterError != nil {
+		t.Skipf("Unable to start the adapter: %s", testAdapterError.Error())
+	}
+	waitFor(t, 10*time.Second, "the workers to start", func() bool {
+		return workers.State() == workersRunning
+	})
+	return testAdapterInstance
+}
+
+func newTestAdapter() (*testAdapter, error) {
+	if !testing.Verbose() {
+		log.SetOutput(&logutils.LevelFilter{
+			Levels:   []logutils.LogLevel{"DEBUG", "INFO", "WARN", "ERROR", "FATAL"},
+			MinLevel: logutils.LogLevel("ERROR"),
+			Writer:   os.Stderr,
+		})
+	}
+
+	pty, err := Simulator.OpenPty()
+	if err != nil {
+		return nil, err
+	}
+	device, err := Simulator.CreateDevice(Simulator.ModemDeviceName, map[string]interface{}{"echo": false})
+	if err != nil {
+		pty.Close()
+		return nil, err
+	}
+	go device.Run(context.Background(), pty.Master)
+
+	platform := PlatformClient.NewFakePlatform()
+	err = platform.AddAdapterConfig(adapterName, "test", map[string]interface{}{
+		"deviceProfile":  DeviceProfiles.RawProfileName,
+		"serialPortName": pty.SlavePath,
+	})
+	if err != nil {
+		return nil, err
+	}
+	newPlatformClient = func(platformBroker cbPlatformBroker) (PlatformClient.Client, error) {
+		return platform.NewClient(), nil
+	}
+
+	sysKey, sysSec, deviceName, activeKey = "systemKey", "systemSecret", "testAdapter", "password"
+	serialPortName = pty.SlavePath
+	readInterval = 1
+	cbBroker = cbPlatformBroker{
+		name:         "fake platform",
+		clientID:     deviceName + "client",
+		platformURL:  &platformURL,
+		messagingURL: &messagingURL,
+		systemKey:    &sysKey,
+		systemSecret: &sysSec,
+		username:     &deviceName,
+		password:     &activeKey,
+	}
+
+	adapter := &testAdapter{platform: platform, counters: map[string]*workerCounter{}}
+	workers = newWorkerSupervisor(
+		adapter.count(supervisedWorker{name: "subscribeWorker", run: subscribeWorker}),
+		adapter.count(supervisedWorker{name: "readWorker", run: readWorker}),
+		adapter.count(supervisedWorker{name: "writeWorker", run: writeWorker}),
+		adapter.count(supervisedWorker{name: "controlWorker", run: controlWorker}),
+	)
+	schedulerWorkers = newWorkerSupervisor(
+		supervisedWorker{name: "pollScheduler", run: func(ctx context.Context) { scheduler.Run(ctx) }},
+	)
+
+	if err := initCbClient(cbBroker); err != nil {
+		return nil, err
+	}
+
+	adapter.harness = platform.NewClient()
+	if err := adapter.harness.Authenticate(); err != nil {
+		return nil, err
+	}
+	if err := adapter.harness.ConnectMQTT("harness", nil, true, PlatformClient.Callbacks{}); err != nil {
+		return nil, err
+	}
+	return adapter, nil
+}
+
+//count : Wraps worker so the number of its instances running at once is recorded
+func (adapter *testAdapter) count(worker supervisedWorker) supervisedWorker {
+	counter := &workerCounter{}
+	adapter.counters[worker.name] = counter
+	return counter.wrap(worker)
+}
+
+//workerCounter : Records how many instances of a worker run at once
+type workerCounter struct {
+	running int32
+	peak    int32
+}
+
+func (counter *workerCounter) wrap(worker supervisedWorker) supervisedWorker {
+	return supervisedWorker{name: worker.name, run: func(ctx context.Context) {
+		running := atomic.AddInt32(&counter.running, 1)
+		defer atomic.AddInt32(&counter.running, -1)
+
+		for {
+			peak := atomic.LoadInt32(&counter.peak)
+			if running <= peak || atomic.CompareAndSwapInt32(&counter.peak, peak, running) {
+				break
+			}
+		}
+		worker.run(ctx)
+	}}
+}
+
+//Peak : The largest number of instances that ran at once
+func (counter *workerCounter) Peak() int32 {
+	return atomic.LoadInt32(&counter.peak)
+}
+
+//waitFor : Fails the test if condition is not true within timeout
+func waitFor(t *testing.T, timeout time.Duration, description string, condition func() bool) {
+	t.Helper()
+
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatalf("Timed out waiting for %s", description)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+//waitForGroup : Fails the test, printing every go routine, if group does not finish within timeout
+func waitForGroup(t *testing.T, timeout time.Duration, group *sync.WaitGroup) {
+	t.Helper()
+
+	done := make(chan struct{})
+	go func() {
+		group.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(timeout):
+		pprof.Lookup("goroutine").WriteTo(os.Stderr, 2)
+		t.Fatalf("Deadlock: the go routines did not finish within %s", timeout)
+	}
+}
+
+//receive : Waits for a message on subscription that satisfies match
+func receive(t *testing.T, subscription <-chan *mqttTypes.Publish, description string, match func(payload []byte) bool) []byte {
+	t.Helper()
+
+	timer := time.NewTimer(10 * time.Second)
+	defer timer.Stop()
+	for {
+		select {
+		case message := <-subscription:
+			if match(message.Payload) {
+				return message.Payload
+			}
+		case <-timer.C:
+			t.Fatalf("Timed out waiting for %s", description)
+			return nil
+		}
+	}
+}
+
+func TestSendRequestReceiveResponse(t *testing.T) {
+	adapter := startTestAdapter(t)
+
+	writeResponses, err := adapter.harness.Subscribe(topic(writeResponseTopicName), 0)
+	if err != nil {
+		t.Fatalf("Unable to subscribe to send/response: %s", err.Error())
+	}
+	readResponses, err := adapter.harness.Subscribe(topic(readResponseTopicName), 0)
+	if err != nil {
+		t.Fatalf("Unable to subscribe to receive/response: %s", err.Error())
+	}
+
+	if err := adapter.harness.Publish(topic(writeRequestTopicName), []byte("AT\r"), 0, false); err != nil {
+		t.Fatalf("Unable to publish to send/request: %s", err.Error())
+	}
+
+	payload := receive(t, writeResponses, "the send/response", func(payload []byte) bool { return true })
+	result := writeResult{}
+	if err := json.Unmarshal(payload, &result); err != nil {
+		t.Fatalf("Invalid send/response %q: %s", payload, err.Error())
+	}
+	if !result.Success || result.BytesWritten != 3 {
+		t.Errorf("send/response is %s, expected 3 bytes written", payload)
+	}
+
+	//The read worker publishes the reply of the modem
+	receive(t, readResponses, "the OK reply on receive/response", func(payload []byte) bool {
+		return strings.Contains(string(payload), "OK")
+	})
+}
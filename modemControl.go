@@ -0,0 +1,280 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"serialAdapter/GenericSerial"
+	"strings"
+	"time"
+)
+
+//Actions of the control/request topic
+const (
+	controlSet   = "set"
+	controlPulse = "pulse"
+	controlBreak = "break"
+	controlRead  = "read"
+)
+
+const (
+	//defaultControlPulse : Milliseconds a line is cleared by a pulse request without a duration
+	defaultControlPulse = 100
+
+	//maxControlDuration : Longest pulse or break in milliseconds
+	maxControlDuration = 10000
+
+	//controlQueueSize : Control requests waiting for the controlWorker. Further requests are rejected.
+	controlQueueSize = 10
+)
+
+var (
+	errControlQueueFull = errors.New("Control queue is full")
+	errControlDiscarded = errors.New("The adapter stopped before the control request was applied")
+)
+
+//controlRequest : A message of the control/request topic
+//
+//	{"requestId": "1", "action": "set", "line": "dtr", "state": false}
+//	{"requestId": "2", "action": "pulse", "line": "rts", "duration": 100}
+//	{"requestId": "3", "action": "break", "duration": 250}
+//	{"requestId": "4", "action": "read"}
+type controlRequest struct {
+	RequestID string `json:"requestId"`
+	Action    string `json:"action"`
+	Line      string `json:"line"`
+	State     *bool  `json:"state"`
+	Duration  int    `json:"duration"`
+}
+
+//controlResult : Outcome of a control request published to control/response
+type controlResult struct {
+	RequestID string                    `json:"requestId,omitempty"`
+	Action    string                    `json:"action"`
+	Success   bool                      `json:"success"`
+	Error     string                    `json:"error,omitempty"`
+	Lines     *GenericSerial.ModemLines `json:"lines,omitempty"`
+	Timestamp string                    `json:"timestamp"`
+}
+
+//controlEvent : Published to control/event when an input line changes state
+type controlEvent struct {
+	Line      string                   `json:"line"`
+	State     bool                     `json:"state"`
+	Lines     GenericSerial.ModemLines `json:"lines"`
+	Timestamp string                   `json:"timestamp"`
+}
+
+//Control requests waiting for the controlWorker, run one at a time so pulses and breaks do not overlap
+var controlRequests = make(chan controlRequest, controlQueueSize)
+
+//queueControlRequest : Queues a control/request message for the controlWorker. Invalid requests
+//and requests received while the queue is full are answered with an error.
+func queueControlRequest(payload []byte) {
+	request := controlRequest{}
+	if err := json.Unmarshal(payload, &request); err != nil {
+		log.Printf("[WARN] queueControlRequest - Invalid control request: %s\n", err.Error())
+		publishControlResponse(controlResult{Error: "Invalid control request: " + err.Error()})
+		return
+	}
+	request.Action = strings.ToLower(request.Action)
+	request.Line = strings.ToLower(request.Line)
+
+	select {
+	case controlRequests <- request:
+	default:
+		log.Printf("[WARN] queueControlRequest - Rejecting control request %s: %s\n", request.RequestID, errControlQueueFull.Error())
+		publishControlResponse(controlResult{RequestID: request.RequestID, Action: request.Action, Error: errControlQueueFull.Error()})
+	}
+}
+
+//controlWorker : Applies the queued control requests. Requests still queued when the worker stops
+//are answered with an error, a pulse or break applied after a reconnect would reset or wake the
+//device long after it was requested.
+func controlWorker(ctx context.Context) {
+	log.Println("[INFO] controlWorker - Starting controlWorker")
+
+	//The subscribeWorker may have queued a request after the previous controlWorker stopped
+	failQueuedControlRequests()
+
+	for {
+		select {
+		case request := <-controlRequests:
+			handleControlRequest(request)
+		case <-ctx.Done():
+			log.Println("[INFO] controlWorker - Stopping controlWorker")
+			failQueuedControlRequests()
+			return
+		}
+	}
+}
+
+//failQueuedControlRequests : Publishes a failure result for each queued control request
+func failQueuedControlRequests() {
+	for {
+		select {
+		case request := <-controlRequests:
+			log.Printf("[WARN] failQueuedControlRequests - Discarding control request %s\n", request.RequestID)
+			publishControlResponse(controlResult{RequestID: request.RequestID, Action: request.Action, Error: errControlDiscarded.Error()})
+		default:
+			return
+		}
+	}
+}
+
+//handleControlRequest : Applies a control request and publishes the result
+func handleControlRequest(request controlRequest) {
+	result := controlResult{RequestID: request.RequestID, Action: request.Action}
+	err := applyControlRequest(request)
+
+	lines, linesErr := serialPort.ReadModemLines()
+	if linesErr == nil {
+		result.Lines = &lines
+	} else if request.Action == controlRead && err == nil {
+		err = linesErr
+	}
+
+	if err != nil {
+		log.Printf("[ERROR] handleControlRequest - Control request %s failed: %s\n", request.RequestID, err.Error())
+		result.Error = err.Error()
+	} else {
+		result.Success = true
+	}
+	publishControlResponse(result)
+}
+
+//applyControlRequest : Output lines and breaks are changed while holding serialPortLock so an
+//exclusive session cannot reserve the port meanwhile. Reads and writes wait for pulses and breaks.
+func applyControlRequest(request controlRequest) error {
+	if request.Duration < 0 || request.Duration > maxControlDuration {
+		return fmt.Errorf("duration must be between 0 and %d milliseconds", maxControlDuration)
+	}
+	duration := time.Duration(request.Duration) * time.Millisecond
+
+	if request.Action == controlRead {
+		return nil
+	}
+
+	serialPortLock.Lock()
+	defer serialPortLock.Unlock()
+	if serialPortOwner != "" {
+		return errSerialPortReserved
+	}
+
+	switch request.Action {
+	case controlSet:
+		if request.State == nil {
+			return errors.New("state is required")
+		}
+		switch request.Line {
+		case "dtr":
+			return serialPort.SetDTR(*request.State)
+		case "rts":
+			return serialPort.SetRTS(*request.State)
+		}
+		return errors.New("Invalid line " + request.Line + ", expected dtr or rts")
+	case controlPulse:
+		if duration == 0 {
+			duration = defaultControlPulse * time.Millisecond
+		}
+		switch request.Line {
+		case "dtr":
+			return serialPort.ToggleDTR(duration)
+		case "rts":
+			return serialPort.ToggleRTS(duration)
+		}
+		return errors.New("Invalid line " + request.Line + ", expected dtr or rts")
+	case controlBreak:
+		if duration == 0 {
+			return serialPort.SendBreak()
+		}
+		return serialPort.SendBreakFor(duration)
+	}
+	return errors.New("Unknown action " + request.Action + ", expected set, pulse, break or read")
+}
+
+//publishControlResponse : Publishes the result of a control request to control/response
+func publishControlResponse(result controlResult) {
+	result.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	payload, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("[ERROR] publishControlResponse - ERROR marshalling control result: %s\n", err.Error())
+		return
+	}
+
+	if err := publish(topic(controlResponseTopicName), string(payload), mqttConfig.options(controlResponseTopicName)); err != nil {
+		log.Printf("[ERROR] publishControlResponse - ERROR publishing to topic: %s\n", err.Error())
+	}
+}
+
+//loadLineMonitorInterval : The "modemLines" adapter setting, 0 when the input lines are not monitored
+//
+//	"modemLines": {"pollInterval": 100}
+func loadLineMonitorInterval(adapterSettings map[string]interface{}) (time.Duration, error) {
+	config, ok := adapterSettings["modemLines"].(map[string]interface{})
+	if !ok {
+		return 0, nil
+	}
+
+	interval, _ := config["pollInterval"].(float64)
+	if interval < 0 {
+		return 0, errors.New("pollInterval must not be negative")
+	}
+	return time.Duration(interval) * time.Millisecond, nil
+}
+
+//monitorModemLines : Reads the input lines every interval and publishes an event to control/event
+//for each line that changed. Events are queued in the outbox while the broker is unreachable.
+func monitorModemLines(ctx context.Context, interval time.Duration) {
+	log.Printf("[INFO] monitorModemLines - Monitoring the modem input lines every %s\n", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var previous map[string]bool
+	failing := false
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			log.Println("[INFO] monitorModemLines - Stopping the modem line monitor")
+			return
+		}
+
+		lines, err := serialPort.ReadModemLines()
+		if err != nil {
+			//Logged once, the port may be reopened with new settings
+			if !failing {
+				log.Printf("[WARN] monitorModemLines - Unable to read the modem lines: %s\n", err.Error())
+			}
+			failing = true
+			continue
+		}
+		failing = false
+
+		inputs := lines.Inputs()
+		if previous != nil {
+			for _, line := range []string{"cts", "dsr", "dcd", "ri"} {
+				if inputs[line] != previous[line] {
+					publishControlEvent(controlEvent{Line: line, State: inputs[line], Lines: lines})
+				}
+			}
+		}
+		previous = inputs
+	}
+}
+
+//publishControlEvent : Publishes an input line change to control/event
+func publishControlEvent(event controlEvent) {
+	log.Printf("[INFO] publishControlEvent - %s is now %t\n", strings.ToUpper(event.Line), event.State)
+
+	event.Timestamp = time.Now().UTC().Format(time.RFC3339Nano)
+	payload, err := json.Marshal(event)
+	if err != nil {
+		log.Printf("[ERROR] publishControlEvent - ERROR marshalling control event: %s\n", err.Error())
+		return
+	}
+	outbox.Publish(topic(controlEventTopicName), string(payload), mqttConfig.options(controlEventTopicName))
+}
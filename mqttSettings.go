@@ -0,0 +1,141 @@
+package main
+
+import (
+	"log"
+	"time"
+)
+
+//Names of the topics that can be configured in the "mqtt" adapter setting
+const (
+	readRequestTopicName     = serialRead + "/request"
+	writeRequestTopicName    = serialWrite + "/request"
+	readResponseTopicName    = serialRead + "/response"
+	writeResponseTopicName   = serialWrite + "/response"
+	readErrorTopicName       = serialRead + "/error"
+	healthTopicName          = "health"
+	tunnelTxTopicName        = "tunnel/tx"
+	tunnelRxTopicName        = "tunnel/rx"
+	tunnelSessionTopicName   = "tunnel/session"
+	tunnelStatusTopicName    = "tunnel/status"
+	controlRequestTopicName  = "control/request"
+	controlResponseTopicName = "control/response"
+	controlEventTopicName    = "control/event"
+	defaultDuplicateWindow   = 60 //seconds
+)
+
+//topicOptions : Delivery options of a single topic
+type topicOptions struct {
+	qos    int
+	retain bool
+}
+
+//mqttSettings : The "mqtt" adapter setting
+//
+//	{
+//	  "cleanSession": false,
+//	  "duplicateWindow": 60,
+//	  "topics": {
+//	    "send/request": {"qos": 2},
+//	    "receive/response": {"qos": 1, "retain": false},
+//	    "health": {"qos": 0, "retain": true}
+//	  }
+//	}
+type mqttSettings struct {
+	cleanSession    bool //Only honored by the generic broker client, the ClearBlade SDK always starts a clean session
+	duplicateWindow time.Duration
+	topics          map[string]topicOptions
+}
+
+var mqttConfig = defaultMqttSettings()
+
+func defaultMqttSettings() mqttSettings {
+	return mqttSettings{
+		cleanSession:    true,
+		duplicateWindow: defaultDuplicateWindow * time.Second,
+		topics: map[string]topicOptions{
+			readRequestTopicName:     {qos: msgSubscribeQos},
+			writeRequestTopicName:    {qos: msgSubscribeQos},
+			readResponseTopicName:    {qos: msgPublishQos},
+			writeResponseTopicName:   {qos: msgPublishQos},
+			readErrorTopicName:       {qos: msgPublishQos},
+			healthTopicName:          {qos: msgPublishQos},
+			tunnelTxTopicName:        {qos: msgSubscribeQos},
+			tunnelRxTopicName:        {qos: msgPublishQos},
+			tunnelSessionTopicName:   {qos: msgSubscribeQos},
+			tunnelStatusTopicName:    {qos: msgPublishQos},
+			controlRequestTopicName:  {qos: msgSubscribeQos},
+			controlResponseTopicName: {qos: msgPublishQos},
+			controlEventTopicName:    {qos: msgPublishQos},
+		},
+	}
+}
+
+//loadMqttSettings : Applies the "mqtt" adapter setting over the defaults. Invalid values are logged and ignored.
+func loadMqttSettings(adapterSettings map[string]interface{}) mqttSettings {
+	settings := defaultMqttSettings()
+
+	mqttJSON, ok := adapterSettings["mqtt"].(map[string]interface{})
+	if !ok {
+		log.Println("[DEBUG] loadMqttSettings - No mqtt settings, using defaults")
+		return settings
+	}
+
+	if cleanSession, ok := mqttJSON["cleanSession"].(bool); ok {
+		settings.cleanSession = cleanSession
+	}
+
+	if window, ok := mqttJSON["duplicateWindow"].(float64); ok && window >= 0 {
+		settings.duplicateWindow = time.Duration(window) * time.Second
+	}
+
+	if topicsJSON, ok := mqttJSON["topics"].(map[string]interface{}); ok {
+		for name, value := range topicsJSON {
+			options, exists := settings.topics[name]
+			if !exists {
+				log.Printf("[WARN] loadMqttSettings - Unknown topic %s in mqtt settings, ignoring\n", name)
+				continue
+			}
+
+			optionsJSON, ok := value.(map[string]interface{})
+			if !ok {
+				log.Printf("[WARN] loadMqttSettings - Settings for topic %s must be an object, ignoring\n", name)
+				continue
+			}
+
+			if qos, ok := optionsJSON["qos"].(float64); ok {
+				if qos < 0 || qos > 2 || qos != float64(int(qos)) {
+					log.Printf("[WARN] loadMqttSettings - Invalid qos %v for topic %s, using %d\n", qos, name, options.qos)
+				} else {
+					options.qos = int(qos)
+				}
+			}
+			if retain, ok := optionsJSON["retain"].(bool); ok {
+				if name == readRequestTopicName || name == writeRequestTopicName || name == tunnelTxTopicName || name == tunnelSessionTopicName || name == controlRequestTopicName {
+					log.Printf("[WARN] loadMqttSettings - retain does not apply to subscription topic %s\n", name)
+				} else {
+					options.retain = retain
+				}
+			}
+			settings.topics[name] = options
+		}
+	}
+
+	for name, options := range settings.topics {
+		log.Printf("[INFO] loadMqttSettings - Topic %s: qos = %d, retain = %t\n", name, options.qos, options.retain)
+	}
+
+	if !settings.cleanSession {
+		//The client ID must be stable for the broker to associate the connection with the previous session
+		log.Println("[INFO] loadMqttSettings - Persistent session requested, using a stable client ID")
+		if !isStandalone() {
+			log.Println("[WARN] loadMqttSettings - The ClearBlade SDK connection always requests a clean session, subscriptions are re-established on connect")
+		}
+	}
+
+	return settings
+}
+
+//options : The delivery options of the named topic
+func (settings mqttSettings) options(name string) topicOptions {
+	return settings.topics[name]
+}
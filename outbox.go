@@ -0,0 +1,217 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+)
+
+const defaultOutboxSize = 1000
+
+//outboxMessage : A message waiting to be published
+type outboxMessage struct {
+	topic   string
+	data    string
+	options topicOptions
+}
+
+//storedOutboxMessage : An outboxMessage in the outbox file
+type storedOutboxMessage struct {
+	Topic  string `json:"topic"`
+	Data   string `json:"data"`
+	Qos    int    `json:"qos"`
+	Retain bool   `json:"retain"`
+}
+
+//outboxMetrics : Outbox statistics published with the health status
+type outboxMetrics struct {
+	Depth     int   `json:"depth"`
+	Capacity  int   `json:"capacity"`
+	Published int64 `json:"published"`
+	Stored    int64 `json:"stored"`
+	Dropped   int64 `json:"dropped"`
+}
+
+//messageOutbox : Store-and-forward path for data produced while the broker is unreachable.
+//Messages are held in the order they were produced and published once the connection is
+//re-established. When the outbox is full the oldest message is dropped. With a file, the waiting
+//messages are written to it whenever they change and restored when the adapter starts.
+type messageOutbox struct {
+	lock     sync.Mutex
+	messages []outboxMessage
+	metrics  outboxMetrics
+	flushing bool
+	fileName string
+}
+
+func newMessageOutbox(capacity int) *messageOutbox {
+	if capacity <= 0 {
+		capacity = defaultOutboxSize
+	}
+	return &messageOutbox{metrics: outboxMetrics{Capacity: capacity}}
+}
+
+//loadOutboxSettings : Creates the outbox from the "outbox" adapter setting
+//
+// "outbox": {"size": 1000}
+func loadOutboxSettings(adapterSettings map[string]interface{}) *messageOutbox {
+	size := defaultOutboxSize
+	if outboxJSON, ok := adapterSettings["outbox"].(map[string]interface{}); ok {
+		if value, ok := outboxJSON["size"].(float64); ok && value > 0 {
+			size = int(value)
+		}
+	}
+
+	log.Printf("[INFO] loadOutboxSettings - Outbox size = %d\n", size)
+	outbox := newMessageOutbox(size)
+	if outboxFile != "" {
+		outbox.restore(outboxFile)
+	}
+	return outbox
+}
+
+//restore : Loads the messages left in fileName by the previous run and keeps the outbox in it from now on
+func (outbox *messageOutbox) restore(fileName string) {
+	outbox.lock.Lock()
+	defer outbox.lock.Unlock()
+
+	outbox.fileName = fileName
+	contents, err := ioutil.ReadFile(fileName)
+	if os.IsNotExist(err) {
+		return
+	}
+	if err != nil {
+		log.Printf("[WARN] messageOutbox - Unable to read %s, stored messages are lost: %s\n", fileName, err.Error())
+		return
+	}
+
+	stored := []storedOutboxMessage{}
+	if err := json.Unmarshal(contents, &stored); err != nil {
+		log.Printf("[WARN] messageOutbox - %s is not valid, stored messages are lost: %s\n", fileName, err.Error())
+		return
+	}
+	for _, message := range stored {
+		outbox.messages = append(outbox.messages, outboxMessage{topic: message.Topic, data: message.Data, options: topicOptions{qos: message.Qos, retain: message.Retain}})
+	}
+	if dropped := len(outbox.messages) - outbox.metrics.Capacity; dropped > 0 {
+		log.Printf("[WARN] messageOutbox - Dropping the %d oldest stored messages, the outbox holds %d\n", dropped, outbox.metrics.Capacity)
+		outbox.messages = outbox.messages[dropped:]
+		outbox.metrics.Dropped += int64(dropped)
+	}
+	log.Printf("[INFO] messageOutbox - Restored %d stored messages from %s\n", len(outbox.messages), fileName)
+}
+
+//persist : Writes the waiting messages to the outbox file. Must be called while holding the lock.
+func (outbox *messageOutbox) persist() {
+	if outbox.fileName == "" {
+		return
+	}
+
+	stored := make([]storedOutboxMessage, 0, len(outbox.messages))
+	for _, message := range outbox.messages {
+		stored = append(stored, storedOutboxMessage{Topic: message.topic, Data: message.data, Qos: message.options.qos, Retain: message.options.retain})
+	}
+	contents, err := json.Marshal(stored)
+	if err == nil {
+		err = writeStateFile(outbox.fileName, contents)
+	}
+	if err != nil {
+		log.Printf("[WARN] messageOutbox - Unable to write %s: %s\n", outbox.fileName, err.Error())
+	}
+}
+
+//Publish : Publishes the message if connected and nothing is waiting ahead of it, otherwise stores it
+func (outbox *messageOutbox) Publish(topic string, data string, options topicOptions) {
+	outbox.lock.Lock()
+	pending := len(outbox.messages) > 0 || outbox.flushing
+	outbox.lock.Unlock()
+
+	if !pending && isConnected() {
+		if err := publish(topic, data, options); err == nil {
+			outbox.lock.Lock()
+			outbox.metrics.Published++
+			outbox.lock.Unlock()
+			return
+		}
+	}
+
+	outbox.store(outboxMessage{topic: topic, data: data, options: options})
+}
+
+func (outbox *messageOutbox) store(message outboxMessage) {
+	outbox.lock.Lock()
+	defer outbox.lock.Unlock()
+
+	if len(outbox.messages) >= outbox.metrics.Capacity {
+		log.Printf("[WARN] messageOutbox - Outbox full, dropping oldest message for %s\n", outbox.messages[0].topic)
+		outbox.messages = outbox.messages[1:]
+		outbox.metrics.Dropped++
+	}
+	outbox.messages = append(outbox.messages, message)
+	outbox.metrics.Stored++
+	outbox.persist()
+	log.Printf("[DEBUG] messageOutbox - Stored message for %s, %d waiting\n", message.topic, len(outbox.messages))
+}
+
+//Flush : Publishes the stored messages in order. Stops at the first failure, leaving the
+//remaining messages for the next flush. Only one flush runs at a time.
+func (outbox *messageOutbox) Flush(ctx context.Context) {
+	outbox.lock.Lock()
+	if outbox.flushing {
+		outbox.lock.Unlock()
+		return
+	}
+	outbox.flushing = true
+	outbox.lock.Unlock()
+
+	defer func() {
+		outbox.lock.Lock()
+		outbox.flushing = false
+		outbox.lock.Unlock()
+	}()
+
+	flushed := 0
+	for ctx.Err() == nil && isConnected() {
+		outbox.lock.Lock()
+		if len(outbox.messages) == 0 {
+			outbox.lock.Unlock()
+			break
+		}
+		message := outbox.messages[0]
+		outbox.lock.Unlock()
+
+		if err := publish(message.topic, message.data, message.options); err != nil {
+			log.Printf("[WARN] messageOutbox.Flush - Stopping flush: %s\n", err.Error())
+			break
+		}
+
+		outbox.lock.Lock()
+		//The message may have been dropped to make room while it was being published
+		if len(outbox.messages) > 0 && outbox.messages[0] == message {
+			outbox.messages = outbox.messages[1:]
+		}
+		outbox.metrics.Published++
+		outbox.lock.Unlock()
+		flushed++
+	}
+
+	if flushed > 0 {
+		outbox.lock.Lock()
+		outbox.persist()
+		outbox.lock.Unlock()
+		log.Printf("[INFO] messageOutbox.Flush - Published %d stored messages\n", flushed)
+	}
+}
+
+//Metrics : A snapshot of the outbox statistics
+func (outbox *messageOutbox) Metrics() outboxMetrics {
+	outbox.lock.Lock()
+	defer outbox.lock.Unlock()
+
+	metrics := outbox.metrics
+	metrics.Depth = len(outbox.messages)
+	return metrics
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestOutboxRestoresStoredMessages(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "outbox.json")
+
+	previous := newMessageOutbox(10)
+	previous.restore(fileName)
+	messages := []outboxMessage{
+		{topic: "serial/poll/a", data: "1", options: topicOptions{qos: 1}},
+		{topic: "serial/poll/b", data: "2", options: topicOptions{retain: true}},
+		{topic: "serial/poll/a", data: "3"},
+	}
+	for _, message := range messages {
+		previous.store(message)
+	}
+
+	restored := newMessageOutbox(10)
+	restored.restore(fileName)
+	if !reflect.DeepEqual(restored.messages, messages) {
+		t.Errorf("Restored %+v, expected %+v", restored.messages, messages)
+	}
+
+	//A smaller outbox keeps the newest messages
+	smaller := newMessageOutbox(2)
+	smaller.restore(fileName)
+	if !reflect.DeepEqual(smaller.messages, messages[1:]) {
+		t.Errorf("Restored %+v into an outbox of 2, expected %+v", smaller.messages, messages[1:])
+	}
+	if metrics := smaller.Metrics(); metrics.Dropped != 1 || metrics.Depth != 2 {
+		t.Errorf("Metrics are %+v, expected 1 dropped and a depth of 2", metrics)
+	}
+}
+
+func TestOutboxWithoutFile(t *testing.T) {
+	outbox := newMessageOutbox(1)
+	outbox.restore(filepath.Join(t.TempDir(), "missing.json"))
+	if metrics := outbox.Metrics(); metrics.Depth != 0 {
+		t.Errorf("Restored %d messages from a missing file, expected none", metrics.Depth)
+	}
+}
@@ -0,0 +1,462 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"mime"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	//apiHost : The REST API is only reachable by applications running on the gateway
+	apiHost = "127.0.0.1"
+
+	//apiWriteTimeout : Seconds a write request waits for the write worker before the caller is answered
+	apiWriteTimeout = 30
+
+	//apiMaxBodySize : Largest request body accepted, in bytes
+	apiMaxBodySize = 1024 * 1024
+
+	//apiKeepAliveInterval : Seconds between comments sent on an idle frame stream
+	apiKeepAliveInterval = 30
+
+	//frameSubscriberSize : Frames buffered per stream before further frames are dropped
+	frameSubscriberSize = 100
+)
+
+var (
+	//Serves the REST API, nil when apiPort is 0
+	apiServer *http.Server
+
+	//Frames read by the read worker, delivered to the frame streams of the REST API
+	receivedFrames = newFrameBroadcaster()
+)
+
+//streamedFrame : An event of the frame stream
+type streamedFrame struct {
+	Data      interface{} `json:"data"`
+	Timestamp string      `json:"timestamp"`
+}
+
+//frameBroadcaster : Delivers each frame to every subscriber without blocking the read worker
+type frameBroadcaster struct {
+	lock        sync.Mutex
+	subscribers map[chan streamedFrame]bool
+}
+
+func newFrameBroadcaster() *frameBroadcaster {
+	return &frameBroadcaster{subscribers: map[chan streamedFrame]bool{}}
+}
+
+//Subscribe : Returns the channel the frames are delivered to and the function that ends the subscription
+func (broadcaster *frameBroadcaster) Subscribe() (<-chan streamedFrame, func()) {
+	frames := make(chan streamedFrame, frameSubscriberSize)
+
+	broadcaster.lock.Lock()
+	broadcaster.subscribers[frames] = true
+	broadcaster.lock.Unlock()
+
+	return frames, func() {
+		broadcaster.lock.Lock()
+		delete(broadcaster.subscribers, frames)
+		broadcaster.lock.Unlock()
+	}
+}
+
+//Broadcast : Delivers a frame, or the object decoded from it, to every subscriber
+func (broadcaster *frameBroadcaster) Broadcast(data interface{}) {
+	broadcaster.lock.Lock()
+	defer broadcaster.lock.Unlock()
+
+	frame := streamedFrame{Data: data, Timestamp: time.Now().UTC().Format(time.RFC3339)}
+	for subscriber := range broadcaster.subscribers {
+		select {
+		case subscriber <- frame:
+		default:
+			log.Println("[WARN] frameBroadcaster - Frame stream is not keeping up, dropping frame")
+		}
+	}
+}
+
+//startRESTAPI : Serves the REST API on localhost:port
+//
+//	POST /ports/{name}/write      - body as a send/request payload, answers with the write result
+//	POST /ports/{name}/transact   - {"data": "READ\r\n", "timeout": 2000, "terminator": "\r\n"}, answers with the reply
+//	GET  /ports/{name}/read       - reads until the device stops sending
+//	GET  /ports/{name}/stream     - server-sent events of the frames read by the read worker
+//	GET  /status                  - the health status
+//
+// {name} is the serial port name with or without its directory (ex. ttyUSB0 or /dev/ttyUSB0).
+// Requests must be addressed to localhost or 127.0.0.1 and POST bodies must be application/json.
+func startRESTAPI(port int) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", localOnly(handleStatus))
+	mux.HandleFunc("/ports/", localOnly(handlePort))
+
+	apiServer = &http.Server{
+		Addr:              apiHost + ":" + strconv.Itoa(port),
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	go func() {
+		log.Printf("[INFO] startRESTAPI - Serving the REST API on http://%s\n", apiServer.Addr)
+		if err := apiServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Printf("[ERROR] startRESTAPI - REST API stopped: %s\n", err.Error())
+		}
+	}()
+}
+
+//stopRESTAPI : Stops accepting requests and waits up to timeout for the active requests
+func stopRESTAPI(timeout time.Duration) {
+	if apiServer == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := apiServer.Shutdown(ctx); err != nil {
+		log.Printf("[WARN] stopRESTAPI - %s\n", err.Error())
+	}
+}
+
+func handleStatus(w http.ResponseWriter, r *http.Request) {
+	if !allowMethod(w, r, http.MethodGet) {
+		return
+	}
+	writeJSON(w, http.StatusOK, healthStatus(r.Context()))
+}
+
+//handlePort : Routes /ports/{name}/{operation}
+func handlePort(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/ports/")
+	index := strings.LastIndex(path, "/")
+	if index <= 0 {
+		writeError(w, http.StatusNotFound, "Expected /ports/{name}/{operation}")
+		return
+	}
+
+	name, operation := path[:index], path[index+1:]
+	if !isSerialPortName(name) {
+		writeError(w, http.StatusNotFound, "Unknown serial port "+name)
+		return
+	}
+
+	switch operation {
+	case "write":
+		if allowMethod(w, r, http.MethodPost) && requireJSON(w, r) {
+			handleWrite(w, r)
+		}
+	case "transact":
+		if allowMethod(w, r, http.MethodPost) && requireJSON(w, r) {
+			handleTransact(w, r)
+		}
+	case "read":
+		if allowMethod(w, r, http.MethodGet) {
+			handleRead(w, r)
+		}
+	case "stream":
+		if allowMethod(w, r, http.MethodGet) {
+			handleStream(w, r)
+		}
+	default:
+		writeError(w, http.StatusNotFound, "Unknown operation "+operation)
+	}
+}
+
+//handleWrite : Queues the write with the send/request writes and waits for its result. The write
+//worker only runs while connected to the broker, otherwise the write is made directly.
+func handleWrite(w http.ResponseWriter, r *http.Request) {
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	request, err := parseWriteRequest(body, defaultWriteAck, writes.envelope)
+	if err == nil {
+		err = encodeWriteCommand(request)
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if workers.State() != workersRunning {
+		writeDirectly(w, r, request)
+		return
+	}
+
+	request.done = make(chan writeResult, 1)
+	if err := writes.Push(request); err != nil {
+		writeError(w, http.StatusServiceUnavailable, err.Error())
+		return
+	}
+
+	timer := time.NewTimer(apiWriteTimeout * time.Second)
+	defer timer.Stop()
+
+	select {
+	case result := <-request.done:
+		writeWriteResult(w, result)
+	case <-timer.C:
+		//The connection to the broker was lost after the write was queued
+		writeError(w, http.StatusGatewayTimeout, "Timed out waiting for the write, the request is still queued")
+	case <-r.Context().Done():
+	case <-adapterContext.Done():
+		writeError(w, http.StatusServiceUnavailable, "The adapter is shutting down")
+	}
+}
+
+//writeDirectly : Writes the request while holding the serial port, like a transact request
+func writeDirectly(w http.ResponseWriter, r *http.Request, request *writeRequest) {
+	if err := initDeviceProfile(r.Context()); err != nil {
+		writeError(w, http.StatusServiceUnavailable, "Error initializing device profile: "+err.Error())
+		return
+	}
+
+	started := time.Now()
+	written, confirmed, err := writeToSerialPort(r.Context(), request)
+	writes.Completed(err)
+	if err == errSerialPortReserved {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	writeWriteResult(w, newWriteResult(request, written, confirmed, started, err))
+}
+
+//writeWriteResult : Answers with the write result, 502 if the write failed
+func writeWriteResult(w http.ResponseWriter, result writeResult) {
+	status := http.StatusOK
+	if !result.Success {
+		status = http.StatusBadGateway
+	}
+	writeJSON(w, status, result)
+}
+
+//transactRequest : Body of POST /ports/{name}/transact. The framing attributes default to the "framing" adapter setting.
+type transactRequest struct {
+	Data    *string     `json:"data"`
+	Command interface{} `json:"command"`
+}
+
+//transactResult : Answer of POST /ports/{name}/transact
+type transactResult struct {
+	Data       interface{} `json:"data"`
+	DurationMs int64       `json:"durationMs"`
+	Error      string      `json:"error,omitempty"`
+}
+
+//handleTransact : Writes the request and reads the reply while holding the serial port, like a polling job
+func handleTransact(w http.ResponseWriter, r *http.Request) {
+	body, err := readBody(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	transact := transactRequest{}
+	framingJSON := map[string]interface{}{}
+	if err := json.Unmarshal(body, &transact); err != nil {
+		writeError(w, http.StatusBadRequest, "Invalid transact request: "+err.Error())
+		return
+	}
+	json.Unmarshal(body, &framingJSON)
+
+	framing, err := readFraming.framing.override(framingJSON)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	request := &writeRequest{command: transact.Command}
+	switch {
+	case transact.Data != nil && transact.Command != nil:
+		err = fmt.Errorf("data and command cannot both be specified")
+	case transact.Data != nil:
+		request.payload = *transact.Data
+	case transact.Command != nil:
+		err = encodeWriteCommand(request)
+	default:
+		err = fmt.Errorf("data or command must be specified")
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if err := initDeviceProfile(r.Context()); err != nil {
+		writeError(w, http.StatusServiceUnavailable, "Error initializing device profile: "+err.Error())
+		return
+	}
+
+	started := time.Now()
+	serialPortLock.Lock()
+	frame, extra, err := transactSerialPort(r.Context(), request.payload, framing)
+	serialPortLock.Unlock()
+
+	//Unsolicited data that arrived after the reply is not part of the result
+	if extra != "" {
+		publishSerialData(extra)
+	}
+
+	result := transactResult{Data: frame, DurationMs: int64(time.Since(started) / time.Millisecond)}
+	status := http.StatusOK
+	if err != nil {
+		log.Printf("[WARN] handleTransact - %s\n", err.Error())
+		result.Error = err.Error()
+		status = http.StatusBadGateway
+		if err == errFrameTimeout {
+			status = http.StatusGatewayTimeout
+		} else if err == errSerialPortReserved {
+			status = http.StatusConflict
+		}
+	} else if payloadTransform != nil {
+		if decoded, ok := decodeFrame(frame, ""); ok {
+			result.Data = decoded
+		}
+	}
+	writeJSON(w, status, result)
+}
+
+//handleRead : Reads until the device stops sending and answers with the data instead of publishing it
+func handleRead(w http.ResponseWriter, r *http.Request) {
+	if err := initDeviceProfile(r.Context()); err != nil {
+		writeError(w, http.StatusServiceUnavailable, "Error initializing device profile: "+err.Error())
+		return
+	}
+
+	serialPortLock.Lock()
+	data, err := readSerialData(r.Context())
+	serialPortLock.Unlock()
+
+	if err == errSerialPortReserved {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+	if err != nil && !strings.Contains(err.Error(), "EOF") {
+		log.Printf("[ERROR] handleRead - ERROR reading from serial port: %s\n", err.Error())
+		writeError(w, http.StatusBadGateway, err.Error())
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{"data": data, "timestamp": time.Now().UTC().Format(time.RFC3339)})
+}
+
+//handleStream : Sends the frames read by the read worker as server-sent events until the client disconnects
+func handleStream(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "Streaming is not supported")
+		return
+	}
+
+	frames, unsubscribe := receivedFrames.Subscribe()
+	defer unsubscribe()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(apiKeepAliveInterval * time.Second)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case frame := <-frames:
+			payload, err := json.Marshal(frame)
+			if err != nil {
+				log.Printf("[ERROR] handleStream - ERROR marshalling frame: %s\n", err.Error())
+				continue
+			}
+			fmt.Fprintf(w, "event: frame\ndata: %s\n\n", payload)
+		case <-keepAlive.C:
+			io.WriteString(w, ": keep-alive\n\n")
+		case <-r.Context().Done():
+			return
+		case <-adapterContext.Done():
+			return
+		}
+		flusher.Flush()
+	}
+}
+
+//isSerialPortName : True when name is the serial port the adapter uses, with or without its directory
+func isSerialPortName(name string) bool {
+	return name == serialPortName || "/"+name == serialPortName || name == filepath.Base(serialPortName)
+}
+
+//localOnly : Answers 403 unless the Host header is localhost or 127.0.0.1. A web page opened on the
+//gateway cannot reach the API through a DNS name of its own that resolves to 127.0.0.1.
+func localOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		host := r.Host
+		if name, _, err := net.SplitHostPort(host); err == nil {
+			host = name
+		}
+		if !strings.EqualFold(host, "localhost") && host != apiHost {
+			writeError(w, http.StatusForbidden, "Host "+r.Host+" is not allowed, use localhost or "+apiHost)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+//requireJSON : Answers 415 unless the body is application/json. Browsers do not send a cross-site
+//request with that content type without a CORS preflight, which the API does not allow.
+func requireJSON(w http.ResponseWriter, r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err == nil && mediaType == "application/json" {
+		return true
+	}
+	writeError(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json")
+	return false
+}
+
+//allowMethod : Answers 405 unless the request uses method
+func allowMethod(w http.ResponseWriter, r *http.Request, method string) bool {
+	if r.Method == method {
+		return true
+	}
+	w.Header().Set("Allow", method)
+	writeError(w, http.StatusMethodNotAllowed, "Method "+r.Method+" is not allowed")
+	return false
+}
+
+func readBody(r *http.Request) ([]byte, error) {
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, apiMaxBodySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > apiMaxBodySize {
+		return nil, fmt.Errorf("Request body exceeds %d bytes", apiMaxBodySize)
+	}
+	return body, nil
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		log.Printf("[ERROR] writeJSON - ERROR marshalling response: %s\n", err.Error())
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(payload)
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/binary"
+	"log"
+	"serialAdapter/GenericSerial"
+)
+
+//Telnet commands and options used by RFC 2217 (telnet com port control)
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWILL = 251
+	telnetWONT = 252
+	telnetDO   = 253
+	telnetDONT = 254
+	telnetIAC  = 255
+
+	telnetBinary          = 0
+	telnetSuppressGoAhead = 3
+	telnetComPortOption   = 44
+
+	//Server responses add 100 to the client command
+	comPortServerOffset = 100
+)
+
+//Com port option commands sent by the client
+const (
+	comPortSetBaudRate        = 1
+	comPortSetDataSize        = 2
+	comPortSetParity          = 3
+	comPortSetStopSize        = 4
+	comPortSetControl         = 5
+	comPortNotifyLineState    = 6
+	comPortNotifyModemState   = 7
+	comPortFlowControlSuspend = 8
+	comPortFlowControlResume  = 9
+	comPortSetLineStateMask   = 10
+	comPortSetModemStateMask  = 11
+	comPortPurgeData          = 12
+)
+
+//SET-CONTROL values
+const (
+	comPortControlRequestFlow         = 0
+	comPortControlNoFlow              = 1
+	comPortControlXonXoffFlow         = 2
+	comPortControlHardwareFlow        = 3
+	comPortControlRequestBreak        = 4
+	comPortControlBreakOn             = 5
+	comPortControlBreakOff            = 6
+	comPortControlRequestDTR          = 7
+	comPortControlDTROn               = 8
+	comPortControlDTROff              = 9
+	comPortControlRequestRTS          = 10
+	comPortControlRTSOn               = 11
+	comPortControlRTSOff              = 12
+	comPortControlRequestInboundFlow  = 13
+	comPortControlNoInboundFlow       = 14
+	comPortControlXonXoffInboundFlow  = 15
+	comPortControlHardwareInboundFlow = 16
+	comPortControlLastInboundFlow     = 19
+)
+
+//comPortMaxSubnegotiation : Longest subnegotiation kept, the rest is discarded
+const comPortMaxSubnegotiation = 64
+
+//RFC 2217 parity and stop size values
+var (
+	comPortParity   = map[byte]string{1: GenericSerial.ParityNone, 2: GenericSerial.ParityOdd, 3: GenericSerial.ParityEven, 4: GenericSerial.ParityMark, 5: GenericSerial.ParitySpace}
+	comPortStopSize = map[byte]float64{1: 1, 2: 2, 3: 1.5}
+)
+
+//RFC 2217 flow control values. The UART applies one flow control to both directions, the inbound
+//values change the same setting.
+var (
+	comPortFlow        = map[byte]string{comPortControlNoFlow: GenericSerial.FlowNone, comPortControlXonXoffFlow: GenericSerial.FlowXONXOFF, comPortControlHardwareFlow: GenericSerial.FlowRTSCTS}
+	comPortInboundFlow = map[byte]string{comPortControlNoInboundFlow: GenericSerial.FlowNone, comPortControlXonXoffInboundFlow: GenericSerial.FlowXONXOFF, comPortControlHardwareInboundFlow: GenericSerial.FlowRTSCTS}
+)
+
+//comPortControl : The serial port operations a com port control session can request
+type comPortControl interface {
+	//LineSettings : The current speed and character format
+	LineSettings() GenericSerial.LineSettings
+
+	//SetLineSettings : Changes the speed and character format, returns the settings in effect afterwards
+	SetLineSettings(settings GenericSerial.LineSettings) GenericSerial.LineSettings
+
+	//SetControl : Applies a SET-CONTROL value, returns the value to report to the client
+	SetControl(value byte) byte
+
+	//Purge : Discards buffered data, 1 = receive, 2 = transmit, 3 = both
+	Purge(value byte)
+}
+
+//telnetCodec : Separates the data of a telnet connection from the option negotiation and com port
+//control subnegotiations. Decode is not safe for concurrent use, it is called by the go routine
+//reading the connection. Encode keeps no state and may be called from any go routine.
+type telnetCodec struct {
+	control comPortControl
+
+	//Writes negotiation replies to the client
+	reply func(data []byte)
+
+	//Decoder state
+	state     int
+	subOption []byte
+	command   byte
+	enabled   map[byte]bool //Options we agreed to perform (WILL)
+	requested map[byte]bool //Options we asked the client to perform (DO)
+	modemMask byte
+	lineMask  byte
+}
+
+//Decoder states
+const (
+	telnetStateData = iota
+	telnetStateIAC
+	telnetStateOption
+	telnetStateSub
+	telnetStateSubIAC
+)
+
+func newTelnetCodec(control comPortControl, reply func(data []byte)) *telnetCodec {
+	return &telnetCodec{
+		control:   control,
+		reply:     reply,
+		enabled:   map[byte]bool{},
+		requested: map[byte]bool{},
+	}
+}
+
+//Start : Proposes binary transmission and com port control to the client
+func (codec *telnetCodec) Start() {
+	codec.enabled[telnetBinary] = true
+	codec.enabled[telnetSuppressGoAhead] = true
+	codec.requested[telnetBinary] = true
+	codec.requested[telnetComPortOption] = true
+	codec.reply([]byte{
+		telnetIAC, telnetWILL, telnetBinary,
+		telnetIAC, telnetWILL, telnetSuppressGoAhead,
+		telnetIAC, telnetDO, telnetBinary,
+		telnetIAC, telnetDO, telnetComPortOption,
+	})
+}
+
+//Encode : Escapes IAC bytes of data sent to the client
+func (codec *telnetCodec) Encode(data []byte) []byte {
+	encoded := make([]byte, 0, len(data))
+	for _, b := range data {
+		encoded = append(encoded, b)
+		if b == telnetIAC {
+			encoded = append(encoded, telnetIAC)
+		}
+	}
+	return encoded
+}
+
+//Decode : Returns the data bytes received from the client. Negotiations are answered through reply.
+func (codec *telnetCodec) Decode(received []byte) []byte {
+	data := make([]byte, 0, len(received))
+	for _, b := range received {
+		switch codec.state {
+		case telnetStateData:
+			if b == telnetIAC {
+				codec.state = telnetStateIAC
+			} else {
+				data = append(data, b)
+			}
+		case telnetStateIAC:
+			switch b {
+			case telnetIAC:
+				data = append(data, telnetIAC)
+				codec.state = telnetStateData
+			case telnetWILL, telnetWONT, telnetDO, telnetDONT:
+				codec.command = b
+				codec.state = telnetStateOption
+			case telnetSB:
+				codec.subOption = codec.subOption[:0]
+				codec.state = telnetStateSub
+			default:
+				//NOP, GA, AYT and other commands without arguments are ignored
+				codec.state = telnetStateData
+			}
+		case telnetStateOption:
+			codec.negotiate(codec.command, b)
+			codec.state = telnetStateData
+		case telnetStateSub:
+			if b == telnetIAC {
+				codec.state = telnetStateSubIAC
+			} else if len(codec.subOption) < comPortMaxSubnegotiation {
+				codec.subOption = append(codec.subOption, b)
+			}
+		case telnetStateSubIAC:
+			switch b {
+			case telnetSE:
+				codec.subnegotiation(codec.subOption)
+				codec.state = telnetStateData
+			case telnetIAC:
+				if len(codec.subOption) < comPortMaxSubnegotiation {
+					codec.subOption = append(codec.subOption, telnetIAC)
+				}
+				codec.state = telnetStateSub
+			default:
+				codec.state = telnetStateSub
+			}
+		}
+	}
+	return data
+}
+
+//negotiate : Answers WILL, WONT, DO and DONT. Replies are only sent when the state changes so
+//the negotiation cannot loop.
+func (codec *telnetCodec) negotiate(command byte, option byte) {
+	switch command {
+	case telnetDO:
+		if option == telnetBinary || option == telnetSuppressGoAhead {
+			if !codec.enabled[option] {
+				codec.enabled[option] = true
+				codec.reply([]byte{telnetIAC, telnetWILL, option})
+			}
+		} else {
+			codec.reply([]byte{telnetIAC, telnetWONT, option})
+		}
+	case telnetDONT:
+		if codec.enabled[option] {
+			codec.enabled[option] = false
+			codec.reply([]byte{telnetIAC, telnetWONT, option})
+		}
+	case telnetWILL:
+		if option == telnetBinary || option == telnetSuppressGoAhead || option == telnetComPortOption {
+			if option == telnetComPortOption {
+				log.Println("[DEBUG] telnetCodec - Client enabled com port control")
+			}
+			if !codec.requested[option] {
+				codec.requested[option] = true
+				codec.reply([]byte{telnetIAC, telnetDO, option})
+			}
+		} else {
+			codec.reply([]byte{telnetIAC, telnetDONT, option})
+		}
+	case telnetWONT:
+		if codec.requested[option] {
+			codec.requested[option] = false
+			codec.reply([]byte{telnetIAC, telnetDONT, option})
+		}
+	}
+}
+
+//subnegotiation : Handles a com port control command and answers with the value in effect
+func (codec *telnetCodec) subnegotiation(sub []byte) {
+	if len(sub) < 2 || sub[0] != telnetComPortOption {
+		log.Printf("[DEBUG] telnetCodec - Ignoring subnegotiation %v\n", sub)
+		return
+	}
+
+	command, value := sub[1], sub[2:]
+	var answer []byte
+	switch command {
+	case comPortSetBaudRate:
+		settings := codec.control.LineSettings()
+		if len(value) == 4 && binary.BigEndian.Uint32(value) != 0 {
+			settings.BaudRate = int(binary.BigEndian.Uint32(value))
+			settings = codec.control.SetLineSettings(settings)
+		}
+		answer = make([]byte, 4)
+		binary.BigEndian.PutUint32(answer, uint32(settings.BaudRate))
+	case comPortSetDataSize:
+		settings := codec.control.LineSettings()
+		if len(value) == 1 && value[0] != 0 {
+			settings.DataBits = int(value[0])
+			settings = codec.control.SetLineSettings(settings)
+		}
+		answer = []byte{byte(settings.DataBits)}
+	case comPortSetParity:
+		settings := codec.control.LineSettings()
+		if len(value) == 1 && value[0] != 0 {
+			if parity, ok := comPortParity[value[0]]; ok {
+				settings.Parity = parity
+				settings = codec.control.SetLineSettings(settings)
+			}
+		}
+		answer = []byte{comPortCode(comPortParity, settings.Parity)}
+	case comPortSetStopSize:
+		settings := codec.control.LineSettings()
+		if len(value) == 1 && value[0] != 0 {
+			if stopBits, ok := comPortStopSize[value[0]]; ok {
+				settings.StopBits = stopBits
+				settings = codec.control.SetLineSettings(settings)
+			}
+		}
+		answer = []byte{comPortStopCode(settings.StopBits)}
+	case comPortSetControl:
+		if len(value) != 1 {
+			return
+		}
+		answer = []byte{codec.control.SetControl(value[0])}
+	case comPortSetLineStateMask:
+		if len(value) == 1 {
+			codec.lineMask = value[0]
+		}
+		answer = []byte{codec.lineMask}
+	case comPortSetModemStateMask:
+		if len(value) == 1 {
+			codec.modemMask = value[0]
+		}
+		answer = []byte{codec.modemMask}
+	case comPortPurgeData:
+		if len(value) == 1 {
+			codec.control.Purge(value[0])
+		}
+		answer = value
+	case comPortFlowControlSuspend, comPortFlowControlResume:
+		answer = []byte{}
+	case comPortNotifyLineState, comPortNotifyModemState:
+		//Notifications are only sent by the server
+		return
+	default:
+		log.Printf("[DEBUG] telnetCodec - Unknown com port command %d\n", command)
+		return
+	}
+
+	codec.reply(codec.comPortMessage(command+comPortServerOffset, answer))
+}
+
+//comPortMessage : IAC SB COM-PORT-OPTION command value IAC SE, with IAC bytes of the value escaped
+func (codec *telnetCodec) comPortMessage(command byte, value []byte) []byte {
+	message := []byte{telnetIAC, telnetSB, telnetComPortOption, command}
+	message = append(message, codec.Encode(value)...)
+	return append(message, telnetIAC, telnetSE)
+}
+
+//comPortCode : The RFC 2217 value of a parity or flow control
+func comPortCode(codes map[byte]string, value string) byte {
+	for code, name := range codes {
+		if name == value {
+			return code
+		}
+	}
+	return 0
+}
+
+//comPortStopCode : The RFC 2217 value of a stop size
+func comPortStopCode(stopBits float64) byte {
+	for code, value := range comPortStopSize {
+		if value == stopBits {
+			return code
+		}
+	}
+	return 0
+}
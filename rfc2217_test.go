@@ -0,0 +1,237 @@
+package main
+
+import (
+	"bytes"
+	"serialAdapter/GenericSerial"
+	"testing"
+)
+
+//fakeComPort : A comPortControl recording the settings requested by the client
+type fakeComPort struct {
+	settings GenericSerial.LineSettings
+	controls []byte
+	purged   []byte
+}
+
+func (port *fakeComPort) LineSettings() GenericSerial.LineSettings {
+	return port.settings
+}
+
+func (port *fakeComPort) SetLineSettings(settings GenericSerial.LineSettings) GenericSerial.LineSettings {
+	port.settings = settings
+	return port.settings
+}
+
+func (port *fakeComPort) SetControl(value byte) byte {
+	port.controls = append(port.controls, value)
+	return value
+}
+
+func (port *fakeComPort) Purge(value byte) {
+	port.purged = append(port.purged, value)
+}
+
+//newTestTelnetCodec : A codec for port that collects its replies
+func newTestTelnetCodec(port *fakeComPort) (*telnetCodec, *bytes.Buffer) {
+	replies := &bytes.Buffer{}
+	return newTelnetCodec(port, func(data []byte) { replies.Write(data) }), replies
+}
+
+//comPortRequest : IAC SB COM-PORT-OPTION command value IAC SE as sent by the client, value already escaped
+func comPortRequest(command byte, value ...byte) []byte {
+	request := []byte{telnetIAC, telnetSB, telnetComPortOption, command}
+	request = append(request, value...)
+	return append(request, telnetIAC, telnetSE)
+}
+
+func TestTelnetCodecEncode(t *testing.T) {
+	tests := []struct {
+		data, encoded []byte
+	}{
+		{[]byte{}, []byte{}},
+		{[]byte("plain"), []byte("plain")},
+		{[]byte{telnetIAC}, []byte{telnetIAC, telnetIAC}},
+		{[]byte{1, telnetIAC, telnetIAC, 2}, []byte{1, telnetIAC, telnetIAC, telnetIAC, telnetIAC, 2}},
+	}
+
+	codec, _ := newTestTelnetCodec(&fakeComPort{})
+	for _, test := range tests {
+		if encoded := codec.Encode(test.data); !bytes.Equal(encoded, test.encoded) {
+			t.Errorf("Encode(%v) = %v, expected %v", test.data, encoded, test.encoded)
+		}
+	}
+}
+
+func TestTelnetCodecDecode(t *testing.T) {
+	tests := []struct {
+		name     string
+		received []byte
+		data     []byte
+		replies  []byte
+	}{
+		{
+			name:     "plain data",
+			received: []byte("AT\r\n"),
+			data:     []byte("AT\r\n"),
+		},
+		{
+			name:     "escaped IAC",
+			received: []byte{'a', telnetIAC, telnetIAC, 'b'},
+			data:     []byte{'a', telnetIAC, 'b'},
+		},
+		{
+			name:     "commands without arguments are dropped",
+			received: []byte{'a', telnetIAC, 241, 'b'},
+			data:     []byte("ab"),
+		},
+		{
+			name:     "negotiation is removed from the data and answered",
+			received: []byte{'a', telnetIAC, telnetDO, telnetBinary, telnetIAC, telnetDO, 1, 'b'},
+			data:     []byte("ab"),
+			replies:  []byte{telnetIAC, telnetWILL, telnetBinary, telnetIAC, telnetWONT, 1},
+		},
+		{
+			name:     "client enables com port control",
+			received: []byte{telnetIAC, telnetWILL, telnetComPortOption, telnetIAC, telnetWILL, 1},
+			data:     []byte{},
+			replies:  []byte{telnetIAC, telnetDO, telnetComPortOption, telnetIAC, telnetDONT, 1},
+		},
+		{
+			name:     "unknown subnegotiation is ignored",
+			received: []byte{'a', telnetIAC, telnetSB, 24, 1, telnetIAC, telnetSE, 'b'},
+			data:     []byte("ab"),
+		},
+		{
+			name:     "overlong subnegotiation is discarded",
+			received: append(append([]byte{telnetIAC, telnetSB, 24}, bytes.Repeat([]byte{telnetIAC, telnetIAC}, 2*comPortMaxSubnegotiation)...), telnetIAC, telnetSE, 'z'),
+			data:     []byte("z"),
+		},
+	}
+
+	for _, test := range tests {
+		//Decoding byte by byte must give the same result as decoding everything at once
+		for _, split := range []bool{false, true} {
+			codec, replies := newTestTelnetCodec(&fakeComPort{})
+			data := []byte{}
+			if split {
+				for _, b := range test.received {
+					data = append(data, codec.Decode([]byte{b})...)
+				}
+			} else {
+				data = codec.Decode(test.received)
+			}
+
+			if !bytes.Equal(data, test.data) {
+				t.Errorf("%s (split %t): decoded %v, expected %v", test.name, split, data, test.data)
+			}
+			if !bytes.Equal(replies.Bytes(), test.replies) {
+				t.Errorf("%s (split %t): replied %v, expected %v", test.name, split, replies.Bytes(), test.replies)
+			}
+			if len(codec.subOption) > comPortMaxSubnegotiation {
+				t.Errorf("%s (split %t): kept %d subnegotiation bytes, expected at most %d", test.name, split, len(codec.subOption), comPortMaxSubnegotiation)
+			}
+		}
+	}
+}
+
+func TestTelnetCodecNegotiationDoesNotLoop(t *testing.T) {
+	codec, replies := newTestTelnetCodec(&fakeComPort{})
+	codec.Start()
+	replies.Reset()
+
+	//The client agrees to what the server proposed, nothing more is sent
+	codec.Decode([]byte{
+		telnetIAC, telnetDO, telnetBinary,
+		telnetIAC, telnetDO, telnetSuppressGoAhead,
+		telnetIAC, telnetWILL, telnetBinary,
+		telnetIAC, telnetWILL, telnetComPortOption,
+	})
+	if replies.Len() != 0 {
+		t.Errorf("Replied %v to an agreement, expected nothing", replies.Bytes())
+	}
+
+	codec.Decode([]byte{telnetIAC, telnetDONT, telnetBinary, telnetIAC, telnetDONT, telnetBinary})
+	if expected := []byte{telnetIAC, telnetWONT, telnetBinary}; !bytes.Equal(replies.Bytes(), expected) {
+		t.Errorf("Replied %v to DONT BINARY twice, expected %v", replies.Bytes(), expected)
+	}
+}
+
+func TestTelnetCodecSetBaudRate(t *testing.T) {
+	tests := []struct {
+		name     string
+		request  []byte //Escaped value of SET-BAUDRATE
+		baudRate int
+		reply    []byte //Escaped value of the answer
+	}{
+		{
+			name:     "set",
+			request:  []byte{0x00, 0x01, 0xc2, 0x00},
+			baudRate: 115200,
+			reply:    []byte{0x00, 0x01, 0xc2, 0x00},
+		},
+		{
+			name:     "value containing IAC",
+			request:  []byte{0x00, 0x00, telnetIAC, telnetIAC, 0x00},
+			baudRate: 0xff00,
+			reply:    []byte{0x00, 0x00, telnetIAC, telnetIAC, 0x00},
+		},
+		{
+			name:     "zero queries the current rate",
+			request:  []byte{0x00, 0x00, 0x00, 0x00},
+			baudRate: 9600,
+			reply:    []byte{0x00, 0x00, 0x25, 0x80},
+		},
+		{
+			name:     "wrong length queries the current rate",
+			request:  []byte{0x01, 0xc2, 0x00},
+			baudRate: 9600,
+			reply:    []byte{0x00, 0x00, 0x25, 0x80},
+		},
+	}
+
+	for _, test := range tests {
+		port := &fakeComPort{settings: GenericSerial.LineSettings{BaudRate: 9600, DataBits: 8, Parity: GenericSerial.ParityNone, StopBits: 1}}
+		codec, replies := newTestTelnetCodec(port)
+
+		data := codec.Decode(append(append([]byte("before"), comPortRequest(comPortSetBaudRate, test.request...)...), []byte("after")...))
+		if string(data) != "beforeafter" {
+			t.Errorf("%s: decoded %q, expected the data around the subnegotiation", test.name, data)
+		}
+		if port.settings.BaudRate != test.baudRate {
+			t.Errorf("%s: baud rate is %d, expected %d", test.name, port.settings.BaudRate, test.baudRate)
+		}
+		if expected := comPortRequest(comPortSetBaudRate+comPortServerOffset, test.reply...); !bytes.Equal(replies.Bytes(), expected) {
+			t.Errorf("%s: replied %v, expected %v", test.name, replies.Bytes(), expected)
+		}
+	}
+}
+
+func TestTelnetCodecLineSettings(t *testing.T) {
+	port := &fakeComPort{settings: GenericSerial.LineSettings{BaudRate: 9600, DataBits: 8, Parity: GenericSerial.ParityNone, StopBits: 1}}
+	codec, replies := newTestTelnetCodec(port)
+
+	codec.Decode(comPortRequest(comPortSetDataSize, 7))
+	codec.Decode(comPortRequest(comPortSetParity, 3))
+	codec.Decode(comPortRequest(comPortSetStopSize, 2))
+	codec.Decode(comPortRequest(comPortSetControl, comPortControlDTROn))
+	codec.Decode(comPortRequest(comPortPurgeData, 3))
+
+	expected := GenericSerial.LineSettings{BaudRate: 9600, DataBits: 7, Parity: GenericSerial.ParityEven, StopBits: 2}
+	if port.settings != expected {
+		t.Errorf("Line settings are %+v, expected %+v", port.settings, expected)
+	}
+	if !bytes.Equal(port.controls, []byte{comPortControlDTROn}) || !bytes.Equal(port.purged, []byte{3}) {
+		t.Errorf("Control %v and purge %v, expected [%d] and [3]", port.controls, port.purged, comPortControlDTROn)
+	}
+
+	expectedReplies := bytes.Join([][]byte{
+		comPortRequest(comPortSetDataSize+comPortServerOffset, 7),
+		comPortRequest(comPortSetParity+comPortServerOffset, 3),
+		comPortRequest(comPortSetStopSize+comPortServerOffset, 2),
+		comPortRequest(comPortSetControl+comPortServerOffset, comPortControlDTROn),
+		comPortRequest(comPortPurgeData+comPortServerOffset, 3),
+	}, nil)
+	if !bytes.Equal(replies.Bytes(), expectedReplies) {
+		t.Errorf("Replied %v, expected %v", replies.Bytes(), expectedReplies)
+	}
+}
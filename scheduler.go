@@ -0,0 +1,308 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+//defaultPollTopicTemplate : Topic the results of a polling job are published to
+const defaultPollTopicTemplate = "{root}/poll/{job}"
+
+//pollJob : Writes a query to the serial device on a schedule and publishes the framed reply
+//
+//	{
+//	  "name": "temperature",
+//	  "interval": 30,
+//	  "cron": "*/5 * * * *",
+//	  "payload": "READ TEMP\r\n",
+//	  "framing": {"terminator": "\r\n", "timeout": 2000},
+//	  "topic": "{root}/{device}/temperature",
+//	  "qos": 1
+//	}
+//
+// Exactly one of interval (seconds) and cron must be specified.
+type pollJob struct {
+	name     string
+	interval time.Duration
+	cron     *cronSchedule
+	payload  string
+	framing  responseFraming
+	topic    string
+	options  topicOptions
+}
+
+//pollResult : Published to the topic of the job
+type pollResult struct {
+	Job       string      `json:"job"`
+	Data      interface{} `json:"data"`
+	Timestamp string      `json:"timestamp"`
+	Error     string      `json:"error,omitempty"`
+}
+
+//pollJobStatus : Status of a job included in the health status
+type pollJobStatus struct {
+	Runs      int64  `json:"runs"`
+	Failures  int64  `json:"failures"`
+	Skipped   int64  `json:"skipped"`
+	LastRun   string `json:"lastRun,omitempty"`
+	LastError string `json:"lastError,omitempty"`
+	NextRun   string `json:"nextRun,omitempty"`
+}
+
+//pollScheduler : Runs the polling jobs. Jobs run whether or not the broker is reachable, their
+//results are published through the outbox.
+type pollScheduler struct {
+	jobs []*pollJob
+
+	lock   sync.Mutex
+	status map[string]*pollJobStatus
+}
+
+//loadPollJobs : Creates the scheduler from the "pollJobs" adapter setting. Must be called after the topics are resolved.
+func loadPollJobs(adapterSettings map[string]interface{}) (*pollScheduler, error) {
+	scheduler := &pollScheduler{status: map[string]*pollJobStatus{}}
+
+	jobsJSON, ok := adapterSettings["pollJobs"].([]interface{})
+	if !ok {
+		if adapterSettings["pollJobs"] != nil {
+			return nil, errors.New("pollJobs must be an array")
+		}
+		return scheduler, nil
+	}
+
+	for index, value := range jobsJSON {
+		jobJSON, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("pollJobs[%d] must be an object", index)
+		}
+
+		job, err := parsePollJob(jobJSON)
+		if err != nil {
+			return nil, fmt.Errorf("pollJobs[%d]: %s", index, err.Error())
+		}
+		if _, exists := scheduler.status[job.name]; exists {
+			return nil, fmt.Errorf("pollJobs[%d]: duplicate job name %s", index, job.name)
+		}
+
+		scheduler.jobs = append(scheduler.jobs, job)
+		scheduler.status[job.name] = &pollJobStatus{}
+
+		if job.cron != nil {
+			log.Printf("[INFO] loadPollJobs - Job %s runs on cron schedule %s, publishing to %s\n", job.name, job.cron.expression, job.topic)
+		} else {
+			log.Printf("[INFO] loadPollJobs - Job %s runs every %s, publishing to %s\n", job.name, job.interval.String(), job.topic)
+		}
+	}
+
+	return scheduler, nil
+}
+
+func parsePollJob(jobJSON map[string]interface{}) (*pollJob, error) {
+	job := &pollJob{}
+
+	job.name, _ = jobJSON["name"].(string)
+	if job.name == "" {
+		return nil, errors.New("name is required")
+	}
+
+	job.payload, _ = jobJSON["payload"].(string)
+	if job.payload == "" {
+		return nil, errors.New("payload is required")
+	}
+
+	interval, hasInterval := jobJSON["interval"].(float64)
+	expression, hasCron := jobJSON["cron"].(string)
+	switch {
+	case hasInterval && hasCron:
+		return nil, errors.New("specify either interval or cron, not both")
+	case hasInterval:
+		if interval <= 0 {
+			return nil, errors.New("interval must be a positive number of seconds")
+		}
+		job.interval = time.Duration(interval * float64(time.Second))
+	case hasCron:
+		schedule, err := parseCronSchedule(expression)
+		if err != nil {
+			return nil, err
+		}
+		job.cron = schedule
+	default:
+		return nil, errors.New("interval or cron is required")
+	}
+
+	framingJSON, _ := jobJSON["framing"].(map[string]interface{})
+	framing, err := parseResponseFraming(framingJSON)
+	if err != nil {
+		return nil, err
+	}
+	job.framing = framing
+
+	template := defaultPollTopicTemplate
+	if value, ok := jobJSON["topic"].(string); ok && value != "" {
+		template = value
+	}
+	values := topicTemplateValues()
+	values["{job}"] = job.name
+	if job.topic, err = expandTopicTemplate(template, values); err != nil {
+		return nil, errors.New("invalid topic: " + err.Error())
+	}
+
+	job.options = topicOptions{qos: msgPublishQos}
+	if qos, ok := jobJSON["qos"].(float64); ok {
+		if qos < 0 || qos > 2 || qos != float64(int(qos)) {
+			return nil, fmt.Errorf("invalid qos %v", qos)
+		}
+		job.options.qos = int(qos)
+	}
+	if retain, ok := jobJSON["retain"].(bool); ok {
+		job.options.retain = retain
+	}
+
+	return job, nil
+}
+
+//HasJobs : True if any polling jobs are configured
+func (scheduler *pollScheduler) HasJobs() bool {
+	return scheduler != nil && len(scheduler.jobs) > 0
+}
+
+//Run : Runs every job on its schedule until ctx is done
+func (scheduler *pollScheduler) Run(ctx context.Context) {
+	if !scheduler.HasJobs() {
+		return
+	}
+
+	log.Printf("[INFO] pollScheduler - Starting %d polling jobs\n", len(scheduler.jobs))
+	group := &sync.WaitGroup{}
+	for _, job := range scheduler.jobs {
+		group.Add(1)
+		go func(job *pollJob) {
+			defer group.Done()
+			scheduler.runJob(ctx, job)
+		}(job)
+	}
+	group.Wait()
+	log.Println("[INFO] pollScheduler - Polling jobs stopped")
+}
+
+func (scheduler *pollScheduler) runJob(ctx context.Context, job *pollJob) {
+	for {
+		now := time.Now()
+		var next time.Time
+		if job.cron != nil {
+			if next = job.cron.Next(now); next.IsZero() {
+				log.Printf("[ERROR] pollScheduler - Cron schedule of job %s never matches, job disabled\n", job.name)
+				return
+			}
+		} else {
+			next = now.Add(job.interval)
+		}
+		scheduler.updateStatus(job.name, func(status *pollJobStatus) {
+			status.NextRun = next.UTC().Format(time.RFC3339)
+		})
+
+		timer := time.NewTimer(next.Sub(now))
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		}
+
+		scheduler.poll(ctx, job)
+	}
+}
+
+//poll : Writes the job payload, reads the framed reply and publishes it through the outbox
+func (scheduler *pollScheduler) poll(ctx context.Context, job *pollJob) {
+	log.Printf("[DEBUG] pollScheduler - Running job %s\n", job.name)
+	started := time.Now()
+
+	serialPortLock.Lock()
+	frame, extra, err := transactSerialPort(ctx, job.payload, job.framing)
+	serialPortLock.Unlock()
+
+	if ctx.Err() != nil {
+		log.Printf("[DEBUG] pollScheduler - Job %s cancelled, discarding reply\n", job.name)
+		return
+	}
+	if err == errSerialPortReserved {
+		//Polling is paused while an exclusive session owns the serial port
+		log.Printf("[DEBUG] pollScheduler - Skipping job %s: %s\n", job.name, err.Error())
+		scheduler.updateStatus(job.name, func(status *pollJobStatus) { status.Skipped++ })
+		return
+	}
+
+	result := pollResult{Job: job.name, Data: frame, Timestamp: started.UTC().Format(time.RFC3339)}
+	if err != nil {
+		log.Printf("[WARN] pollScheduler - Job %s failed: %s\n", job.name, err.Error())
+		result.Error = err.Error()
+	} else if payloadTransform != nil {
+		//Replies that cannot be decoded are reported on the error topic and published unchanged
+		if decoded, ok := decodeFrame(frame, job.name); ok {
+			result.Data = decoded
+		}
+	}
+
+	scheduler.updateStatus(job.name, func(status *pollJobStatus) {
+		status.Runs++
+		status.LastRun = result.Timestamp
+		status.LastError = result.Error
+		if err != nil {
+			status.Failures++
+		}
+	})
+
+	payload, marshalErr := json.Marshal(result)
+	if marshalErr != nil {
+		log.Printf("[ERROR] pollScheduler - ERROR marshalling result of job %s: %s\n", job.name, marshalErr.Error())
+		return
+	}
+	outbox.Publish(job.topic, string(payload), job.options)
+
+	//Unsolicited data that arrived after the reply is not part of the job result
+	if extra != "" {
+		outbox.Publish(topic(readResponseTopicName), extra, mqttConfig.options(readResponseTopicName))
+	}
+}
+
+//transactSerialPort : Writes payload and reads the framed reply. Data received after the reply is
+//returned separately. Must be called while holding serialPortLock.
+func transactSerialPort(ctx context.Context, payload string, framing responseFraming) (string, string, error) {
+	if serialPortOwner != "" {
+		return "", "", errSerialPortReserved
+	}
+	if _, err := serialPort.WriteSerialPort(ctx, payload); err != nil {
+		return "", "", err
+	}
+	if err := deviceProfile.PostWrite(ctx, serialPort); err != nil {
+		log.Printf("[WARN] transactSerialPort - Device profile post-write failed: %s\n", err.Error())
+	}
+	return framing.readFrame(ctx, serialPort.ReadSerialPort)
+}
+
+func (scheduler *pollScheduler) updateStatus(name string, update func(status *pollJobStatus)) {
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+	update(scheduler.status[name])
+}
+
+//Status : A snapshot of the status of every job
+func (scheduler *pollScheduler) Status() map[string]pollJobStatus {
+	status := map[string]pollJobStatus{}
+	if scheduler == nil {
+		return status
+	}
+
+	scheduler.lock.Lock()
+	defer scheduler.lock.Unlock()
+	for name, jobStatus := range scheduler.status {
+		status[name] = *jobStatus
+	}
+	return status
+}
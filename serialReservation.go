@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+)
+
+//errSerialPortReserved : Returned by serial port operations while an exclusive session owns the port
+var errSerialPortReserved = errors.New("The serial port is reserved by an exclusive session")
+
+//serialPortOwner : The exclusive session (TCP client or MQTT tunnel) that owns the serial port,
+//empty when the port is not reserved. Guarded by serialPortLock.
+var serialPortOwner string
+
+//reserveSerialPort : Hands the serial port over to an exclusive session. The read worker, write
+//worker, polling jobs and REST API are refused until the port is released. The device profile is
+//shut down first, the session speaks to the device directly.
+func reserveSerialPort(owner string) error {
+	serialPortLock.Lock()
+	if serialPortOwner != "" {
+		current := serialPortOwner
+		serialPortLock.Unlock()
+		return fmt.Errorf("The serial port is reserved by %s", current)
+	}
+	serialPortOwner = owner
+	serialPortLock.Unlock()
+
+	log.Printf("[INFO] reserveSerialPort - Serial port reserved by %s\n", owner)
+	if err := shutdownDeviceProfile(adapterContext); err != nil {
+		log.Printf("[WARN] reserveSerialPort - Error shutting down device profile: %s\n", err.Error())
+	}
+
+	serialPortLock.Lock()
+	defer serialPortLock.Unlock()
+	if err := serialPort.FlushSerialPort(); err != nil {
+		log.Printf("[WARN] reserveSerialPort - Error flushing serial port: %s\n", err.Error())
+	}
+	return nil
+}
+
+//releaseSerialPort : Returns the serial port to the adapter. The device profile is initialized
+//again for the work that was waiting for the port.
+func releaseSerialPort() {
+	serialPortLock.Lock()
+	log.Printf("[INFO] releaseSerialPort - Serial port released by %s\n", serialPortOwner)
+	serialPortOwner = ""
+	serialPortLock.Unlock()
+
+	if adapterContext.Err() == nil && (workers.State() == workersRunning || scheduler.HasJobs()) {
+		if err := initDeviceProfile(adapterContext); err != nil {
+			log.Printf("[ERROR] releaseSerialPort - Error initializing device profile: %s\n", err.Error())
+		}
+	}
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+)
+
+//adapterConfigCache : The adapter configuration rows last retrieved from the platform, kept in the
+//file named by -settingsCacheFile. When the adapter is restarted while the platform is unreachable
+//it starts from these rows, so polling jobs run and fill the outbox until the platform is reachable.
+//The file has the format of the standalone mode configFile.
+type adapterConfigCache struct {
+	fileName string
+
+	lock    sync.Mutex
+	rows    []map[string]interface{}
+	changed bool
+}
+
+//Adapter configuration rows of the previous run, nil when -settingsCacheFile is not specified
+var configCache *adapterConfigCache
+
+//loadAdapterConfigCache : Reads the rows cached by the previous run. A missing or unreadable file
+//leaves the cache empty. Returns nil when fileName is empty.
+func loadAdapterConfigCache(fileName string) *adapterConfigCache {
+	if fileName == "" {
+		return nil
+	}
+
+	cache := &adapterConfigCache{fileName: fileName}
+	if _, err := os.Stat(fileName); os.IsNotExist(err) {
+		log.Printf("[INFO] loadAdapterConfigCache - %s does not exist yet, it is written once the configuration is retrieved from the platform\n", fileName)
+		return cache
+	}
+
+	rows, err := loadConfigFile(fileName)
+	if err != nil {
+		log.Printf("[WARN] loadAdapterConfigCache - Ignoring the cached adapter configuration: %s\n", err.Error())
+		return cache
+	}
+	cache.rows = rows
+	return cache
+}
+
+//HasRows : Returns true if the adapter can start from the cache
+func (cache *adapterConfigCache) HasRows() bool {
+	if cache == nil {
+		return false
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+	return len(cache.rows) > 0
+}
+
+//Query : The cached rows whose columns equal every condition
+func (cache *adapterConfigCache) Query(conditions map[string]interface{}) []map[string]interface{} {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	matches := []map[string]interface{}{}
+	for _, row := range cache.rows {
+		if rowMatches(row, conditions) {
+			matches = append(matches, row)
+		}
+	}
+	return matches
+}
+
+//Store : Replaces the cached rows matching conditions with the rows retrieved from the platform
+func (cache *adapterConfigCache) Store(conditions map[string]interface{}, rows []map[string]interface{}) {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	kept := []map[string]interface{}{}
+	previous := []map[string]interface{}{}
+	for _, row := range cache.rows {
+		if rowMatches(row, conditions) {
+			previous = append(previous, row)
+		} else {
+			kept = append(kept, row)
+		}
+	}
+
+	//The rows are compared as JSON, the file does not preserve the types of the platform client
+	if !sameJSON(previous, rows) {
+		cache.changed = true
+	}
+	cache.rows = append(kept, rows...)
+}
+
+//Save : Writes the rows to the cache file if they changed. Returns true if the file was written.
+func (cache *adapterConfigCache) Save() (bool, error) {
+	if cache == nil {
+		return false, nil
+	}
+
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	if !cache.changed {
+		return false, nil
+	}
+	contents, err := json.MarshalIndent(cache.rows, "", "  ")
+	if err != nil {
+		return false, err
+	}
+	if err := writeStateFile(cache.fileName, contents); err != nil {
+		return false, err
+	}
+	cache.changed = false
+	log.Printf("[INFO] adapterConfigCache - Saved %d adapter configuration rows to %s\n", len(cache.rows), cache.fileName)
+	return true, nil
+}
+
+//AdapterNames : The adapter_name of every cached row, the adapter configuration and transform scripts
+func (cache *adapterConfigCache) AdapterNames() []string {
+	cache.lock.Lock()
+	defer cache.lock.Unlock()
+
+	names := []string{}
+	for _, row := range cache.rows {
+		if name, ok := row["adapter_name"].(string); ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func rowMatches(row map[string]interface{}, conditions map[string]interface{}) bool {
+	for column, value := range conditions {
+		if row[column] != value {
+			return false
+		}
+	}
+	return true
+}
+
+func sameJSON(a, b interface{}) bool {
+	aJSON, aErr := json.Marshal(a)
+	bJSON, bErr := json.Marshal(b)
+	if aErr != nil || bErr != nil {
+		return false
+	}
+
+	var aValue, bValue interface{}
+	json.Unmarshal(aJSON, &aValue)
+	json.Unmarshal(bJSON, &bValue)
+	return reflect.DeepEqual(aValue, bValue)
+}
+
+//writeStateFile : Replaces fileName with contents, readable only by the adapter. The contents are
+//written to a temporary file first so a crash cannot leave a truncated file behind.
+func writeStateFile(fileName string, contents []byte) error {
+	temp, err := ioutil.TempFile(filepath.Dir(fileName), filepath.Base(fileName)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+
+	if _, err := temp.Write(contents); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Sync(); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), fileName)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestAdapterConfigCache(t *testing.T) {
+	fileName := filepath.Join(t.TempDir(), "settings.json")
+	settingsRow := map[string]interface{}{
+		"adapter_name":     adapterName,
+		"topic_root":       "serial",
+		"adapter_settings": `{"pollJobs": [{"name": "temperature", "interval": 60, "payload": "T?\r"}]}`,
+	}
+	scriptRow := map[string]interface{}{"adapter_name": "thermometer", "script": "function decode(frame) { return frame }"}
+
+	if cache := loadAdapterConfigCache(""); cache.HasRows() {
+		t.Error("A cache without a file has rows")
+	}
+
+	cache := loadAdapterConfigCache(fileName)
+	if cache.HasRows() {
+		t.Fatal("A cache without a file has rows")
+	}
+	cache.Store(map[string]interface{}{"adapter_name": adapterName}, []map[string]interface{}{settingsRow})
+	cache.Store(map[string]interface{}{"adapter_name": "thermometer"}, []map[string]interface{}{scriptRow})
+	if saved, err := cache.Save(); !saved || err != nil {
+		t.Fatalf("Save returned %t, %v, expected the file to be written", saved, err)
+	}
+
+	restored := loadAdapterConfigCache(fileName)
+	if !restored.HasRows() {
+		t.Fatal("The saved rows were not restored")
+	}
+	rows := restored.Query(map[string]interface{}{"adapter_name": adapterName})
+	if len(rows) != 1 || rows[0]["adapter_settings"] != settingsRow["adapter_settings"] || rows[0]["topic_root"] != "serial" {
+		t.Errorf("Query returned %v, expected the adapter settings row", rows)
+	}
+	if rows := restored.Query(map[string]interface{}{"adapter_name": "thermometer"}); len(rows) != 1 || rows[0]["script"] != scriptRow["script"] {
+		t.Errorf("Query returned %v, expected the script row", rows)
+	}
+
+	//Retrieving the same rows again does not rewrite the file
+	restored.Store(map[string]interface{}{"adapter_name": adapterName}, []map[string]interface{}{settingsRow})
+	if saved, err := restored.Save(); saved || err != nil {
+		t.Errorf("Save returned %t, %v for unchanged rows, expected nothing to be written", saved, err)
+	}
+
+	changed := map[string]interface{}{"adapter_name": adapterName, "topic_root": "sensors"}
+	restored.Store(map[string]interface{}{"adapter_name": adapterName}, []map[string]interface{}{changed})
+	if saved, err := restored.Save(); !saved || err != nil {
+		t.Errorf("Save returned %t, %v for changed rows, expected the file to be written", saved, err)
+	}
+	if rows := loadAdapterConfigCache(fileName).Query(map[string]interface{}{"adapter_name": adapterName}); len(rows) != 1 || rows[0]["topic_root"] != "sensors" {
+		t.Errorf("Query returned %v after the change, expected topic_root sensors", rows)
+	}
+}
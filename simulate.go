@@ -0,0 +1,102 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/signal"
+	"serialAdapter/Simulator"
+	"strings"
+	"syscall"
+
+	"github.com/hashicorp/logutils"
+)
+
+//simulateCommand : First argument that runs the device simulator instead of the adapter
+const simulateCommand = "simulate"
+
+//runSimulator : Emulates a serial device on a pseudo-terminal until SIGINT or SIGTERM
+//
+//	serialAdapter simulate -device=modem -link=/tmp/ttySIM -config=modem.json
+//
+// The adapter is then started with -serialPortName (or adapter_settings serialPortName) set to the
+// printed pseudo-terminal path or to the link.
+func runSimulator(args []string) int {
+	flags := flag.NewFlagSet(simulateCommand, flag.ExitOnError)
+	deviceType := flags.String("device", Simulator.ModemDeviceName, "The simulated device: "+strings.Join(Simulator.DeviceNames(), ", "))
+	configFile := flags.String("config", "", "JSON file containing the settings of the simulated device (optional)")
+	link := flags.String("link", "", "Symbolic link created to the pseudo-terminal so the adapter can use a stable path (optional)")
+	level := flags.String("logLevel", "info", "The level of logging to use. Available levels are 'debug, 'info', 'warn', 'error', 'fatal' (optional)")
+	flags.Usage = func() {
+		log.Printf("Usage: serialAdapter %s [options]\n\n", simulateCommand)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	log.SetOutput(&logutils.LevelFilter{
+		Levels:   []logutils.LogLevel{"DEBUG", "INFO", "WARN", "ERROR", "FATAL"},
+		MinLevel: logutils.LogLevel(strings.ToUpper(*level)),
+		Writer:   os.Stdout,
+	})
+
+	settings := map[string]interface{}{}
+	if *configFile != "" {
+		contents, err := ioutil.ReadFile(*configFile)
+		if err != nil {
+			log.Printf("[FATAL] runSimulator - Unable to read %s: %s\n", *configFile, err.Error())
+			return 1
+		}
+		if err := json.Unmarshal(contents, &settings); err != nil {
+			log.Printf("[FATAL] runSimulator - %s is not a JSON object: %s\n", *configFile, err.Error())
+			return 1
+		}
+	}
+
+	device, err := Simulator.CreateDevice(*deviceType, settings)
+	if err != nil {
+		log.Printf("[FATAL] runSimulator - %s\n", err.Error())
+		return 1
+	}
+
+	pty, err := Simulator.OpenPty()
+	if err != nil {
+		log.Printf("[FATAL] runSimulator - Unable to create a pseudo-terminal: %s\n", err.Error())
+		return 1
+	}
+	defer pty.Close()
+
+	portPath := pty.SlavePath
+	if *link != "" {
+		os.Remove(*link)
+		if err := os.Symlink(pty.SlavePath, *link); err != nil {
+			log.Printf("[FATAL] runSimulator - Unable to create link %s: %s\n", *link, err.Error())
+			return 1
+		}
+		defer os.Remove(*link)
+		portPath = *link
+	}
+
+	fmt.Printf("Simulating a %s device on %s\n", device.Name(), portPath)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.Printf("[INFO] runSimulator - OS signal %s received, stopping simulator\n", sig)
+		cancel()
+		//Unblocks the read of the device
+		pty.Master.Close()
+	}()
+
+	if err := device.Run(ctx, pty.Master); err != nil {
+		log.Printf("[ERROR] runSimulator - %s\n", err.Error())
+		return 1
+	}
+	return 0
+}
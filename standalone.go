@@ -0,0 +1,88 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"serialAdapter/GenericSerial"
+	"serialAdapter/PlatformClient"
+)
+
+//isStandalone : True when the adapter connects to a generic MQTT broker instead of the ClearBlade platform
+func isStandalone() bool {
+	return brokerURL != ""
+}
+
+//newStandaloneClient : Creates the client of the generic MQTT broker. The rows of the local
+//configuration file take the place of the adapter configuration collection.
+func newStandaloneClient() (PlatformClient.Client, error) {
+	log.Printf("[DEBUG] newStandaloneClient - Broker URL: %s\n", brokerURL)
+	log.Printf("[DEBUG] newStandaloneClient - Username: %s\n", mqttUsername)
+	log.Printf("[DEBUG] newStandaloneClient - Password: %s\n", GenericSerial.RedactSecret(mqttPassword))
+
+	collections := map[string][]map[string]interface{}{}
+	if configFile != "" {
+		rows, err := loadConfigFile(configFile)
+		if err != nil {
+			log.Printf("[ERROR] newStandaloneClient - Unable to load %s: %s\n", configFile, err.Error())
+			return nil, err
+		}
+		collections[adapterConfigCollection] = rows
+	} else {
+		log.Println("[INFO] newStandaloneClient - No configuration file specified, using default adapter settings")
+	}
+
+	return PlatformClient.NewMQTTClient(brokerURL, mqttUsername, mqttPassword, collections), nil
+}
+
+//loadConfigFile : Reads the adapter configuration rows from a local file. The file contains a
+//single row, whose adapter_name defaults to SerialPortAdapter, or an array of rows (ex. the adapter
+//configuration followed by payload transform scripts). adapter_settings may be given as an object
+//rather than the JSON string the collection column holds.
+//
+// {"topic_root": "serial", "adapter_settings": {"serialPortName": "/dev/ttyUSB0", "deviceProfile": "raw"}}
+func loadConfigFile(fileName string) ([]map[string]interface{}, error) {
+	contents, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	var config interface{}
+	if err := json.Unmarshal(contents, &config); err != nil {
+		return nil, fmt.Errorf("%s is not valid JSON: %s", fileName, err.Error())
+	}
+
+	rows := []map[string]interface{}{}
+	switch config := config.(type) {
+	case map[string]interface{}:
+		if _, ok := config["adapter_name"]; !ok {
+			config["adapter_name"] = adapterName
+		}
+		rows = append(rows, config)
+	case []interface{}:
+		for index, item := range config {
+			row, ok := item.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("Row %d of %s is not an object", index, fileName)
+			}
+			rows = append(rows, row)
+		}
+	default:
+		return nil, errors.New(fileName + " must contain an object or an array of objects")
+	}
+
+	for _, row := range rows {
+		if settings, ok := row["adapter_settings"].(map[string]interface{}); ok {
+			settingsJSON, err := json.Marshal(settings)
+			if err != nil {
+				return nil, err
+			}
+			row["adapter_settings"] = string(settingsJSON)
+		}
+	}
+
+	log.Printf("[INFO] loadConfigFile - Loaded %d configuration rows from %s\n", len(rows), fileName)
+	return rows, nil
+}
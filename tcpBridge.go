@@ -0,0 +1,555 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"serialAdapter/GenericSerial"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Protocols and access modes of the TCP serial server
+const (
+	tcpBridgeRaw     = "raw"
+	tcpBridgeRFC2217 = "rfc2217"
+
+	//The TCP client owns the serial port, the MQTT path, polling jobs and REST API are refused
+	tcpBridgeExclusive = "exclusive"
+	//The TCP client writes alongside the MQTT path and receives a copy of everything read
+	tcpBridgeShared = "shared"
+
+	//tcpBridgeBufferSize : Bytes read from the TCP client at once
+	tcpBridgeBufferSize = 1024
+
+	//tcpBridgeWriteTimeout : Seconds a write to the client may take before the session is ended
+	tcpBridgeWriteTimeout = 5
+
+	//tcpBridgeQueueSize : Reads queued for the client. A shared session whose client falls this
+	//far behind is ended, the read observer cannot wait for it.
+	tcpBridgeQueueSize = 64
+
+	//tcpBridgeReservedWait : Milliseconds a shared session waits before checking again whether the
+	//serial port is still reserved by another session
+	tcpBridgeReservedWait = 250
+
+	//tcpBridgeStopTimeout : Seconds to wait for the session to release the serial port when stopping
+	tcpBridgeStopTimeout = 5
+)
+
+//Serves the serial port over TCP, nil when the tcpServer adapter setting is absent
+var bridge *tcpBridge
+
+//tcpBridgeSettings : The "tcpServer" adapter setting
+//
+// "tcpServer": {"address": ":2217", "protocol": "rfc2217", "access": "exclusive"}
+type tcpBridgeSettings struct {
+	address  string
+	protocol string
+	access   string
+}
+
+//loadTCPBridgeSettings : Reads the "tcpServer" adapter setting, returns nil when it is absent
+func loadTCPBridgeSettings(adapterSettings map[string]interface{}) (*tcpBridgeSettings, error) {
+	serverJSON, ok := adapterSettings["tcpServer"].(map[string]interface{})
+	if !ok {
+		return nil, nil
+	}
+
+	settings := &tcpBridgeSettings{protocol: tcpBridgeRaw, access: tcpBridgeExclusive}
+	settings.address, _ = serverJSON["address"].(string)
+	if settings.address == "" {
+		return nil, errors.New("tcpServer address is required")
+	}
+	if protocol, ok := serverJSON["protocol"].(string); ok && protocol != "" {
+		settings.protocol = strings.ToLower(protocol)
+	}
+	if settings.protocol != tcpBridgeRaw && settings.protocol != tcpBridgeRFC2217 {
+		return nil, fmt.Errorf("Invalid tcpServer protocol %q, expected raw or rfc2217", settings.protocol)
+	}
+	if access, ok := serverJSON["access"].(string); ok && access != "" {
+		settings.access = strings.ToLower(access)
+	}
+	if settings.access != tcpBridgeExclusive && settings.access != tcpBridgeShared {
+		return nil, fmt.Errorf("Invalid tcpServer access %q, expected exclusive or shared", settings.access)
+	}
+
+	log.Printf("[INFO] loadTCPBridgeSettings - TCP serial server on %s, protocol = %s, access = %s\n", settings.address, settings.protocol, settings.access)
+	return settings, nil
+}
+
+//tcpBridge : Serves the serial port to one TCP client at a time (ser2net style)
+type tcpBridge struct {
+	settings tcpBridgeSettings
+	listener net.Listener
+
+	lock    sync.Mutex
+	session *tcpSession
+	stopped bool
+}
+
+//startTCPBridge : Listens for TCP clients of the serial port
+func startTCPBridge(settings tcpBridgeSettings) (*tcpBridge, error) {
+	listener, err := net.Listen("tcp", settings.address)
+	if err != nil {
+		log.Printf("[ERROR] startTCPBridge - Unable to listen on %s: %s\n", settings.address, err.Error())
+		return nil, err
+	}
+
+	server := &tcpBridge{settings: settings, listener: listener}
+	go server.accept()
+
+	log.Printf("[INFO] startTCPBridge - Serving serial port %s on %s\n", serialPortName, listener.Addr().String())
+	return server, nil
+}
+
+func (server *tcpBridge) accept() {
+	for {
+		conn, err := server.listener.Accept()
+		if err != nil {
+			server.lock.Lock()
+			stopped := server.stopped
+			server.lock.Unlock()
+			if !stopped {
+				log.Printf("[ERROR] tcpBridge - TCP serial server stopped: %s\n", err.Error())
+			}
+			return
+		}
+
+		server.lock.Lock()
+		if server.session != nil || server.stopped {
+			server.lock.Unlock()
+			log.Printf("[INFO] tcpBridge - Refusing %s, the serial port is in use by %s\n", conn.RemoteAddr().String(), server.Client())
+			conn.Write([]byte("Serial port is in use\r\n"))
+			conn.Close()
+			continue
+		}
+		session := newTCPSession(conn, server.settings)
+		server.session = session
+		server.lock.Unlock()
+
+		go func() {
+			session.run()
+
+			server.lock.Lock()
+			server.session = nil
+			server.lock.Unlock()
+		}()
+	}
+}
+
+//Stop : Stops accepting clients and ends the active session
+func (server *tcpBridge) Stop() {
+	if server == nil {
+		return
+	}
+
+	server.lock.Lock()
+	server.stopped = true
+	session := server.session
+	server.lock.Unlock()
+
+	server.listener.Close()
+	if session != nil {
+		session.close()
+		select {
+		case <-session.done:
+		case <-time.After(tcpBridgeStopTimeout * time.Second):
+			log.Println("[WARN] tcpBridge - Timed out waiting for the TCP session to end")
+		}
+	}
+}
+
+//Client : The address of the connected client, empty when no client is connected
+func (server *tcpBridge) Client() string {
+	server.lock.Lock()
+	defer server.lock.Unlock()
+
+	if server.session == nil {
+		return ""
+	}
+	return server.session.conn.RemoteAddr().String()
+}
+
+//Status : The status reported by the health topic
+func (server *tcpBridge) Status() map[string]interface{} {
+	return map[string]interface{}{
+		"address":  server.listener.Addr().String(),
+		"protocol": server.settings.protocol,
+		"access":   server.settings.access,
+		"client":   server.Client(),
+	}
+}
+
+//tcpSession : The connection of a TCP client to the serial port. The session implements
+//comPortControl for the RFC 2217 codec.
+type tcpSession struct {
+	conn      net.Conn
+	exclusive bool
+	codec     *telnetCodec //nil in raw mode
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	//Exclusive sessions own the serial port, their reads and writes do not wait for each other.
+	//Reopening the port with new line settings waits for the current read.
+	portLock sync.RWMutex
+
+	//The data and the negotiation replies written to the client by pumpOutgoing
+	outgoing chan []byte
+
+	//Last DTR and RTS states requested by the client, both are asserted when the port is opened
+	dtr bool
+	rts bool
+}
+
+func newTCPSession(conn net.Conn, settings tcpBridgeSettings) *tcpSession {
+	ctx, cancel := context.WithCancel(adapterContext)
+	session := &tcpSession{
+		conn:      conn,
+		exclusive: settings.access == tcpBridgeExclusive,
+		ctx:       ctx,
+		cancel:    cancel,
+		done:      make(chan struct{}),
+		outgoing:  make(chan []byte, tcpBridgeQueueSize),
+		dtr:       true,
+		rts:       true,
+	}
+	if settings.protocol == tcpBridgeRFC2217 {
+		session.codec = newTelnetCodec(session, session.reply)
+	}
+	return session
+}
+
+//run : Bridges the client and the serial port until either side fails or the session is closed
+func (session *tcpSession) run() {
+	defer close(session.done)
+
+	client := session.conn.RemoteAddr().String()
+	log.Printf("[INFO] tcpSession - %s connected\n", client)
+
+	var configured GenericSerial.LineSettings
+	if session.exclusive {
+		if err := reserveSerialPort("TCP client " + client); err != nil {
+			log.Printf("[INFO] tcpSession - Refusing %s: %s\n", client, err.Error())
+			session.conn.Write([]byte("Serial port is in use\r\n"))
+			session.close()
+			return
+		}
+		configured = serialPort.LineSettings()
+	} else {
+		serialPort.SetReadObserver(session.observe)
+	}
+
+	outgoingDone := make(chan struct{})
+	go func() {
+		defer close(outgoingDone)
+		session.pumpOutgoing()
+	}()
+
+	if session.codec != nil {
+		session.codec.Start()
+	}
+
+	pumpDone := make(chan struct{})
+	go func() {
+		defer close(pumpDone)
+		session.pumpSerial()
+	}()
+
+	session.pumpClient()
+	session.close()
+	<-pumpDone
+	<-outgoingDone
+
+	if session.exclusive {
+		serialPortLock.Lock()
+		if serialPort.LineSettings() != configured {
+			log.Printf("[INFO] tcpSession - Restoring serial port settings %s\n", configured.String())
+			if err := serialPort.SetLineSettings(configured); err != nil {
+				log.Printf("[ERROR] tcpSession - Unable to restore serial port settings: %s\n", err.Error())
+			}
+		}
+		if !session.dtr {
+			if err := serialPort.SetDTR(true); err != nil {
+				log.Printf("[WARN] tcpSession - Unable to assert DTR: %s\n", err.Error())
+			}
+		}
+		if !session.rts {
+			if err := serialPort.SetRTS(true); err != nil {
+				log.Printf("[WARN] tcpSession - Unable to assert RTS: %s\n", err.Error())
+			}
+		}
+		serialPortLock.Unlock()
+		releaseSerialPort()
+	} else {
+		serialPort.SetReadObserver(nil)
+	}
+
+	log.Printf("[INFO] tcpSession - %s disconnected\n", client)
+}
+
+//close : Ends the session, the pumps return once their current read completes
+func (session *tcpSession) close() {
+	session.cancel()
+	session.conn.Close()
+}
+
+//lockPort : Locks the serial port for a read or write. Shared sessions wait for the MQTT path and
+//are refused while another session has reserved the port.
+func (session *tcpSession) lockPort() (func(), error) {
+	if session.exclusive {
+		session.portLock.RLock()
+		return session.portLock.RUnlock, nil
+	}
+
+	serialPortLock.Lock()
+	if serialPortOwner != "" {
+		serialPortLock.Unlock()
+		return nil, errSerialPortReserved
+	}
+	return serialPortLock.Unlock, nil
+}
+
+//pumpClient : Writes the data received from the client to the serial port
+func (session *tcpSession) pumpClient() {
+	buffer := make([]byte, tcpBridgeBufferSize)
+	for {
+		n, err := session.conn.Read(buffer)
+		if err != nil {
+			if err != io.EOF && session.ctx.Err() == nil {
+				log.Printf("[WARN] tcpSession - Error reading from TCP client: %s\n", err.Error())
+			}
+			return
+		}
+
+		data := buffer[:n]
+		if session.codec != nil {
+			data = session.codec.Decode(data)
+		}
+		if len(data) == 0 {
+			continue
+		}
+
+		unlock, err := session.lockPort()
+		if err != nil {
+			log.Printf("[WARN] tcpSession - Discarding %d bytes: %s\n", len(data), err.Error())
+			continue
+		}
+		_, err = serialPort.WriteSerialPort(session.ctx, string(data))
+		unlock()
+		if err != nil {
+			log.Printf("[ERROR] tcpSession - Error writing to serial port: %s\n", err.Error())
+			return
+		}
+	}
+}
+
+//pumpSerial : Reads the serial port while the session is active. In exclusive mode the data is
+//sent to the client. In shared mode the read observer sends it and it is also published like
+//the data read by the read worker.
+func (session *tcpSession) pumpSerial() {
+	for session.ctx.Err() == nil {
+		unlock, err := session.lockPort()
+		if err != nil {
+			//The session that reserved the port reads it, its data reaches the read observer
+			select {
+			case <-time.After(tcpBridgeReservedWait * time.Millisecond):
+			case <-session.ctx.Done():
+			}
+			continue
+		}
+		data, err := serialPort.ReadSerialPort(session.ctx)
+		unlock()
+
+		if session.ctx.Err() != nil {
+			return
+		}
+		if err != nil && !strings.Contains(err.Error(), "EOF") {
+			log.Printf("[ERROR] tcpSession - Error reading from serial port: %s\n", err.Error())
+			session.close()
+			return
+		}
+
+		if session.exclusive {
+			if data != "" {
+				session.send([]byte(data))
+			}
+		} else {
+			publishSerialData(data)
+		}
+	}
+}
+
+//pumpOutgoing : Writes the queued data to the client, the session ends when the client cannot be written to
+func (session *tcpSession) pumpOutgoing() {
+	for {
+		select {
+		case data := <-session.outgoing:
+			session.conn.SetWriteDeadline(time.Now().Add(tcpBridgeWriteTimeout * time.Second))
+			if _, err := session.conn.Write(data); err != nil {
+				if session.ctx.Err() == nil {
+					log.Printf("[WARN] tcpSession - Error writing to TCP client: %s\n", err.Error())
+				}
+				session.close()
+				return
+			}
+		case <-session.ctx.Done():
+			return
+		}
+	}
+}
+
+//send : Queues data read from the serial port for the client
+func (session *tcpSession) send(data []byte) {
+	if session.codec != nil {
+		data = session.codec.Encode(data)
+	}
+	session.reply(data)
+}
+
+//reply : Queues data for the client, waiting while the queue is full
+func (session *tcpSession) reply(data []byte) {
+	select {
+	case session.outgoing <- data:
+	case <-session.ctx.Done():
+	}
+}
+
+//observe : The read observer of shared sessions. It is called while the serial port is locked,
+//so it never waits for the client: the session ends when the queue is full.
+func (session *tcpSession) observe(data []byte) {
+	if session.codec != nil {
+		data = session.codec.Encode(data)
+	}
+
+	select {
+	case session.outgoing <- data:
+	default:
+		if session.ctx.Err() == nil {
+			log.Printf("[WARN] tcpSession - %s is not keeping up with the serial port, ending the session\n", session.conn.RemoteAddr().String())
+		}
+		session.close()
+	}
+}
+
+//LineSettings : The current speed and character format of the serial port
+func (session *tcpSession) LineSettings() GenericSerial.LineSettings {
+	if !session.exclusive {
+		serialPortLock.Lock()
+		defer serialPortLock.Unlock()
+	}
+	return serialPort.LineSettings()
+}
+
+//SetLineSettings : Applies the settings requested by the client. Shared sessions cannot change the
+//settings the MQTT path depends on, the current settings are reported instead.
+func (session *tcpSession) SetLineSettings(settings GenericSerial.LineSettings) GenericSerial.LineSettings {
+	if !session.exclusive {
+		log.Printf("[INFO] tcpSession - Ignoring serial port settings %s, the session is shared\n", settings.String())
+		return session.LineSettings()
+	}
+
+	session.portLock.Lock()
+	defer session.portLock.Unlock()
+
+	if err := serialPort.SetLineSettings(settings); err != nil {
+		log.Printf("[WARN] tcpSession - Unable to apply serial port settings %s: %s\n", settings.String(), err.Error())
+		if !serialPort.IsOpen() {
+			session.close()
+		}
+	}
+	return serialPort.LineSettings()
+}
+
+//SetControl : Applies the flow control, break, DTR and RTS requests of exclusive sessions. The
+//state in effect is reported.
+func (session *tcpSession) SetControl(value byte) byte {
+	switch {
+	case value <= comPortControlLastInboundFlow && value >= comPortControlRequestInboundFlow:
+		return session.setFlowControl(value, comPortInboundFlow)
+	case value < comPortControlRequestBreak:
+		return session.setFlowControl(value, comPortFlow)
+	case value > comPortControlLastInboundFlow:
+		return value
+	case value >= comPortControlRequestRTS:
+		return session.setRTS(value)
+	}
+
+	switch value {
+	case comPortControlBreakOn:
+		if !session.exclusive {
+			return comPortControlBreakOff
+		}
+		if err := serialPort.SendBreak(); err != nil {
+			log.Printf("[WARN] tcpSession - Unable to send break: %s\n", err.Error())
+			return comPortControlBreakOff
+		}
+		return comPortControlBreakOn
+	case comPortControlDTROn, comPortControlDTROff:
+		asserted := value == comPortControlDTROn
+		if session.exclusive {
+			if err := serialPort.SetDTR(asserted); err != nil {
+				log.Printf("[WARN] tcpSession - Unable to change DTR: %s\n", err.Error())
+			} else {
+				session.dtr = asserted
+			}
+		}
+	case comPortControlRequestBreak, comPortControlBreakOff:
+		return comPortControlBreakOff
+	}
+
+	if session.dtr {
+		return comPortControlDTROn
+	}
+	return comPortControlDTROff
+}
+
+//setFlowControl : Applies a flow control request, returns the flow control in effect. DCD, DTR
+//and DSR flow control are not supported.
+func (session *tcpSession) setFlowControl(value byte, codes map[byte]string) byte {
+	if flow, ok := codes[value]; ok {
+		settings := session.LineSettings()
+		if settings.FlowControl != flow {
+			settings.FlowControl = flow
+			session.SetLineSettings(settings)
+		}
+	}
+	return comPortCode(codes, session.LineSettings().FlowControl)
+}
+
+//setRTS : Applies an RTS request, returns the RTS state in effect
+func (session *tcpSession) setRTS(value byte) byte {
+	if value != comPortControlRequestRTS && session.exclusive {
+		asserted := value == comPortControlRTSOn
+		if err := serialPort.SetRTS(asserted); err != nil {
+			log.Printf("[WARN] tcpSession - Unable to change RTS: %s\n", err.Error())
+		} else {
+			session.rts = asserted
+		}
+	}
+
+	if session.rts {
+		return comPortControlRTSOn
+	}
+	return comPortControlRTSOff
+}
+
+//Purge : Discards the data received by the serial port. The transmit buffer is not purged.
+func (session *tcpSession) Purge(value byte) {
+	if !session.exclusive {
+		return
+	}
+
+	session.portLock.RLock()
+	defer session.portLock.RUnlock()
+
+	if err := serialPort.FlushSerialPort(); err != nil {
+		log.Printf("[WARN] tcpSession - Unable to purge serial port: %s\n", err.Error())
+	}
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+)
+
+//loadTLSConfig : Builds the TLS configuration used for the platform REST and MQTT connections
+//from the command line flags. Returns nil when no TLS flags were specified, in which case
+//the connections use the system defaults.
+func loadTLSConfig() (*tls.Config, error) {
+	if caFile == "" && clientCertFile == "" && clientKeyFile == "" && tlsServerName == "" {
+		return nil, nil
+	}
+
+	log.Println("[INFO] loadTLSConfig - Loading TLS configuration")
+	tlsConfig := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		ServerName: tlsServerName,
+	}
+
+	//Private CA bundle used to verify the platform certificate
+	if caFile != "" {
+		log.Printf("[DEBUG] loadTLSConfig - Loading CA bundle %s\n", caFile)
+		caPem, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			log.Printf("[ERROR] loadTLSConfig - Unable to read CA bundle: %s\n", err.Error())
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(caPem) {
+			log.Printf("[ERROR] loadTLSConfig - No certificates found in CA bundle %s\n", caFile)
+			return nil, errors.New("No PEM certificates found in " + caFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	//Client certificate for mutual TLS
+	if clientCertFile != "" || clientKeyFile != "" {
+		if clientCertFile == "" || clientKeyFile == "" {
+			log.Println("[ERROR] loadTLSConfig - Both clientCertFile and clientKeyFile are required for mutual TLS")
+			return nil, errors.New("Both clientCertFile and clientKeyFile are required for mutual TLS")
+		}
+
+		log.Printf("[DEBUG] loadTLSConfig - Loading client certificate %s\n", clientCertFile)
+		cert, err := tls.LoadX509KeyPair(clientCertFile, clientKeyFile)
+		if err != nil {
+			log.Printf("[ERROR] loadTLSConfig - Unable to load client certificate: %s\n", err.Error())
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+//applyHTTPTLSConfig : The Go SDK issues its REST requests through the default HTTP transport,
+//so the TLS configuration is installed there for the authentication and collection requests
+func applyHTTPTLSConfig(tlsConfig *tls.Config) {
+	if tlsConfig == nil {
+		return
+	}
+
+	if !strings.HasPrefix(strings.ToLower(platformURL), "https://") {
+		log.Printf("[WARN] applyHTTPTLSConfig - TLS is configured but the platform URL %s does not use https\n", platformURL)
+	}
+
+	if transport, ok := http.DefaultTransport.(*http.Transport); ok {
+		transport.TLSClientConfig = tlsConfig
+	} else {
+		log.Println("[WARN] applyHTTPTLSConfig - Unable to apply TLS configuration to the default HTTP transport")
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+//defaultTopicTemplate : Produces {topic_root}/receive/request, {topic_root}/send/request, etc.
+const defaultTopicTemplate = "{root}/{direction}"
+
+//Topics the adapter subscribes to and publishes to, identified by the {direction} placeholder value
+var (
+	subscribeTopicNames = []string{readRequestTopicName, writeRequestTopicName, tunnelTxTopicName, tunnelSessionTopicName, controlRequestTopicName}
+	publishTopicNames   = []string{readResponseTopicName, writeResponseTopicName, readErrorTopicName, healthTopicName, tunnelRxTopicName, tunnelStatusTopicName, controlResponseTopicName, controlEventTopicName}
+)
+
+var topicPlaceholder = regexp.MustCompile(`\{[^}]*\}`)
+
+//adapterTopics : Resolved topic for each topic name
+var adapterTopics = map[string]string{}
+
+//topic : The resolved topic of the named topic
+func topic(name string) string {
+	return adapterTopics[name]
+}
+
+//resolveTopics : Builds every topic from the "topicTemplate" adapter setting and any per topic
+//overrides in "topicTemplates". Supported placeholders are {root}, {device}, {port} and {direction}.
+func resolveTopics(adapterSettings map[string]interface{}) (map[string]string, error) {
+	template := defaultTopicTemplate
+	if value, ok := adapterSettings["topicTemplate"].(string); ok && value != "" {
+		template = value
+	}
+
+	overrides := map[string]string{}
+	if value, ok := adapterSettings["topicTemplates"].(map[string]interface{}); ok {
+		for name, override := range value {
+			overrideTemplate, ok := override.(string)
+			if !ok || !isTopicName(name) {
+				return nil, fmt.Errorf("Invalid topicTemplates entry %s, expected one of %s", name, strings.Join(topicNames(), ", "))
+			}
+			overrides[name] = overrideTemplate
+		}
+	}
+
+	values := topicTemplateValues()
+
+	resolved := map[string]string{}
+	inUse := map[string]string{}
+	for _, name := range topicNames() {
+		nameTemplate := template
+		if override, ok := overrides[name]; ok {
+			nameTemplate = override
+		}
+
+		values["{direction}"] = name
+		resolvedTopic, err := expandTopicTemplate(nameTemplate, values)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid %s topic: %s", name, err.Error())
+		}
+
+		if other, ok := inUse[resolvedTopic]; ok {
+			return nil, fmt.Errorf("The %s and %s topics are both %s", other, name, resolvedTopic)
+		}
+		inUse[resolvedTopic] = name
+		resolved[name] = resolvedTopic
+	}
+
+	return resolved, nil
+}
+
+//topicTemplateValues : Values of the placeholders shared by every topic template
+func topicTemplateValues() map[string]string {
+	return map[string]string{
+		"{root}":   strings.Trim(topicRoot, "/"),
+		"{device}": deviceName,
+		"{port}":   filepath.Base(serialPortName),
+	}
+}
+
+//expandTopicTemplate : Replaces the placeholders in template and validates the resulting topic
+func expandTopicTemplate(template string, values map[string]string) (string, error) {
+	var expandErr error
+	expanded := topicPlaceholder.ReplaceAllStringFunc(template, func(placeholder string) string {
+		value, ok := values[placeholder]
+		if !ok {
+			expandErr = errors.New("unknown placeholder " + placeholder + " in " + template)
+			return placeholder
+		}
+		if value == "" || value == "." {
+			expandErr = errors.New("placeholder " + placeholder + " in " + template + " has no value")
+		}
+		return value
+	})
+	if expandErr != nil {
+		return "", expandErr
+	}
+
+	if err := validateTopic(expanded); err != nil {
+		return "", err
+	}
+	return expanded, nil
+}
+
+//validateTopic : Topics must be non-empty, free of wildcards and must not contain empty levels
+func validateTopic(topic string) error {
+	switch {
+	case topic == "":
+		return errors.New("topic is empty")
+	case len(topic) > 65535:
+		return errors.New("topic is longer than 65535 bytes")
+	case strings.ContainsAny(topic, "+#"):
+		return errors.New(topic + " contains an MQTT wildcard")
+	case strings.ContainsRune(topic, 0):
+		return errors.New(topic + " contains a null character")
+	case strings.HasPrefix(topic, "/") || strings.HasSuffix(topic, "/") || strings.Contains(topic, "//"):
+		return errors.New(topic + " contains an empty topic level")
+	}
+	return nil
+}
+
+func topicNames() []string {
+	return append(append([]string{}, subscribeTopicNames...), publishTopicNames...)
+}
+
+func isTopicName(name string) bool {
+	for _, topicName := range topicNames() {
+		if topicName == name {
+			return true
+		}
+	}
+	return false
+}
+
+//logTopics : Prints the final topics so wiring problems are obvious
+func logTopics() {
+	for _, name := range subscribeTopicNames {
+		log.Printf("[INFO] logTopics - Subscribing to %s: %s\n", name, topic(name))
+	}
+	for _, name := range publishTopicNames {
+		log.Printf("[INFO] logTopics - Publishing %s: %s\n", name, topic(name))
+	}
+}
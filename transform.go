@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"serialAdapter/PayloadTransform"
+	"strings"
+	"time"
+)
+
+//payloadTransform : Converts frames read from the serial device into JSON and JSON commands into
+//device bytes. nil when the "transform" adapter setting is not specified.
+var payloadTransform PayloadTransform.Transform
+
+//loadPayloadTransform : Creates the transform from the "transform" adapter setting
+//
+// "transform": {"type": "split", "separator": ";"}
+// "transform": {"type": "script", "scriptName": "thermometer"}
+//
+// Scripts named by scriptName are read from the script column of the adapter configuration
+// collection row whose adapter_name matches.
+func loadPayloadTransform(adapterSettings map[string]interface{}) (PayloadTransform.Transform, error) {
+	if adapterSettings["transform"] == nil {
+		log.Println("[INFO] loadPayloadTransform - No payload transform configured, data is published unchanged")
+		return nil, nil
+	}
+
+	transformJSON, ok := adapterSettings["transform"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("transform must be an object")
+	}
+
+	transformType, ok := transformJSON["type"].(string)
+	if !ok || transformType == "" {
+		return nil, errors.New("transform requires a type, available transforms: " + strings.Join(PayloadTransform.TransformNames(), ", "))
+	}
+
+	settings := make(map[string]interface{}, len(transformJSON)+1)
+	for key, value := range transformJSON {
+		settings[key] = value
+	}
+
+	if scriptName, ok := transformJSON["scriptName"].(string); ok && scriptName != "" {
+		script, err := getTransformScript(scriptName)
+		if err != nil {
+			return nil, err
+		}
+		settings["script"] = script
+	}
+
+	return PayloadTransform.CreateTransform(transformType, settings)
+}
+
+//getTransformScript : Retrieves a payload transform script from the adapter configuration collection
+func getTransformScript(name string) (string, error) {
+	log.Printf("[INFO] getTransformScript - Retrieving payload transform script %s\n", name)
+
+	rows, err := queryAdapterConfig(map[string]interface{}{"adapter_name": name})
+	if err != nil {
+		log.Printf("[ERROR] getTransformScript - Error retrieving script %s: %s\n", name, err.Error())
+		return "", err
+	}
+
+	if len(rows) == 0 {
+		return "", errors.New("No row with adapter_name " + name + " in " + adapterConfigCollection)
+	}
+
+	script, ok := rows[0]["script"].(string)
+	if !ok || script == "" {
+		return "", errors.New("The script column of " + name + " is empty")
+	}
+	return script, nil
+}
+
+//decodeError : Published to the receive/error topic for frames the payload transform rejects
+type decodeError struct {
+	Transform string `json:"transform"`
+	Job       string `json:"job,omitempty"`
+	Frame     string `json:"frame"`
+	Error     string `json:"error"`
+	Timestamp string `json:"timestamp"`
+}
+
+//decodeFrame : Decodes a frame read from the serial port, or by the named polling job, with the
+//payload transform. Frames that cannot be decoded are published to the receive/error topic.
+func decodeFrame(frame string, job string) (interface{}, bool) {
+	decoded, err := payloadTransform.Decode(frame)
+	if err == nil {
+		return decoded, true
+	}
+
+	log.Printf("[WARN] decodeFrame - Unable to decode frame %q: %s\n", frame, err.Error())
+
+	payload, marshalErr := json.Marshal(decodeError{
+		Transform: payloadTransform.Name(),
+		Job:       job,
+		Frame:     frame,
+		Error:     err.Error(),
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	})
+	if marshalErr != nil {
+		log.Printf("[ERROR] decodeFrame - ERROR marshalling decode error: %s\n", marshalErr.Error())
+		return nil, false
+	}
+
+	//Polling jobs run while disconnected, so their errors are held in the outbox
+	if job != "" {
+		outbox.Publish(topic(readErrorTopicName), string(payload), mqttConfig.options(readErrorTopicName))
+	} else if err := publish(topic(readErrorTopicName), string(payload), mqttConfig.options(readErrorTopicName)); err != nil {
+		log.Printf("[ERROR] decodeFrame - ERROR publishing to topic: %s\n", err.Error())
+	}
+	return nil, false
+}
+
+//encodeWriteCommand : Replaces the command of a write request with the bytes produced by the
+//transform. Plain JSON payloads are written unchanged when the transform cannot encode them.
+func encodeWriteCommand(request *writeRequest) error {
+	if request.command == nil {
+		return nil
+	}
+
+	//An envelope command has no payload to fall back on
+	explicit := request.payload == ""
+
+	if payloadTransform == nil {
+		if explicit {
+			return errors.New("Commands require a payload transform, see the transform adapter setting")
+		}
+		return nil
+	}
+
+	encoded, err := payloadTransform.Encode(request.command)
+	if err != nil {
+		if err == PayloadTransform.ErrEncodeNotSupported && !explicit {
+			return nil
+		}
+		log.Printf("[WARN] encodeWriteCommand - Unable to encode command: %s\n", err.Error())
+		return err
+	}
+
+	log.Printf("[DEBUG] encodeWriteCommand - Command encoded as %q\n", encoded)
+	request.payload = encoded
+	return nil
+}
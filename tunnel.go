@@ -0,0 +1,338 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+//Actions of the tunnel/session topic
+const (
+	tunnelOpen      = "open"
+	tunnelClose     = "close"
+	tunnelKeepAlive = "keepalive"
+)
+
+//States published to the tunnel/status topic
+const (
+	tunnelStateOpen    = "open"
+	tunnelStateClosed  = "closed"
+	tunnelStateRefused = "refused"
+)
+
+const (
+	//defaultTunnelIdleTimeout : Seconds without data or keepalive from the client before the session is closed
+	defaultTunnelIdleTimeout = 300
+
+	//defaultTunnelMaxIdleTimeout : Longest idle timeout a client may request, in seconds
+	defaultTunnelMaxIdleTimeout = 3600
+
+	//tunnelStopTimeout : Seconds to wait for the session to release the serial port when stopping
+	tunnelStopTimeout = 5
+)
+
+//tunnelRequest : A message of the tunnel/session topic
+//
+// {"action": "open", "client": "laptop-1", "idleTimeout": 300}
+type tunnelRequest struct {
+	Action      string `json:"action"`
+	Client      string `json:"client"`
+	IdleTimeout int    `json:"idleTimeout"`
+}
+
+//tunnelStatus : A message of the tunnel/status topic
+type tunnelStatus struct {
+	State     string `json:"state"`
+	Client    string `json:"client,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+	Timestamp string `json:"timestamp"`
+}
+
+//serialTunnel : Bridges the serial port and the tunnel topics for one client at a time. While the
+//session is open the tunnel reserves the serial port, pausing the read and write requests and the
+//polling jobs. Bytes published to tunnel/tx are written unchanged and every read is published to
+//tunnel/rx as soon as it completes.
+type serialTunnel struct {
+	//Upper bound of the idle timeout requested by the client
+	maxIdleTimeout time.Duration
+
+	lock     sync.Mutex
+	client   string
+	opened   time.Time
+	activity time.Time
+	reason   string //Why the session is being closed
+	ctx      context.Context
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+//The MQTT tunnel, driven by the subscribeWorker
+var tunnel = &serialTunnel{maxIdleTimeout: defaultTunnelMaxIdleTimeout * time.Second}
+
+//loadTunnelMaxIdleTimeout : The maxIdleTimeout of the "tunnel" adapter setting
+//
+//	"tunnel": {"maxIdleTimeout": 3600}
+func loadTunnelMaxIdleTimeout(adapterSettings map[string]interface{}) (time.Duration, error) {
+	maxIdleTimeout := time.Duration(defaultTunnelMaxIdleTimeout) * time.Second
+
+	config, ok := adapterSettings["tunnel"].(map[string]interface{})
+	if !ok {
+		return maxIdleTimeout, nil
+	}
+	if value, ok := config["maxIdleTimeout"].(float64); ok {
+		if value <= 0 {
+			return 0, errors.New("maxIdleTimeout must be a positive number of seconds")
+		}
+		maxIdleTimeout = time.Duration(value) * time.Second
+	}
+	return maxIdleTimeout, nil
+}
+
+//HandleSession : Opens, closes or keeps alive the session named by a tunnel/session message
+func (tunnel *serialTunnel) HandleSession(payload []byte) {
+	request := tunnelRequest{}
+	if err := json.Unmarshal(payload, &request); err != nil {
+		log.Printf("[WARN] serialTunnel - Invalid tunnel session request: %s\n", err.Error())
+		publishTunnelStatus(tunnelStatus{State: tunnelStateRefused, Reason: "Invalid tunnel session request: " + err.Error()})
+		return
+	}
+	if request.Client == "" {
+		publishTunnelStatus(tunnelStatus{State: tunnelStateRefused, Reason: "client is required"})
+		return
+	}
+
+	switch strings.ToLower(request.Action) {
+	case tunnelOpen:
+		tunnel.open(request)
+	case tunnelClose:
+		if !tunnel.close(request.Client, "Closed by the client") {
+			publishTunnelStatus(tunnelStatus{State: tunnelStateClosed, Client: request.Client})
+		}
+	case tunnelKeepAlive:
+		if !tunnel.touch(request.Client) {
+			publishTunnelStatus(tunnelStatus{State: tunnelStateClosed, Client: request.Client, Reason: "No tunnel session is open"})
+		}
+	default:
+		publishTunnelStatus(tunnelStatus{State: tunnelStateRefused, Client: request.Client, Reason: "Unknown action " + request.Action + ", expected open, close or keepalive"})
+	}
+}
+
+func (tunnel *serialTunnel) open(request tunnelRequest) {
+	tunnel.lock.Lock()
+	current := tunnel.client
+	if current == request.Client {
+		tunnel.activity = time.Now()
+	}
+	tunnel.lock.Unlock()
+
+	//Opening an open session again is answered with its status so a client can retry the request
+	if current == request.Client {
+		publishTunnelStatus(tunnelStatus{State: tunnelStateOpen, Client: request.Client})
+		return
+	}
+	if current != "" {
+		publishTunnelStatus(tunnelStatus{State: tunnelStateRefused, Client: request.Client, Reason: "The tunnel is in use by " + current})
+		return
+	}
+
+	if err := reserveSerialPort("MQTT tunnel client " + request.Client); err != nil {
+		log.Printf("[INFO] serialTunnel - Refusing tunnel client %s: %s\n", request.Client, err.Error())
+		publishTunnelStatus(tunnelStatus{State: tunnelStateRefused, Client: request.Client, Reason: err.Error()})
+		return
+	}
+
+	idleTimeout := time.Duration(defaultTunnelIdleTimeout) * time.Second
+	if request.IdleTimeout > 0 {
+		idleTimeout = time.Duration(request.IdleTimeout) * time.Second
+	}
+	//A client cannot hold the serial port indefinitely
+	if idleTimeout > tunnel.maxIdleTimeout {
+		log.Printf("[INFO] serialTunnel - Limiting the idle timeout of %s to %s\n", request.Client, tunnel.maxIdleTimeout)
+		idleTimeout = tunnel.maxIdleTimeout
+	}
+
+	ctx, cancel := context.WithCancel(adapterContext)
+	done := make(chan struct{})
+
+	tunnel.lock.Lock()
+	tunnel.client = request.Client
+	tunnel.opened = time.Now()
+	tunnel.activity = tunnel.opened
+	tunnel.reason = ""
+	tunnel.ctx = ctx
+	tunnel.cancel = cancel
+	tunnel.done = done
+	tunnel.lock.Unlock()
+
+	log.Printf("[INFO] serialTunnel - Tunnel opened by %s, idle timeout %s\n", request.Client, idleTimeout)
+	publishTunnelStatus(tunnelStatus{State: tunnelStateOpen, Client: request.Client})
+
+	go func() {
+		reason := tunnel.pump(ctx, idleTimeout)
+
+		tunnel.lock.Lock()
+		client := tunnel.client
+		if tunnel.reason != "" {
+			reason = tunnel.reason
+		}
+		tunnel.client = ""
+		tunnel.cancel = nil
+		tunnel.done = nil
+		tunnel.lock.Unlock()
+		cancel()
+
+		releaseSerialPort()
+		log.Printf("[INFO] serialTunnel - Tunnel of %s closed: %s\n", client, reason)
+		publishTunnelStatus(tunnelStatus{State: tunnelStateClosed, Client: client, Reason: reason})
+		close(done)
+	}()
+}
+
+//pump : Publishes the data read from the serial port until the session is closed or idle. The
+//tunnel owns the reserved serial port, reads and writes do not take serialPortLock so the data of
+//the client is not held back by a read in progress. Returns why the session ended.
+func (tunnel *serialTunnel) pump(ctx context.Context, idleTimeout time.Duration) string {
+	for {
+		if ctx.Err() != nil {
+			return "The adapter is stopping"
+		}
+		if tunnel.idle() > idleTimeout {
+			return "No data or keepalive received for " + idleTimeout.String()
+		}
+
+		data, err := serialPort.ReadSerialPort(ctx)
+		if ctx.Err() != nil {
+			continue
+		}
+		if err != nil && !strings.Contains(err.Error(), "EOF") {
+			return "Error reading from serial port: " + err.Error()
+		}
+		if data == "" {
+			continue
+		}
+
+		if err := publish(topic(tunnelRxTopicName), data, mqttConfig.options(tunnelRxTopicName)); err != nil {
+			log.Printf("[ERROR] serialTunnel - ERROR publishing %d bytes to the tunnel: %s\n", len(data), err.Error())
+		}
+	}
+}
+
+//Write : Writes the data of a tunnel/tx message to the serial port
+func (tunnel *serialTunnel) Write(data []byte) {
+	tunnel.lock.Lock()
+	client := tunnel.client
+	ctx := tunnel.ctx
+	tunnel.activity = time.Now()
+	tunnel.lock.Unlock()
+
+	if client == "" {
+		log.Printf("[WARN] serialTunnel - No tunnel session is open, discarding %d bytes\n", len(data))
+		publishTunnelStatus(tunnelStatus{State: tunnelStateClosed, Reason: "No tunnel session is open"})
+		return
+	}
+	if len(data) == 0 {
+		return
+	}
+
+	if _, err := serialPort.WriteSerialPort(ctx, string(data)); err != nil {
+		log.Printf("[ERROR] serialTunnel - ERROR writing tunnel data to serial port: %s\n", err.Error())
+	}
+}
+
+//close : Closes the session of client and waits for the serial port to be released. Returns false
+//if client has no open session.
+func (tunnel *serialTunnel) close(client string, reason string) bool {
+	tunnel.lock.Lock()
+	if tunnel.client == "" || tunnel.client != client {
+		tunnel.lock.Unlock()
+		return false
+	}
+	tunnel.reason = reason
+	tunnel.cancel()
+	done := tunnel.done
+	tunnel.lock.Unlock()
+
+	<-done
+	return true
+}
+
+//touch : Records activity of client, returns false if client has no open session
+func (tunnel *serialTunnel) touch(client string) bool {
+	tunnel.lock.Lock()
+	defer tunnel.lock.Unlock()
+
+	if tunnel.client == "" || tunnel.client != client {
+		return false
+	}
+	tunnel.activity = time.Now()
+	return true
+}
+
+//idle : Time since the last data or keepalive from the client
+func (tunnel *serialTunnel) idle() time.Duration {
+	tunnel.lock.Lock()
+	defer tunnel.lock.Unlock()
+
+	return time.Since(tunnel.activity)
+}
+
+//Stop : Closes the open session when the adapter is stopped
+func (tunnel *serialTunnel) Stop() {
+	tunnel.CloseSession("The adapter is stopping")
+}
+
+//CloseSession : Closes the open session, if any, for reason. Waits at most tunnelStopTimeout for
+//the serial port to be released.
+func (tunnel *serialTunnel) CloseSession(reason string) {
+	tunnel.lock.Lock()
+	client := tunnel.client
+	tunnel.lock.Unlock()
+	if client == "" {
+		return
+	}
+
+	closed := make(chan struct{})
+	go func() {
+		tunnel.close(client, reason)
+		close(closed)
+	}()
+
+	select {
+	case <-closed:
+	case <-time.After(tunnelStopTimeout * time.Second):
+		log.Println("[WARN] serialTunnel - Timed out waiting for the tunnel session to end")
+	}
+}
+
+//Status : The status reported by the health topic
+func (tunnel *serialTunnel) Status() map[string]interface{} {
+	tunnel.lock.Lock()
+	defer tunnel.lock.Unlock()
+
+	if tunnel.client == "" {
+		return map[string]interface{}{"state": tunnelStateClosed}
+	}
+	return map[string]interface{}{
+		"state":  tunnelStateOpen,
+		"client": tunnel.client,
+		"opened": tunnel.opened.UTC().Format(time.RFC3339),
+	}
+}
+
+//publishTunnelStatus : Reports the state of the tunnel session to the tunnel/status topic
+func publishTunnelStatus(status tunnelStatus) {
+	status.Timestamp = time.Now().UTC().Format(time.RFC3339)
+	payload, err := json.Marshal(status)
+	if err != nil {
+		log.Printf("[ERROR] publishTunnelStatus - ERROR marshalling tunnel status: %s\n", err.Error())
+		return
+	}
+
+	if err := publish(topic(tunnelStatusTopicName), string(payload), mqttConfig.options(tunnelStatusTopicName)); err != nil {
+		log.Printf("[ERROR] publishTunnelStatus - ERROR publishing to topic: %s\n", err.Error())
+	}
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net"
+	"os"
+	"os/signal"
+	"serialAdapter/PlatformClient"
+	"serialAdapter/Simulator"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	mqttTypes "github.com/clearblade/mqtt_parsing"
+	"github.com/hashicorp/logutils"
+)
+
+const (
+	//tunnelCommand : First argument that runs the tunnel client instead of the adapter
+	tunnelCommand = "tunnel"
+
+	//tunnelOpenTimeout : Seconds to wait for the adapter to answer a session request
+	tunnelOpenTimeout = 10
+)
+
+//tunnelClient : The engineer's end of the MQTT tunnel. Bytes written to the local pseudo-terminal
+//(or TCP connection) are published to tunnel/tx and tunnel/rx is written back to it.
+type tunnelClient struct {
+	platform    PlatformClient.Client
+	root        string
+	name        string
+	idleTimeout int
+
+	lock    sync.Mutex
+	local   io.Writer //Current local connection, nil while no TCP client is connected
+	closing bool      //Set once the client has asked the adapter to close the session
+}
+
+//runTunnel : Bridges a local pseudo-terminal to the serial port of a remote adapter until SIGINT or SIGTERM
+//
+//	serialAdapter tunnel -brokerURL=tcp://broker:1883 -topicRoot=serial -link=/tmp/ttyREMOTE
+//
+// The configuration software then opens the printed pseudo-terminal path or the link. With -listen
+// the tunnel is served on a local TCP port instead, for platforms without pseudo-terminals.
+func runTunnel(args []string) int {
+	flags := flag.NewFlagSet(tunnelCommand, flag.ExitOnError)
+	broker := flags.String("brokerURL", "", "Generic MQTT broker the adapter is connected to (ex. tcp://broker:1883)")
+	username := flags.String("mqttUsername", "", "Username used to connect to the generic MQTT broker (optional)")
+	password := flags.String("mqttPassword", "", "Password used to connect to the generic MQTT broker (optional)")
+	platform := flags.String("platformURL", platURL, "ClearBlade platform url, used when brokerURL is not specified")
+	messaging := flags.String("messagingURL", messURL, "ClearBlade messaging URL, used when brokerURL is not specified")
+	systemKey := flags.String("systemKey", "", "ClearBlade system key, used when brokerURL is not specified")
+	systemSecret := flags.String("systemSecret", "", "ClearBlade system secret, used when brokerURL is not specified")
+	device := flags.String("deviceName", "", "ClearBlade device used to authenticate, used when brokerURL is not specified")
+	devicePassword := flags.String("password", "", "Password (or active key) of the ClearBlade device, used when brokerURL is not specified")
+	root := flags.String("topicRoot", "serial", "The topic prefix of the adapter's tunnel topics, {topicRoot}/tunnel/tx etc.")
+	hostname, _ := os.Hostname()
+	name := flags.String("client", hostname, "The name reported to the adapter and shown in its health status")
+	idleTimeout := flags.Int("idleTimeout", defaultTunnelIdleTimeout, "Seconds without keepalive after which the adapter closes the session")
+	link := flags.String("link", "", "Symbolic link created to the pseudo-terminal so the software can use a stable path (optional)")
+	listen := flags.String("listen", "", "Serves the tunnel on this local TCP address (ex. 127.0.0.1:7000) instead of a pseudo-terminal (optional)")
+	level := flags.String("logLevel", "info", "The level of logging to use. Available levels are 'debug, 'info', 'warn', 'error', 'fatal' (optional)")
+	flags.Usage = func() {
+		log.Printf("Usage: serialAdapter %s [options]\n\n", tunnelCommand)
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	log.SetFlags(log.LstdFlags | log.Lshortfile)
+	log.SetOutput(&logutils.LevelFilter{
+		Levels:   []logutils.LogLevel{"DEBUG", "INFO", "WARN", "ERROR", "FATAL"},
+		MinLevel: logutils.LogLevel(strings.ToUpper(*level)),
+		Writer:   os.Stdout,
+	})
+
+	if *name == "" || *idleTimeout <= 0 {
+		log.Println("[FATAL] runTunnel - client is required and idleTimeout must be positive")
+		return 1
+	}
+
+	var platformClient PlatformClient.Client
+	if *broker != "" {
+		platformClient = PlatformClient.NewMQTTClient(*broker, *username, *password, nil)
+	} else if *systemKey != "" && *systemSecret != "" && *device != "" && *devicePassword != "" {
+		platformClient = PlatformClient.NewClearBladeClient(*platform, *messaging, *systemKey, *systemSecret, *device, *devicePassword)
+	} else {
+		log.Println("[FATAL] runTunnel - brokerURL, or systemKey, systemSecret, deviceName and password are required")
+		flags.Usage()
+		return 1
+	}
+
+	client := &tunnelClient{platform: platformClient, root: strings.Trim(*root, "/"), name: *name, idleTimeout: *idleTimeout}
+	if err := client.run(*link, *listen); err != nil {
+		log.Printf("[FATAL] runTunnel - %s\n", err.Error())
+		return 1
+	}
+	return 0
+}
+
+func (client *tunnelClient) run(link string, listen string) error {
+	if err := client.platform.Authenticate(); err != nil {
+		return errors.New("Unable to authenticate: " + err.Error())
+	}
+
+	//Subscriptions are not restored after a reconnect, the tunnel ends instead
+	lost := make(chan error, 1)
+	rand.Seed(time.Now().UnixNano())
+	clientID := "tunnel-" + client.name + "-" + strconv.Itoa(rand.Intn(10000))
+	err := client.platform.ConnectMQTT(clientID, nil, true, PlatformClient.Callbacks{
+		OnConnectLost: func(err error) {
+			select {
+			case lost <- err:
+			default:
+			}
+		},
+	})
+	if err != nil {
+		return errors.New("Unable to connect: " + err.Error())
+	}
+	defer client.platform.Disconnect()
+
+	received, err := client.platform.Subscribe(client.topic(tunnelRxTopicName), msgSubscribeQos)
+	if err != nil {
+		return err
+	}
+	statuses, err := client.platform.Subscribe(client.topic(tunnelStatusTopicName), msgSubscribeQos)
+	if err != nil {
+		return err
+	}
+
+	if err := client.open(statuses); err != nil {
+		return err
+	}
+	defer client.close()
+
+	failed := make(chan error, 1)
+	if listen != "" {
+		listener, err := net.Listen("tcp", listen)
+		if err != nil {
+			return err
+		}
+		defer listener.Close()
+		fmt.Printf("Tunnel to %s served on %s\n", client.root, listener.Addr().String())
+		go client.accept(listener)
+	} else {
+		pty, err := Simulator.OpenPty()
+		if err != nil {
+			return errors.New("Unable to create a pseudo-terminal: " + err.Error())
+		}
+		defer pty.Close()
+
+		portPath := pty.SlavePath
+		if link != "" {
+			os.Remove(link)
+			if err := os.Symlink(pty.SlavePath, link); err != nil {
+				return errors.New("Unable to create link " + link + ": " + err.Error())
+			}
+			defer os.Remove(link)
+			portPath = link
+		}
+		fmt.Printf("Tunnel to %s available on %s\n", client.root, portPath)
+
+		client.setLocal(pty.Master)
+		go func() {
+			failed <- client.forward(pty.Master)
+		}()
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	keepAlive := time.NewTicker(time.Duration(client.idleTimeout) * time.Second / 3)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case message := <-received:
+			client.writeLocal(message.Payload)
+		case message := <-statuses:
+			if err := client.handleStatus(message); err != nil {
+				return err
+			}
+		case <-keepAlive.C:
+			client.request(tunnelKeepAlive)
+		case err := <-failed:
+			return errors.New("Error reading the pseudo-terminal: " + err.Error())
+		case err := <-lost:
+			return errors.New("Connection lost: " + err.Error())
+		case sig := <-signals:
+			log.Printf("[INFO] runTunnel - OS signal %s received, closing the tunnel\n", sig)
+			return nil
+		}
+	}
+}
+
+//open : Requests the session and waits for the adapter to open it
+func (client *tunnelClient) open(statuses <-chan *mqttTypes.Publish) error {
+	if err := client.request(tunnelOpen); err != nil {
+		return err
+	}
+
+	timeout := time.After(tunnelOpenTimeout * time.Second)
+	for {
+		select {
+		case message := <-statuses:
+			status := tunnelStatus{}
+			if json.Unmarshal(message.Payload, &status) != nil || status.Client != client.name {
+				continue
+			}
+			switch status.State {
+			case tunnelStateOpen:
+				log.Printf("[INFO] tunnelClient - Tunnel to %s opened\n", client.root)
+				return nil
+			case tunnelStateRefused:
+				return errors.New("The adapter refused the tunnel: " + status.Reason)
+			}
+		case <-timeout:
+			return errors.New("The adapter did not answer, check topicRoot and that the adapter is connected")
+		}
+	}
+}
+
+//handleStatus : Opens the session again when the adapter closed it (ex. the adapter was restarted)
+func (client *tunnelClient) handleStatus(message *mqttTypes.Publish) error {
+	status := tunnelStatus{}
+	if err := json.Unmarshal(message.Payload, &status); err != nil {
+		return nil
+	}
+	if status.Client != "" && status.Client != client.name {
+		return nil
+	}
+
+	client.lock.Lock()
+	closing := client.closing
+	client.lock.Unlock()
+	if closing {
+		return nil
+	}
+
+	switch status.State {
+	case tunnelStateClosed:
+		log.Printf("[WARN] tunnelClient - The adapter closed the tunnel (%s), opening it again\n", status.Reason)
+		return client.request(tunnelOpen)
+	case tunnelStateRefused:
+		return errors.New("The adapter refused the tunnel: " + status.Reason)
+	}
+	return nil
+}
+
+//close : Asks the adapter to close the session
+func (client *tunnelClient) close() {
+	client.lock.Lock()
+	client.closing = true
+	client.lock.Unlock()
+
+	if err := client.request(tunnelClose); err != nil {
+		log.Printf("[WARN] tunnelClient - Unable to close the tunnel: %s\n", err.Error())
+	}
+}
+
+//request : Publishes a session request to the adapter
+func (client *tunnelClient) request(action string) error {
+	payload, _ := json.Marshal(tunnelRequest{Action: action, Client: client.name, IdleTimeout: client.idleTimeout})
+	return client.platform.Publish(client.topic(tunnelSessionTopicName), payload, msgPublishQos, false)
+}
+
+//accept : Serves one local TCP connection at a time
+func (client *tunnelClient) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		log.Printf("[INFO] tunnelClient - %s connected\n", conn.RemoteAddr().String())
+		client.setLocal(conn)
+		if err := client.forward(conn); err != nil && err != io.EOF {
+			log.Printf("[WARN] tunnelClient - %s\n", err.Error())
+		}
+		client.setLocal(nil)
+		conn.Close()
+		log.Printf("[INFO] tunnelClient - %s disconnected\n", conn.RemoteAddr().String())
+	}
+}
+
+//forward : Publishes the data read from the local connection until the connection fails
+func (client *tunnelClient) forward(reader io.Reader) error {
+	buffer := make([]byte, tcpBridgeBufferSize)
+	for {
+		n, err := reader.Read(buffer)
+		if n > 0 {
+			if publishErr := client.platform.Publish(client.topic(tunnelTxTopicName), append([]byte(nil), buffer[:n]...), msgPublishQos, false); publishErr != nil {
+				log.Printf("[ERROR] tunnelClient - ERROR publishing %d bytes: %s\n", n, publishErr.Error())
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (client *tunnelClient) setLocal(local io.Writer) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	client.local = local
+}
+
+//writeLocal : Writes the data received from the serial port to the local connection
+func (client *tunnelClient) writeLocal(data []byte) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+
+	if client.local == nil {
+		log.Printf("[DEBUG] tunnelClient - No local connection, discarding %d bytes\n", len(data))
+		return
+	}
+	if _, err := client.local.Write(data); err != nil {
+		log.Printf("[WARN] tunnelClient - Error writing to the local connection: %s\n", err.Error())
+	}
+}
+
+//topic : The tunnel topic of the adapter with the default topic template, {topicRoot}/{direction}
+func (client *tunnelClient) topic(name string) string {
+	return client.root + "/" + name
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+//workerState : Lifecycle state of the workers that run while the adapter is connected to the broker
+type workerState int
+
+const (
+	workersStopped workerState = iota
+	workersStarting
+	workersRunning
+	workersStopping
+)
+
+var errWorkersStopTimeout = errors.New("Timed out waiting for workers to stop")
+
+func (state workerState) String() string {
+	switch state {
+	case workersStopped:
+		return "stopped"
+	case workersStarting:
+		return "starting"
+	case workersRunning:
+		return "running"
+	case workersStopping:
+		return "stopping"
+	}
+	return "unknown"
+}
+
+//supervisedWorker : A long running function that must return once its context is done
+type supervisedWorker struct {
+	name string
+	run  func(ctx context.Context)
+}
+
+//workerSupervisor : Starts and stops a set of workers as a unit. Start and Stop are idempotent
+//and serialized, so repeated connect and disconnect callbacks can neither start duplicate
+//workers nor block on workers that have already exited.
+type workerSupervisor struct {
+	//Serializes Start and Stop
+	transitionLock sync.Mutex
+
+	stateLock sync.Mutex
+	state     workerState
+	cancel    context.CancelFunc
+
+	//Closed once every worker of the current generation has returned
+	done chan struct{}
+
+	workers []supervisedWorker
+}
+
+func newWorkerSupervisor(workers ...supervisedWorker) *workerSupervisor {
+	return &workerSupervisor{state: workersStopped, workers: workers}
+}
+
+//State : The current lifecycle state
+func (supervisor *workerSupervisor) State() workerState {
+	supervisor.stateLock.Lock()
+	defer supervisor.stateLock.Unlock()
+	return supervisor.state
+}
+
+func (supervisor *workerSupervisor) setState(state workerState) {
+	supervisor.stateLock.Lock()
+	defer supervisor.stateLock.Unlock()
+
+	if supervisor.state != state {
+		log.Printf("[DEBUG] workerSupervisor - Workers %s -> %s\n", supervisor.state, state)
+		supervisor.state = state
+	}
+}
+
+//Start : Starts the workers with a context derived from parent. Returns false if the workers
+//were already running. If a previous generation is still stopping, Start waits for it to exit
+//or for parent to be done. Stop is not blocked while Start waits.
+func (supervisor *workerSupervisor) Start(parent context.Context) bool {
+	supervisor.transitionLock.Lock()
+	defer supervisor.transitionLock.Unlock()
+
+	for supervisor.State() == workersStopping {
+		supervisor.stateLock.Lock()
+		done := supervisor.done
+		supervisor.stateLock.Unlock()
+
+		log.Println("[INFO] workerSupervisor.Start - Waiting for previous workers to exit")
+		supervisor.transitionLock.Unlock()
+		select {
+		case <-done:
+		case <-parent.Done():
+		}
+		supervisor.transitionLock.Lock()
+
+		if parent.Err() != nil {
+			break
+		}
+	}
+
+	switch supervisor.State() {
+	case workersRunning, workersStarting:
+		log.Println("[DEBUG] workerSupervisor.Start - Workers already running")
+		return false
+	case workersStopping:
+		log.Println("[INFO] workerSupervisor.Start - Parent context is done, not starting workers")
+		return false
+	}
+
+	if parent.Err() != nil {
+		log.Println("[INFO] workerSupervisor.Start - Parent context is done, not starting workers")
+		return false
+	}
+
+	supervisor.setState(workersStarting)
+
+	ctx, cancel := context.WithCancel(parent)
+	done := make(chan struct{})
+	group := &sync.WaitGroup{}
+
+	supervisor.stateLock.Lock()
+	supervisor.cancel = cancel
+	supervisor.done = done
+	supervisor.stateLock.Unlock()
+
+	group.Add(len(supervisor.workers))
+	for _, worker := range supervisor.workers {
+		go func(worker supervisedWorker) {
+			defer group.Done()
+			worker.run(ctx)
+			log.Printf("[DEBUG] workerSupervisor - %s exited\n", worker.name)
+		}(worker)
+	}
+
+	//Mark the generation stopped once every worker has returned, even if Stop timed out waiting
+	go func() {
+		group.Wait()
+		supervisor.stateLock.Lock()
+		if supervisor.done == done {
+			supervisor.state = workersStopped
+			supervisor.cancel = nil
+		}
+		supervisor.stateLock.Unlock()
+		close(done)
+	}()
+
+	supervisor.stateLock.Lock()
+	if supervisor.done == done && supervisor.state == workersStarting {
+		supervisor.state = workersRunning
+	}
+	supervisor.stateLock.Unlock()
+
+	log.Printf("[DEBUG] workerSupervisor.Start - Started %d workers\n", len(supervisor.workers))
+	return true
+}
+
+//Stop : Cancels the workers and waits up to timeout for them to return. Stopping workers
+//that are not running is a no-op. Returns errWorkersStopTimeout if the workers are still
+//running when the timeout expires; they are left in the stopping state until they exit.
+func (supervisor *workerSupervisor) Stop(timeout time.Duration) error {
+	supervisor.transitionLock.Lock()
+	defer supervisor.transitionLock.Unlock()
+
+	supervisor.stateLock.Lock()
+	if supervisor.state == workersStopped {
+		supervisor.stateLock.Unlock()
+		log.Println("[DEBUG] workerSupervisor.Stop - Workers already stopped")
+		return nil
+	}
+	log.Printf("[DEBUG] workerSupervisor - Workers %s -> %s\n", supervisor.state, workersStopping)
+	supervisor.state = workersStopping
+	cancel, done := supervisor.cancel, supervisor.done
+	supervisor.stateLock.Unlock()
+
+	if cancel != nil {
+		cancel()
+	}
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return nil
+	case <-timer.C:
+		log.Printf("[WARN] workerSupervisor.Stop - Workers still running after %s\n", timeout)
+		return errWorkersStopTimeout
+	}
+}
@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	concurrentCallers    = 8
+	concurrentIterations = 200
+	deadlockTimeout      = 30 * time.Second
+)
+
+func TestWorkerSupervisorConcurrentStartStop(t *testing.T) {
+	fast, slow := &workerCounter{}, &workerCounter{}
+	supervisor := newWorkerSupervisor(
+		fast.wrap(supervisedWorker{name: "fast", run: func(ctx context.Context) { <-ctx.Done() }}),
+		//Exits after the Stop timeout below, so Start finds the previous workers still stopping
+		slow.wrap(supervisedWorker{name: "slow", run: func(ctx context.Context) {
+			<-ctx.Done()
+			time.Sleep(2 * time.Millisecond)
+		}}),
+	)
+
+	group := &sync.WaitGroup{}
+	for caller := 0; caller < concurrentCallers; caller++ {
+		group.Add(1)
+		go func(caller int) {
+			defer group.Done()
+			for i := 0; i < concurrentIterations; i++ {
+				if (caller+i)%2 == 0 {
+					supervisor.Start(context.Background())
+				} else {
+					supervisor.Stop(time.Millisecond)
+				}
+				supervisor.State()
+			}
+		}(caller)
+	}
+	waitForGroup(t, deadlockTimeout, group)
+
+	if err := supervisor.Stop(5 * time.Second); err != nil {
+		t.Fatalf("Stop returned %s", err.Error())
+	}
+	if state := supervisor.State(); state != workersStopped {
+		t.Errorf("State is %s after Stop, expected stopped", state)
+	}
+	for name, counter := range map[string]*workerCounter{"fast": fast, "slow": slow} {
+		if peak := counter.Peak(); peak != 1 {
+			t.Errorf("%d %s workers ran at once, expected 1", peak, name)
+		}
+	}
+}
+
+func TestWorkerSupervisorStopWhileStartWaits(t *testing.T) {
+	release := make(chan struct{})
+	supervisor := newWorkerSupervisor(supervisedWorker{name: "stuck", run: func(ctx context.Context) { <-release }})
+
+	if !supervisor.Start(context.Background()) {
+		t.Fatal("Start returned false for stopped workers")
+	}
+	if err := supervisor.Stop(time.Millisecond); err != errWorkersStopTimeout {
+		t.Fatalf("Stop returned %v, expected %s", err, errWorkersStopTimeout)
+	}
+
+	started := make(chan bool)
+	go func() {
+		started <- supervisor.Start(context.Background())
+	}()
+
+	//Start waits for the stuck worker without holding up Stop
+	time.Sleep(20 * time.Millisecond)
+	stopped := make(chan error)
+	go func() {
+		stopped <- supervisor.Stop(time.Millisecond)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Stop blocked while Start was waiting for the previous workers")
+	}
+
+	close(release)
+	select {
+	case <-started:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start did not return once the previous workers exited")
+	}
+	supervisor.Stop(time.Second)
+}
+
+func TestWorkerSupervisorStartCancelledWhileWaiting(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	supervisor := newWorkerSupervisor(supervisedWorker{name: "stuck", run: func(ctx context.Context) { <-release }})
+
+	supervisor.Start(context.Background())
+	supervisor.Stop(time.Millisecond)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	started := make(chan bool)
+	go func() {
+		started <- supervisor.Start(ctx)
+	}()
+	select {
+	case ok := <-started:
+		if ok {
+			t.Error("Start started workers after its context was done")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Start kept waiting for the previous workers after its context was done")
+	}
+	if state := supervisor.State(); state != workersStopping {
+		t.Errorf("State is %s, expected stopping", state)
+	}
+}
+
+func TestConnectionCallbacksConcurrently(t *testing.T) {
+	adapter := startTestAdapter(t)
+
+	group := &sync.WaitGroup{}
+	for caller := 0; caller < concurrentCallers; caller++ {
+		group.Add(1)
+		go func(caller int) {
+			defer group.Done()
+			for i := 0; i < concurrentIterations/10; i++ {
+				switch (caller + i) % 4 {
+				case 0:
+					OnConnect()
+				case 1:
+					OnConnectLost(errors.New("connection reset by peer"))
+				case 2:
+					workers.Start(adapterContext)
+				case 3:
+					workers.Stop(time.Duration(shutdownTimeout) * time.Second)
+				}
+			}
+		}(caller)
+	}
+	waitForGroup(t, deadlockTimeout, group)
+
+	//Leave the adapter connected for the other tests
+	OnConnect()
+	if state := workers.State(); state != workersRunning {
+		t.Errorf("Workers are %s after OnConnect, expected running", state)
+	}
+	for name, counter := range adapter.counters {
+		if peak := counter.Peak(); peak != 1 {
+			t.Errorf("%d instances of %s ran at once, expected 1", peak, name)
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+//Device level delivery confirmation modes of a write request
+const (
+	waitForNone = "none"
+	waitForEcho = "echo"
+	waitForAck  = "ack"
+
+	defaultAckByte     = 0x06 //ASCII ACK
+	defaultWaitTimeout = 1000 //milliseconds
+)
+
+//writeAckOptions : How a write is confirmed at the device level
+type writeAckOptions struct {
+	waitFor string
+	ackByte byte
+	timeout time.Duration
+}
+
+//writeResult : Outcome of a write request published to send/response
+type writeResult struct {
+	RequestID    string `json:"requestId"`
+	BytesWritten int    `json:"bytesWritten"`
+	DurationMs   int64  `json:"durationMs"`
+	Success      bool   `json:"success"`
+	Confirmed    bool   `json:"confirmed,omitempty"`
+	Error        string `json:"error,omitempty"`
+}
+
+//newWriteResult : The result of writing request, started at started. confirmed is true if the
+//device echoed the payload or sent the ACK byte.
+func newWriteResult(request *writeRequest, written int, confirmed bool, started time.Time, err error) writeResult {
+	result := writeResult{
+		RequestID:    request.requestID,
+		BytesWritten: written,
+		DurationMs:   int64(time.Since(started) / time.Millisecond),
+		Success:      err == nil,
+		Confirmed:    err == nil && confirmed,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	return result
+}
+
+func defaultWriteAckOptions() writeAckOptions {
+	return writeAckOptions{
+		waitFor: waitForNone,
+		ackByte: defaultAckByte,
+		timeout: defaultWaitTimeout * time.Millisecond,
+	}
+}
+
+//loadWriteAckSettings : Reads the default acknowledgement options from the "writeAck" adapter setting
+//
+// "writeAck": {"waitFor": "ack", "ackByte": 6, "timeout": 1000}
+func loadWriteAckSettings(adapterSettings map[string]interface{}) writeAckOptions {
+	ack := defaultWriteAckOptions()
+
+	ackJSON, ok := adapterSettings["writeAck"].(map[string]interface{})
+	if !ok {
+		return ack
+	}
+
+	var waitFor *string
+	if value, ok := ackJSON["waitFor"].(string); ok {
+		waitFor = &value
+	}
+	var ackByte, timeout *float64
+	if value, ok := ackJSON["ackByte"].(float64); ok {
+		ackByte = &value
+	}
+	if value, ok := ackJSON["timeout"].(float64); ok {
+		timeout = &value
+	}
+
+	configured, err := ack.override(waitFor, ackByte, timeout)
+	if err != nil {
+		log.Printf("[WARN] loadWriteAckSettings - Invalid writeAck setting, writes will not be confirmed: %s\n", err.Error())
+		return ack
+	}
+
+	log.Printf("[INFO] loadWriteAckSettings - Writes wait for %s, ackByte = 0x%02x, timeout = %s\n", configured.waitFor, configured.ackByte, configured.timeout.String())
+	return configured
+}
+
+//override : Returns a copy of the options with the specified values replaced
+func (ack writeAckOptions) override(waitFor *string, ackByte *float64, timeout *float64) (writeAckOptions, error) {
+	if waitFor != nil {
+		switch *waitFor {
+		case waitForNone, waitForEcho, waitForAck:
+			ack.waitFor = *waitFor
+		case "":
+			ack.waitFor = waitForNone
+		default:
+			return ack, fmt.Errorf("Invalid waitFor %s, expected %s, %s or %s", *waitFor, waitForNone, waitForEcho, waitForAck)
+		}
+	}
+
+	if ackByte != nil {
+		if *ackByte < 0 || *ackByte > 255 || *ackByte != float64(int(*ackByte)) {
+			return ack, fmt.Errorf("Invalid ackByte %v, expected 0-255", *ackByte)
+		}
+		ack.ackByte = byte(*ackByte)
+	}
+
+	if timeout != nil {
+		if *timeout <= 0 {
+			return ack, fmt.Errorf("Invalid timeout %v, expected a positive number of milliseconds", *timeout)
+		}
+		ack.timeout = time.Duration(*timeout) * time.Millisecond
+	}
+
+	return ack, nil
+}
+
+//waitForConfirmation : Reads from the serial port until the device echoes the payload or sends the
+//ACK byte. Must be called while holding serialPortLock so the read worker does not consume the
+//confirmation. Returns true if the confirmation was read, along with the data the device sent
+//before and after it, which the caller publishes as read data once the lock is released.
+func waitForConfirmation(ctx context.Context, request *writeRequest) (bool, []string, error) {
+	var match string
+	switch request.ack.waitFor {
+	case waitForEcho:
+		match = request.payload
+	case waitForAck:
+		match = string([]byte{request.ack.ackByte})
+	default:
+		return false, nil, nil
+	}
+
+	//Nothing is echoed for an empty payload
+	if match == "" {
+		return false, nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, request.ack.timeout)
+	defer cancel()
+
+	before, after, err := serialPort.ReadUntil(ctx, match)
+	if err != nil {
+		log.Printf("[WARN] waitForConfirmation - No %s received for request %s: %s\n", request.ack.waitFor, request.requestID, err.Error())
+		return false, []string{before}, errors.New("No " + request.ack.waitFor + " received from the device: " + err.Error())
+	}
+	return true, []string{before, after}, nil
+}
@@ -0,0 +1,278 @@
+package main
+
+import (
+	"container/heap"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWriteQueueSize = 100
+	defaultWriteSpacing   = 0 //milliseconds
+)
+
+var (
+	errWriteQueueFull = errors.New("Write queue is full")
+	errWriteDiscarded = errors.New("The adapter stopped before the request was written")
+)
+
+//writeRequest : A payload waiting to be written to the serial port
+type writeRequest struct {
+	payload   string
+	command   interface{}
+	priority  int
+	requestID string
+	ack       writeAckOptions
+	sequence  uint64
+	enqueued  time.Time
+
+	//Receives the result instead of the send/response topic when set (writes requested over the REST API)
+	done chan writeResult
+}
+
+//writeEnvelope : JSON form of a send/request payload, used when the "envelope" attribute of the
+//"writeQueue" adapter setting is true
+//
+// {"data": "AT\r\n", "priority": 10, "requestId": "abc", "waitFor": "ack", "ackByte": 6, "timeout": 500}
+// {"command": {"setpoint": 21.5}, "requestId": "abc"}
+type writeEnvelope struct {
+	Data      *string     `json:"data"`
+	Command   interface{} `json:"command"`
+	Priority  int         `json:"priority"`
+	RequestID string      `json:"requestId"`
+	WaitFor   *string     `json:"waitFor"`
+	AckByte   *float64    `json:"ackByte"`
+	Timeout   *float64    `json:"timeout"`
+}
+
+var writeEnvelopeAttributes = map[string]bool{"data": true, "command": true, "priority": true, "requestId": true, "waitFor": true, "ackByte": true, "timeout": true}
+
+//parseWriteRequest : When unwrapEnvelope is true, payloads that are a JSON object with a string "data"
+//attribute or a "command" attribute and only the attributes of writeEnvelope are unwrapped. Any other
+//JSON object is kept as the command so the payload transform can encode it. Everything else is written
+//unchanged using the default acknowledgement options. An error is returned for an invalid envelope.
+func parseWriteRequest(payload []byte, defaultAck writeAckOptions, unwrapEnvelope bool) (*writeRequest, error) {
+	request := &writeRequest{payload: string(payload), ack: defaultAck}
+
+	var attributes map[string]interface{}
+	if err := json.Unmarshal(payload, &attributes); err != nil {
+		return request, nil
+	}
+	if !unwrapEnvelope {
+		request.command = attributes
+		return request, nil
+	}
+	for name := range attributes {
+		if !writeEnvelopeAttributes[name] {
+			request.command = attributes
+			return request, nil
+		}
+	}
+
+	envelope := writeEnvelope{}
+	if err := json.Unmarshal(payload, &envelope); err != nil || (envelope.Data == nil && envelope.Command == nil) {
+		request.command = attributes
+		return request, nil
+	}
+
+	request.priority = envelope.Priority
+	request.requestID = envelope.RequestID
+	if envelope.Data != nil {
+		if envelope.Command != nil {
+			return request, errors.New("data and command cannot both be specified")
+		}
+		request.payload = *envelope.Data
+	} else {
+		request.payload = ""
+		request.command = envelope.Command
+	}
+
+	ack, err := request.ack.override(envelope.WaitFor, envelope.AckByte, envelope.Timeout)
+	if err != nil {
+		return request, err
+	}
+	request.ack = ack
+	return request, nil
+}
+
+//writeRequestHeap : Highest priority first, first in first out within a priority
+type writeRequestHeap []*writeRequest
+
+func (requests writeRequestHeap) Len() int { return len(requests) }
+
+func (requests writeRequestHeap) Less(i, j int) bool {
+	if requests[i].priority != requests[j].priority {
+		return requests[i].priority > requests[j].priority
+	}
+	return requests[i].sequence < requests[j].sequence
+}
+
+func (requests writeRequestHeap) Swap(i, j int) { requests[i], requests[j] = requests[j], requests[i] }
+
+func (requests *writeRequestHeap) Push(request interface{}) {
+	*requests = append(*requests, request.(*writeRequest))
+}
+
+func (requests *writeRequestHeap) Pop() interface{} {
+	old := *requests
+	request := old[len(old)-1]
+	old[len(old)-1] = nil
+	*requests = old[:len(old)-1]
+	return request
+}
+
+//writeQueueMetrics : Queue statistics published with the health status
+type writeQueueMetrics struct {
+	Depth    int   `json:"depth"`
+	Capacity int   `json:"capacity"`
+	MaxDepth int   `json:"maxDepth"`
+	Enqueued int64 `json:"enqueued"`
+	Rejected int64 `json:"rejected"`
+	Written  int64 `json:"written"`
+	Failed   int64 `json:"failed"`
+}
+
+//writeQueue : Bounded priority queue of serial port writes. Requests that are still queued when
+//the connection to the broker is lost are written once the workers are restarted. Requests still
+//queued when the adapter stops are discarded with a failure result.
+type writeQueue struct {
+	lock     sync.Mutex
+	requests writeRequestHeap
+	sequence uint64
+	metrics  writeQueueMetrics
+	spacing  time.Duration
+
+	//Unwrap send/request payloads that are a writeEnvelope
+	envelope bool
+
+	//Signaled when a request is added
+	ready chan struct{}
+}
+
+func newWriteQueue(capacity int, spacing time.Duration) *writeQueue {
+	if capacity <= 0 {
+		capacity = defaultWriteQueueSize
+	}
+	return &writeQueue{
+		spacing: spacing,
+		metrics: writeQueueMetrics{Capacity: capacity},
+		ready:   make(chan struct{}, 1),
+	}
+}
+
+//loadWriteQueueSettings : Creates the write queue from the "writeQueue" adapter setting
+//
+// "writeQueue": {"size": 100, "spacing": 50, "envelope": true}
+func loadWriteQueueSettings(adapterSettings map[string]interface{}) *writeQueue {
+	size := defaultWriteQueueSize
+	spacing := defaultWriteSpacing
+	envelope := false
+
+	if queueJSON, ok := adapterSettings["writeQueue"].(map[string]interface{}); ok {
+		if value, ok := queueJSON["size"].(float64); ok && value > 0 {
+			size = int(value)
+		}
+		if value, ok := queueJSON["spacing"].(float64); ok && value >= 0 {
+			spacing = int(value)
+		}
+		if value, ok := queueJSON["envelope"].(bool); ok {
+			envelope = value
+		}
+	}
+
+	log.Printf("[INFO] loadWriteQueueSettings - Write queue size = %d, spacing = %dms, envelope = %t\n", size, spacing, envelope)
+	queue := newWriteQueue(size, time.Duration(spacing)*time.Millisecond)
+	queue.envelope = envelope
+	return queue
+}
+
+//Push : Adds a request to the queue. Returns errWriteQueueFull when the queue is at capacity.
+func (queue *writeQueue) Push(request *writeRequest) error {
+	queue.lock.Lock()
+	if len(queue.requests) >= queue.metrics.Capacity {
+		queue.metrics.Rejected++
+		queue.lock.Unlock()
+		return errWriteQueueFull
+	}
+
+	queue.sequence++
+	request.sequence = queue.sequence
+	request.enqueued = time.Now()
+	heap.Push(&queue.requests, request)
+
+	queue.metrics.Enqueued++
+	if len(queue.requests) > queue.metrics.MaxDepth {
+		queue.metrics.MaxDepth = len(queue.requests)
+	}
+	queue.lock.Unlock()
+
+	select {
+	case queue.ready <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+//Pop : Removes the highest priority request, returns nil when the queue is empty
+func (queue *writeQueue) Pop() *writeRequest {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	if len(queue.requests) == 0 {
+		return nil
+	}
+	return heap.Pop(&queue.requests).(*writeRequest)
+}
+
+//Discard : Removes every queued request, returning them in priority order
+func (queue *writeQueue) Discard() []*writeRequest {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	discarded := []*writeRequest{}
+	for len(queue.requests) > 0 {
+		discarded = append(discarded, heap.Pop(&queue.requests).(*writeRequest))
+	}
+	return discarded
+}
+
+//Next : Waits for a request. Returns nil once ctx is done.
+func (queue *writeQueue) Next(ctx context.Context) *writeRequest {
+	for ctx.Err() == nil {
+		if request := queue.Pop(); request != nil {
+			return request
+		}
+
+		select {
+		case <-queue.ready:
+		case <-ctx.Done():
+		}
+	}
+	return nil
+}
+
+//Completed : Records the result of a write
+func (queue *writeQueue) Completed(err error) {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	if err != nil {
+		queue.metrics.Failed++
+	} else {
+		queue.metrics.Written++
+	}
+}
+
+//Metrics : A snapshot of the queue statistics
+func (queue *writeQueue) Metrics() writeQueueMetrics {
+	queue.lock.Lock()
+	defer queue.lock.Unlock()
+
+	metrics := queue.metrics
+	metrics.Depth = len(queue.requests)
+	return metrics
+}